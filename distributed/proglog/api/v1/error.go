@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
 )
 
@@ -28,4 +29,106 @@ func (e ErrOffsetOutOfRange) GRPCStatus() *status.Status {
 
 func (e ErrOffsetOutOfRange) Error() string {
 	return e.GRPCStatus().Err().Error()
-}
\ No newline at end of file
+}
+// 리더에서만 처리할 수 있는 요청을 팔로워가 받았을 때 리턴한다.
+// 클라이언트는 Leader 주소로 다시 요청하거나, 서버 목록을 새로 받아야 한다.
+type ErrNotLeader struct {
+	Leader string
+}
+
+const notLeaderReason = "NOT_LEADER"
+
+func (e ErrNotLeader) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("not the leader, current leader: %q", e.Leader))
+	d := &errdetails.ErrorInfo{
+		Reason:   notLeaderReason,
+		Domain:   "proglog",
+		Metadata: map[string]string{"leader": e.Leader},
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrNotLeader) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// gRPC 를 거친 에러가 ErrNotLeader 에서 온 것인지 확인한다.
+func IsNotLeader(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition {
+		return false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Reason == notLeaderReason {
+			return true
+		}
+	}
+	return false
+}
+
+// 프로듀서가 보낸 sequence 가 기대한 값이 아닐 때 리턴한다.
+// 앞선 요청이 빠졌거나, 중복을 확인할 수 없을 만큼 오래된 요청이다.
+type ErrOutOfOrderSequence struct {
+	ProducerId string
+	Expected   uint64
+	Sequence   uint64
+}
+
+const outOfOrderSequenceReason = "OUT_OF_ORDER_SEQUENCE"
+
+func (e ErrOutOfOrderSequence) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf(
+		"out of order sequence for producer %q: expected %d, got %d",
+		e.ProducerId, e.Expected, e.Sequence,
+	))
+	d := &errdetails.ErrorInfo{
+		Reason: outOfOrderSequenceReason,
+		Domain: "proglog",
+		Metadata: map[string]string{
+			"producer_id": e.ProducerId,
+			"expected":    fmt.Sprint(e.Expected),
+			"sequence":    fmt.Sprint(e.Sequence),
+		},
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrOutOfOrderSequence) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
+
+// 열려 있지 않은 트랜잭션에 레코드를 추가하거나 끝내려고 할 때 리턴한다.
+type ErrTxnNotOpen struct {
+	TxnId uint64
+}
+
+const txnNotOpenReason = "TXN_NOT_OPEN"
+
+func (e ErrTxnNotOpen) GRPCStatus() *status.Status {
+	st := status.New(codes.FailedPrecondition, fmt.Sprintf("transaction is not open: %d", e.TxnId))
+	d := &errdetails.ErrorInfo{
+		Reason:   txnNotOpenReason,
+		Domain:   "proglog",
+		Metadata: map[string]string{"txn_id": fmt.Sprint(e.TxnId)},
+	}
+
+	std, err := st.WithDetails(d)
+	if err != nil {
+		return st
+	}
+	return std
+}
+
+func (e ErrTxnNotOpen) Error() string {
+	return e.GRPCStatus().Err().Error()
+}
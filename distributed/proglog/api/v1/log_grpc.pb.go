@@ -24,6 +24,12 @@ const (
 	Log_ConsumeStream_FullMethodName = "/log.v1.Log/ConsumeStream"
 	Log_ProduceStream_FullMethodName = "/log.v1.Log/ProduceStream"
 	Log_GetServers_FullMethodName    = "/log.v1.Log/GetServers"
+	Log_WatchCluster_FullMethodName  = "/log.v1.Log/WatchCluster"
+	Log_GetOffsets_FullMethodName    = "/log.v1.Log/GetOffsets"
+	Log_BeginTxn_FullMethodName      = "/log.v1.Log/BeginTxn"
+	Log_AppendTxn_FullMethodName     = "/log.v1.Log/AppendTxn"
+	Log_CommitTxn_FullMethodName     = "/log.v1.Log/CommitTxn"
+	Log_AbortTxn_FullMethodName      = "/log.v1.Log/AbortTxn"
 )
 
 // LogClient is the client API for Log service.
@@ -35,6 +41,13 @@ type LogClient interface {
 	ConsumeStream(ctx context.Context, in *ConsumeRequest, opts ...grpc.CallOption) (Log_ConsumeStreamClient, error)
 	ProduceStream(ctx context.Context, opts ...grpc.CallOption) (Log_ProduceStreamClient, error)
 	GetServers(ctx context.Context, in *GetServersRequest, opts ...grpc.CallOption) (*GetServersResponse, error)
+	WatchCluster(ctx context.Context, in *WatchClusterRequest, opts ...grpc.CallOption) (Log_WatchClusterClient, error)
+	GetOffsets(ctx context.Context, in *GetOffsetsRequest, opts ...grpc.CallOption) (*GetOffsetsResponse, error)
+	// 트랜잭션, 여러 레코드를 모두 추가하거나 하나도 추가하지 않는다. (read_committed 로 읽는 컨슈머 기준)
+	BeginTxn(ctx context.Context, in *BeginTxnRequest, opts ...grpc.CallOption) (*BeginTxnResponse, error)
+	AppendTxn(ctx context.Context, in *AppendTxnRequest, opts ...grpc.CallOption) (*AppendTxnResponse, error)
+	CommitTxn(ctx context.Context, in *CommitTxnRequest, opts ...grpc.CallOption) (*CommitTxnResponse, error)
+	AbortTxn(ctx context.Context, in *AbortTxnRequest, opts ...grpc.CallOption) (*AbortTxnResponse, error)
 }
 
 type logClient struct {
@@ -135,6 +148,83 @@ func (c *logClient) GetServers(ctx context.Context, in *GetServersRequest, opts
 	return out, nil
 }
 
+func (c *logClient) WatchCluster(ctx context.Context, in *WatchClusterRequest, opts ...grpc.CallOption) (Log_WatchClusterClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Log_ServiceDesc.Streams[2], Log_WatchCluster_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &logWatchClusterClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Log_WatchClusterClient interface {
+	Recv() (*ClusterEvent, error)
+	grpc.ClientStream
+}
+
+type logWatchClusterClient struct {
+	grpc.ClientStream
+}
+
+func (x *logWatchClusterClient) Recv() (*ClusterEvent, error) {
+	m := new(ClusterEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *logClient) GetOffsets(ctx context.Context, in *GetOffsetsRequest, opts ...grpc.CallOption) (*GetOffsetsResponse, error) {
+	out := new(GetOffsetsResponse)
+	err := c.cc.Invoke(ctx, Log_GetOffsets_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) BeginTxn(ctx context.Context, in *BeginTxnRequest, opts ...grpc.CallOption) (*BeginTxnResponse, error) {
+	out := new(BeginTxnResponse)
+	err := c.cc.Invoke(ctx, Log_BeginTxn_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) AppendTxn(ctx context.Context, in *AppendTxnRequest, opts ...grpc.CallOption) (*AppendTxnResponse, error) {
+	out := new(AppendTxnResponse)
+	err := c.cc.Invoke(ctx, Log_AppendTxn_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) CommitTxn(ctx context.Context, in *CommitTxnRequest, opts ...grpc.CallOption) (*CommitTxnResponse, error) {
+	out := new(CommitTxnResponse)
+	err := c.cc.Invoke(ctx, Log_CommitTxn_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *logClient) AbortTxn(ctx context.Context, in *AbortTxnRequest, opts ...grpc.CallOption) (*AbortTxnResponse, error) {
+	out := new(AbortTxnResponse)
+	err := c.cc.Invoke(ctx, Log_AbortTxn_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LogServer is the server API for Log service.
 // All implementations must embed UnimplementedLogServer
 // for forward compatibility
@@ -144,6 +234,13 @@ type LogServer interface {
 	ConsumeStream(*ConsumeRequest, Log_ConsumeStreamServer) error
 	ProduceStream(Log_ProduceStreamServer) error
 	GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error)
+	WatchCluster(*WatchClusterRequest, Log_WatchClusterServer) error
+	GetOffsets(context.Context, *GetOffsetsRequest) (*GetOffsetsResponse, error)
+	// 트랜잭션, 여러 레코드를 모두 추가하거나 하나도 추가하지 않는다. (read_committed 로 읽는 컨슈머 기준)
+	BeginTxn(context.Context, *BeginTxnRequest) (*BeginTxnResponse, error)
+	AppendTxn(context.Context, *AppendTxnRequest) (*AppendTxnResponse, error)
+	CommitTxn(context.Context, *CommitTxnRequest) (*CommitTxnResponse, error)
+	AbortTxn(context.Context, *AbortTxnRequest) (*AbortTxnResponse, error)
 	mustEmbedUnimplementedLogServer()
 }
 
@@ -166,6 +263,24 @@ func (UnimplementedLogServer) ProduceStream(Log_ProduceStreamServer) error {
 func (UnimplementedLogServer) GetServers(context.Context, *GetServersRequest) (*GetServersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetServers not implemented")
 }
+func (UnimplementedLogServer) WatchCluster(*WatchClusterRequest, Log_WatchClusterServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchCluster not implemented")
+}
+func (UnimplementedLogServer) GetOffsets(context.Context, *GetOffsetsRequest) (*GetOffsetsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetOffsets not implemented")
+}
+func (UnimplementedLogServer) BeginTxn(context.Context, *BeginTxnRequest) (*BeginTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BeginTxn not implemented")
+}
+func (UnimplementedLogServer) AppendTxn(context.Context, *AppendTxnRequest) (*AppendTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AppendTxn not implemented")
+}
+func (UnimplementedLogServer) CommitTxn(context.Context, *CommitTxnRequest) (*CommitTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CommitTxn not implemented")
+}
+func (UnimplementedLogServer) AbortTxn(context.Context, *AbortTxnRequest) (*AbortTxnResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AbortTxn not implemented")
+}
 func (UnimplementedLogServer) mustEmbedUnimplementedLogServer() {}
 
 // UnsafeLogServer may be embedded to opt out of forward compatibility for this service.
@@ -280,6 +395,117 @@ func _Log_GetServers_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Log_WatchCluster_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchClusterRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LogServer).WatchCluster(m, &logWatchClusterServer{stream})
+}
+
+type Log_WatchClusterServer interface {
+	Send(*ClusterEvent) error
+	grpc.ServerStream
+}
+
+type logWatchClusterServer struct {
+	grpc.ServerStream
+}
+
+func (x *logWatchClusterServer) Send(m *ClusterEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Log_GetOffsets_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetOffsetsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).GetOffsets(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_GetOffsets_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).GetOffsets(ctx, req.(*GetOffsetsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_BeginTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).BeginTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_BeginTxn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).BeginTxn(ctx, req.(*BeginTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_AppendTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AppendTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).AppendTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_AppendTxn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).AppendTxn(ctx, req.(*AppendTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_CommitTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).CommitTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_CommitTxn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).CommitTxn(ctx, req.(*CommitTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Log_AbortTxn_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AbortTxnRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LogServer).AbortTxn(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Log_AbortTxn_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LogServer).AbortTxn(ctx, req.(*AbortTxnRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Log_ServiceDesc is the grpc.ServiceDesc for Log service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -299,6 +525,26 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetServers",
 			Handler:    _Log_GetServers_Handler,
 		},
+		{
+			MethodName: "GetOffsets",
+			Handler:    _Log_GetOffsets_Handler,
+		},
+		{
+			MethodName: "BeginTxn",
+			Handler:    _Log_BeginTxn_Handler,
+		},
+		{
+			MethodName: "AppendTxn",
+			Handler:    _Log_AppendTxn_Handler,
+		},
+		{
+			MethodName: "CommitTxn",
+			Handler:    _Log_CommitTxn_Handler,
+		},
+		{
+			MethodName: "AbortTxn",
+			Handler:    _Log_AbortTxn_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -312,6 +558,288 @@ var Log_ServiceDesc = grpc.ServiceDesc{
 			ServerStreams: true,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "WatchCluster",
+			Handler:       _Log_WatchCluster_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/v1/log.proto",
+}
+
+const (
+	Admin_AddServer_FullMethodName          = "/log.v1.Admin/AddServer"
+	Admin_RemoveServer_FullMethodName       = "/log.v1.Admin/RemoveServer"
+	Admin_TransferLeadership_FullMethodName = "/log.v1.Admin/TransferLeadership"
+	Admin_GetRaftStats_FullMethodName       = "/log.v1.Admin/GetRaftStats"
+	Admin_TriggerSnapshot_FullMethodName    = "/log.v1.Admin/TriggerSnapshot"
+	Admin_ReloadACL_FullMethodName          = "/log.v1.Admin/ReloadACL"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*AddServerResponse, error)
+	RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*RemoveServerResponse, error)
+	TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error)
+	GetRaftStats(ctx context.Context, in *GetRaftStatsRequest, opts ...grpc.CallOption) (*GetRaftStatsResponse, error)
+	TriggerSnapshot(ctx context.Context, in *TriggerSnapshotRequest, opts ...grpc.CallOption) (*TriggerSnapshotResponse, error)
+	// ACL 모델과 정책 파일을 다시 읽는다. 이 서버에만 적용된다.
+	ReloadACL(ctx context.Context, in *ReloadACLRequest, opts ...grpc.CallOption) (*ReloadACLResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) AddServer(ctx context.Context, in *AddServerRequest, opts ...grpc.CallOption) (*AddServerResponse, error) {
+	out := new(AddServerResponse)
+	err := c.cc.Invoke(ctx, Admin_AddServer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveServer(ctx context.Context, in *RemoveServerRequest, opts ...grpc.CallOption) (*RemoveServerResponse, error) {
+	out := new(RemoveServerResponse)
+	err := c.cc.Invoke(ctx, Admin_RemoveServer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TransferLeadership(ctx context.Context, in *TransferLeadershipRequest, opts ...grpc.CallOption) (*TransferLeadershipResponse, error) {
+	out := new(TransferLeadershipResponse)
+	err := c.cc.Invoke(ctx, Admin_TransferLeadership_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetRaftStats(ctx context.Context, in *GetRaftStatsRequest, opts ...grpc.CallOption) (*GetRaftStatsResponse, error) {
+	out := new(GetRaftStatsResponse)
+	err := c.cc.Invoke(ctx, Admin_GetRaftStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) TriggerSnapshot(ctx context.Context, in *TriggerSnapshotRequest, opts ...grpc.CallOption) (*TriggerSnapshotResponse, error) {
+	out := new(TriggerSnapshotResponse)
+	err := c.cc.Invoke(ctx, Admin_TriggerSnapshot_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ReloadACL(ctx context.Context, in *ReloadACLRequest, opts ...grpc.CallOption) (*ReloadACLResponse, error) {
+	out := new(ReloadACLResponse)
+	err := c.cc.Invoke(ctx, Admin_ReloadACL_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	AddServer(context.Context, *AddServerRequest) (*AddServerResponse, error)
+	RemoveServer(context.Context, *RemoveServerRequest) (*RemoveServerResponse, error)
+	TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error)
+	GetRaftStats(context.Context, *GetRaftStatsRequest) (*GetRaftStatsResponse, error)
+	TriggerSnapshot(context.Context, *TriggerSnapshotRequest) (*TriggerSnapshotResponse, error)
+	// ACL 모델과 정책 파일을 다시 읽는다. 이 서버에만 적용된다.
+	ReloadACL(context.Context, *ReloadACLRequest) (*ReloadACLResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) AddServer(context.Context, *AddServerRequest) (*AddServerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddServer not implemented")
+}
+func (UnimplementedAdminServer) RemoveServer(context.Context, *RemoveServerRequest) (*RemoveServerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveServer not implemented")
+}
+func (UnimplementedAdminServer) TransferLeadership(context.Context, *TransferLeadershipRequest) (*TransferLeadershipResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TransferLeadership not implemented")
+}
+func (UnimplementedAdminServer) GetRaftStats(context.Context, *GetRaftStatsRequest) (*GetRaftStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRaftStats not implemented")
+}
+func (UnimplementedAdminServer) TriggerSnapshot(context.Context, *TriggerSnapshotRequest) (*TriggerSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method TriggerSnapshot not implemented")
+}
+func (UnimplementedAdminServer) ReloadACL(context.Context, *ReloadACLRequest) (*ReloadACLResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReloadACL not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_AddServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_AddServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddServer(ctx, req.(*AddServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveServer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveServerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveServer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemoveServer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveServer(ctx, req.(*RemoveServerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TransferLeadership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TransferLeadershipRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TransferLeadership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_TransferLeadership_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TransferLeadership(ctx, req.(*TransferLeadershipRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetRaftStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRaftStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetRaftStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetRaftStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetRaftStats(ctx, req.(*GetRaftStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_TriggerSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TriggerSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).TriggerSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_TriggerSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).TriggerSnapshot(ctx, req.(*TriggerSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ReloadACL_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadACLRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ReloadACL(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ReloadACL_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ReloadACL(ctx, req.(*ReloadACLRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "log.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AddServer",
+			Handler:    _Admin_AddServer_Handler,
+		},
+		{
+			MethodName: "RemoveServer",
+			Handler:    _Admin_RemoveServer_Handler,
+		},
+		{
+			MethodName: "TransferLeadership",
+			Handler:    _Admin_TransferLeadership_Handler,
+		},
+		{
+			MethodName: "GetRaftStats",
+			Handler:    _Admin_GetRaftStats_Handler,
+		},
+		{
+			MethodName: "TriggerSnapshot",
+			Handler:    _Admin_TriggerSnapshot_Handler,
+		},
+		{
+			MethodName: "ReloadACL",
+			Handler:    _Admin_ReloadACL_Handler,
+		},
 	},
+	Streams:  []grpc.StreamDesc{},
 	Metadata: "api/v1/log.proto",
 }
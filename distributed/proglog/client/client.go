@@ -0,0 +1,125 @@
+/*
+proglog 클러스터에 접속하는 Go 클라이언트
+loadbalance 리졸버와 피커를 사용하므로, 쓰기는 리더로 읽기는 팔로워로 보내고 리더가 바뀌면 서버 목록을 다시 받는다.
+
+	c, err := client.New(client.Config{Addrs: []string{"127.0.0.1:8400"}, TLSConfig: tlsConfig})
+	p := c.NewProducer(client.ProducerConfig{})
+	p.Produce(ctx, []byte("hello"))
+	p.Close()
+	cs := c.NewConsumer(client.ConsumerConfig{Offset: 0})
+	for record := range cs.Records() { ... }
+*/
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/loadbalance"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type Config struct {
+	// 시드 서버의 RPC 주소, 리졸버가 이 중 응답하는 서버에서 클러스터의 서버 목록을 받는다.
+	Addrs []string
+	// nil 이면 TLS 없이 연결한다. 서버가 여러 대면 ServerName 을 지정해야 한다.
+	TLSConfig *tls.Config
+	// 있으면 요청마다 bearer 토큰으로 보낸다. (API 키나 JWT) TLS 로 연결할 때만 보낸다.
+	Token string
+	// 추가로 넘길 gRPC 옵션
+	DialOptions []grpc.DialOption
+}
+
+type Client struct {
+	conn   *grpc.ClientConn
+	log    api.LogClient
+	logger *zap.Logger
+}
+
+func New(config Config) (*Client, error) {
+	if len(config.Addrs) == 0 {
+		return nil, errors.New("client: no server address")
+	}
+
+	creds := insecure.NewCredentials()
+	if config.TLSConfig != nil {
+		creds = credentials.NewTLS(config.TLSConfig)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if config.Token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials(config.Token)))
+	}
+	opts = append(opts, config.DialOptions...)
+
+	target := fmt.Sprintf("%s:///%s", loadbalance.Name, strings.Join(config.Addrs, ","))
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:   conn,
+		log:    api.NewLogClient(conn),
+		logger: zap.L().Named("client"),
+	}, nil
+}
+
+// 클러스터의 서버 목록
+func (c *Client) Servers(ctx context.Context) ([]*api.Server, error) {
+	res, err := c.log.GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Servers, nil
+}
+
+// 읽을 수 있는 오프셋 범위 [lowest, next), topic 은 권한을 확인할 때만 쓴다.
+func (c *Client) Offsets(ctx context.Context, topic string) (lowest, next uint64, err error) {
+	res, err := c.log.GetOffsets(ctx, &api.GetOffsetsRequest{Topic: topic})
+	if err != nil {
+		return 0, 0, err
+	}
+	return res.LowestOffset, res.NextOffset, nil
+}
+
+// 오프셋의 레코드 하나를 읽는다. 토픽이 다르면 NotFound, 아직 없으면 api.ErrOffsetOutOfRange 를 리턴한다.
+func (c *Client) Read(ctx context.Context, req *api.ConsumeRequest) (*api.Record, error) {
+	res, err := c.log.Consume(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Record, nil
+}
+
+// 클라이언트로 만든 Producer, Consumer 를 먼저 닫아야 한다.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+/*
+다시 보내도 되는 에러인지 확인한다.
+리더가 아니라는 에러는 레코드를 추가하기 전에 거절한 것이다.
+Unavailable 은 이미 추가된 레코드일 수도 있지만, 프로듀서는 sequence 를 붙여 보내므로 서버가 중복을 걸러낸다.
+(서버의 CommitLog 가 server.IdempotentCommitLog 를 구현하지 않으면 중복될 수 있다.)
+*/
+func retryable(err error) bool {
+	return status.Code(err) == codes.Unavailable || api.IsNotLeader(err)
+}
+
+type tokenCredentials string
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
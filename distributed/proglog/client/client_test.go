@@ -0,0 +1,239 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/client"
+	"github.com/jhkim988/proglog/internal/auth"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/jhkim988/proglog/internal/log"
+	"github.com/jhkim988/proglog/internal/server"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestProduceConsume(t *testing.T) {
+	clog := &flakyLog{}
+	c, _, _ := setupTest(t, clog, "")
+
+	var mu sync.Mutex
+	var offsets []uint64
+	var errs []error
+	producer := c.NewProducer(client.ProducerConfig{
+		BatchSize: 4,
+		OnDelivery: func(d client.Delivery) {
+			mu.Lock()
+			defer mu.Unlock()
+			if d.Err != nil {
+				errs = append(errs, d.Err)
+				return
+			}
+			offsets = append(offsets, d.Offset)
+		},
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, producer.Produce(ctx, []byte(fmt.Sprintf("record-%d", i))))
+	}
+	require.NoError(t, producer.Flush(ctx))
+	mu.Lock()
+	require.Empty(t, errs)
+	require.Equal(t, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, offsets)
+	mu.Unlock()
+
+	// 리더가 아니라는 에러는 다시 보낸다.
+	clog.failNext(api.ErrNotLeader{})
+	offset, err := producer.ProduceSync(ctx, []byte("retried"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), offset)
+
+	// 끝내 실패한 배치가 있어도 다음 레코드는 sequence 가 건너뛰었다고 거절되지 않는다.
+	clog.failNext(errors.New("disk failure"))
+	_, err = producer.ProduceSync(ctx, []byte("failed"))
+	require.Error(t, err)
+	offset, err = producer.ProduceSync(ctx, []byte("after failure"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(11), offset)
+
+	lowest, next, err := c.Offsets(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), lowest)
+	require.Equal(t, uint64(12), next)
+
+	require.NoError(t, producer.Close())
+	require.Equal(t, client.ErrProducerClosed, producer.Produce(ctx, []byte("closed")))
+
+	// 처음부터 끝까지 순서대로 읽는다.
+	consumer := c.NewConsumer(client.ConsumerConfig{Offset: 5})
+	defer consumer.Close()
+	for want := uint64(5); want <= 11; want++ {
+		record := receive(t, consumer)
+		require.Equal(t, want, record.Offset)
+	}
+	require.Equal(t, uint64(12), consumer.Offset())
+}
+
+func TestConsumerReconnects(t *testing.T) {
+	clog := &flakyLog{}
+	c, addr, stop := setupTest(t, clog, "")
+
+	producer := c.NewProducer(client.ProducerConfig{})
+	defer producer.Close()
+	ctx := context.Background()
+	_, err := producer.ProduceSync(ctx, []byte("before"))
+	require.NoError(t, err)
+
+	consumer := c.NewConsumer(client.ConsumerConfig{})
+	defer consumer.Close()
+	require.Equal(t, uint64(0), receive(t, consumer).Offset)
+
+	// 서버를 다시 띄우면 마지막으로 받은 다음 오프셋부터 이어서 읽는다.
+	stop()
+	_, _, _ = setupTest(t, clog, addr)
+	_, err = producer.ProduceSync(ctx, []byte("after"))
+	require.NoError(t, err)
+
+	record := receive(t, consumer)
+	require.Equal(t, uint64(1), record.Offset)
+	require.Equal(t, []byte("after"), record.Value)
+	require.NoError(t, consumer.Err())
+}
+
+func receive(t *testing.T, consumer *client.Consumer) *api.Record {
+	t.Helper()
+	select {
+	case record, ok := <-consumer.Records():
+		require.True(t, ok, "consumer stopped: %v", consumer.Err())
+		return record
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for record")
+		return nil
+	}
+}
+
+// addr 이 비어 있으면 새 주소에 서버를 띄우고 클라이언트를 만든다.
+// addr 을 주면 같은 주소에 서버만 다시 띄운다.
+func setupTest(t *testing.T, clog *flakyLog, addr string) (*client.Client, string, func()) {
+	t.Helper()
+
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	addr = l.Addr().String()
+
+	if clog.Log == nil {
+		dir, err := os.MkdirTemp("", "client-test")
+		require.NoError(t, err)
+		clog.Log, err = log.NewLog(dir, log.Config{})
+		require.NoError(t, err)
+		t.Cleanup(func() { clog.Remove() })
+	}
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+		Server:        true,
+	})
+	require.NoError(t, err)
+	srv, err := server.NewGRPCServer(&server.Config{
+		CommitLog:   clog,
+		Authorizer:  auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		GetServerer: servers{addr},
+	}, grpc.Creds(credentials.NewTLS(serverTLSConfig)))
+	require.NoError(t, err)
+	go srv.Serve(l)
+
+	var once sync.Once
+	stop := func() { once.Do(srv.Stop) }
+	t.Cleanup(stop)
+
+	clientTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	c, err := client.New(client.Config{
+		Addrs:     []string{addr},
+		TLSConfig: clientTLSConfig,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+
+	return c, addr, stop
+}
+
+// Mock
+type servers struct {
+	addr string
+}
+
+func (s servers) GetServers() ([]*api.Server, error) {
+	return []*api.Server{{Id: "0", RpcAddr: s.addr, IsLeader: true}}, nil
+}
+
+// 지정한 에러로 다음 Append 를 한 번 실패한다.
+type flakyLog struct {
+	*log.Log
+	mu  sync.Mutex
+	err error
+	// 프로듀서별 마지막 sequence
+	sequences map[string]uint64
+}
+
+func (l *flakyLog) failNext(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+func (l *flakyLog) Append(record *api.Record) (uint64, error) {
+	if err := l.takeErr(); err != nil {
+		return 0, err
+	}
+	return l.Log.Append(record)
+}
+
+// 서버의 프로듀서 테이블처럼 처음 보는 프로듀서는 받고, 그다음부터 sequence 가 건너뛰면 거절한다.
+func (l *flakyLog) AppendIdempotent(record *api.Record, producerID string, sequence uint64) (uint64, error) {
+	if err := l.takeErr(); err != nil {
+		return 0, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sequences == nil {
+		l.sequences = make(map[string]uint64)
+	}
+	if last, ok := l.sequences[producerID]; ok && sequence != last+1 {
+		return 0, api.ErrOutOfOrderSequence{ProducerId: producerID, Expected: last + 1, Sequence: sequence}
+	}
+	offset, err := l.Log.Append(record)
+	if err != nil {
+		return 0, err
+	}
+	l.sequences[producerID] = sequence
+	return offset, nil
+}
+
+func (l *flakyLog) takeErr() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.err
+	l.err = nil
+	return err
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+type ConsumerConfig struct {
+	// 처음 읽을 오프셋
+	Offset uint64
+	// 있으면 이 토픽의 레코드만 받는다.
+	Topic string
+	// true 면 커밋한 트랜잭션의 레코드와 트랜잭션 밖의 레코드만 받는다.
+	ReadCommitted bool
+	// Records 채널의 크기, 가득 차면 스트림에서 더 받지 않는다. 기본값 100
+	BufferSize int
+	// 스트림이 끊겼을 때 다시 연결하기까지 기다리는 시간, 두 배씩 MaxBackoff 까지 늘어난다. 기본값 100ms
+	RetryBackoff time.Duration
+	// 기본값 5s
+	MaxBackoff time.Duration
+	// 서버가 레코드가 없을 때 보내는 heartbeat 간격, 세 번 동안 아무것도 받지 못하면 다시 연결한다. 기본값 5s
+	HeartbeatInterval time.Duration
+	// 서버가 응답 하나에 담아 보낼 최대 바이트, 0 이면 서버 설정을 따른다.
+	MaxBytes int
+}
+
+/*
+ConsumeStream 으로 레코드를 받아 Records 채널로 넘긴다.
+스트림이 끊기면 마지막으로 넘긴 레코드 다음 오프셋부터 다시 연결한다.
+채널이 가득 차면 스트림에서 받지 않으므로, gRPC 흐름 제어로 서버도 보내기를 멈춘다.
+*/
+type Consumer struct {
+	config  ConsumerConfig
+	client  api.LogClient
+	logger  *zap.Logger
+	records chan *api.Record
+	offset  uint64 // 다음에 읽을 오프셋
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+func (c *Client) NewConsumer(config ConsumerConfig) *Consumer {
+	if config.BufferSize <= 0 {
+		config.BufferSize = 100
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = 5 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cs := &Consumer{
+		config:  config,
+		client:  c.log,
+		logger:  c.logger.Named("consumer"),
+		records: make(chan *api.Record, config.BufferSize),
+		offset:  config.Offset,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go cs.run(ctx)
+	return cs
+}
+
+// 받은 레코드, Consumer 가 멈추면 닫힌다. 멈춘 이유는 Err 로 확인한다.
+func (c *Consumer) Records() <-chan *api.Record {
+	return c.records
+}
+
+// 다음에 읽을 오프셋, Records 로 넘긴 마지막 레코드의 오프셋 + 1
+func (c *Consumer) Offset() uint64 {
+	return atomic.LoadUint64(&c.offset)
+}
+
+// 다시 연결해도 소용없는 에러로 멈췄으면 그 에러를 리턴한다.
+func (c *Consumer) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Consumer) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Consumer) run(ctx context.Context) {
+	defer close(c.done)
+	defer close(c.records)
+
+	backoff := c.config.RetryBackoff
+	for {
+		received, err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if permanent(err) {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			c.logger.Error("stopped consuming", zap.Error(err))
+			return
+		}
+		if received {
+			backoff = c.config.RetryBackoff
+		}
+		c.logger.Debug("reconnecting consume stream",
+			zap.Uint64("offset", c.Offset()),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// 스트림이 끝날 때까지 레코드를 받는다. 하나라도 받았으면 received 가 true 다.
+// 연결이 끊긴 것을 알아채지 못하는 경우를 위해, heartbeat 도 받지 못하면 스트림을 취소한다.
+func (c *Consumer) consume(ctx context.Context) (received bool, err error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idleTimeout := 3 * c.config.HeartbeatInterval
+	idle := time.AfterFunc(idleTimeout, cancel)
+	defer idle.Stop()
+
+	stream, err := c.client.ConsumeStream(streamCtx, &api.ConsumeRequest{
+		Offset:            c.Offset(),
+		ReadCommitted:     c.config.ReadCommitted,
+		Topic:             c.config.Topic,
+		HeartbeatInterval: durationpb.New(c.config.HeartbeatInterval),
+		MaxBytes:          uint32(c.config.MaxBytes),
+	})
+	if err != nil {
+		return false, err
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return received, err
+		}
+		idle.Reset(idleTimeout)
+		// heartbeat 응답에는 레코드가 없다.
+		for _, record := range res.Records {
+			select {
+			case c.records <- record:
+			case <-ctx.Done():
+				return received, ctx.Err()
+			}
+			received = true
+			atomic.StoreUint64(&c.offset, record.Offset+1)
+		}
+	}
+}
+
+// 권한이 없거나 요청이 잘못된 경우는 다시 연결해도 같은 에러가 난다.
+func permanent(err error) bool {
+	switch status.Code(err) {
+	case codes.PermissionDenied, codes.Unauthenticated, codes.InvalidArgument, codes.Unimplemented:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,323 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrProducerClosed = errors.New("client: producer closed")
+
+type ProducerConfig struct {
+	// 레코드를 추가할 토픽, 비어 있으면 토픽 없이 추가한다. (모든 토픽에 대한 권한이 필요하다.)
+	Topic string
+	// 서버가 다시 보낸 레코드를 걸러낼 때 쓰는 ID, 비어 있으면 임의로 만든다.
+	ProducerID string
+	// 한 번에 보낼 최대 레코드 수, 기본값 100
+	BatchSize int
+	// 배치가 차지 않았을 때 더 기다리는 시간, 기본값 5ms
+	Linger time.Duration
+	// 보내기 전에 쌓아둘 수 있는 레코드 수, 가득 차면 Produce 가 기다린다. 기본값 1000
+	BufferSize int
+	// 다시 보낼 최대 횟수, 기본값 5
+	MaxRetries int
+	// 처음 다시 보내기까지 기다리는 시간, 두 배씩 늘어난다. 기본값 100ms
+	RetryBackoff time.Duration
+	// 배치 하나를 보내는 제한 시간, 기본값 10s
+	RequestTimeout time.Duration
+	// 레코드를 추가하거나 끝내 실패했을 때 호출한다. 보내는 고루틴에서 호출하므로 오래 걸리면 안 된다.
+	OnDelivery func(Delivery)
+}
+
+// 레코드를 보낸 결과
+type Delivery struct {
+	Value  []byte
+	Offset uint64
+	Err    error
+}
+
+/*
+레코드를 모아서 ProduceStream 으로 리더에 보낸다.
+Produce 는 레코드를 버퍼에 넣기만 하고, 고루틴 하나가 배치로 묶어 순서대로 보낸다.
+레코드마다 프로듀서 ID 와 1씩 늘어나는 sequence 를 붙이므로, 타임아웃 후 다시 보내도 서버는 한 번만 추가한다.
+*/
+type Producer struct {
+	config   ProducerConfig
+	client   api.LogClient
+	logger   *zap.Logger
+	sequence uint64 // 마지막으로 붙인 sequence, run 고루틴에서만 쓴다.
+	// 배치가 끝내 실패할 때마다 늘린다. run 고루틴에서만 쓴다. (producerID)
+	epoch uint64
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *message
+	done   chan struct{}
+}
+
+type message struct {
+	value    []byte
+	sequence uint64
+	callback func(Delivery)
+	flushed  chan struct{} // nil 이 아니면 레코드가 아니라 Flush 표시
+}
+
+func (c *Client) NewProducer(config ProducerConfig) *Producer {
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.Linger <= 0 {
+		config.Linger = 5 * time.Millisecond
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 5
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 100 * time.Millisecond
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = 10 * time.Second
+	}
+	if config.ProducerID == "" {
+		config.ProducerID = newProducerID()
+	}
+	p := &Producer{
+		config: config,
+		client: c.log,
+		logger: c.logger.Named("producer"),
+		queue:  make(chan *message, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// 레코드를 버퍼에 넣는다. 결과는 OnDelivery 로 받는다.
+func (p *Producer) Produce(ctx context.Context, value []byte) error {
+	return p.enqueue(ctx, &message{value: value})
+}
+
+// 레코드를 보내고 추가될 때까지 기다린다.
+func (p *Producer) ProduceSync(ctx context.Context, value []byte) (uint64, error) {
+	result := make(chan Delivery, 1)
+	err := p.enqueue(ctx, &message{
+		value:    value,
+		callback: func(d Delivery) { result <- d },
+	})
+	if err != nil {
+		return 0, err
+	}
+	select {
+	case d := <-result:
+		return d.Offset, d.Err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// 지금까지 넣은 레코드를 모두 보낼 때까지 기다린다.
+func (p *Producer) Flush(ctx context.Context) error {
+	msg := &message{flushed: make(chan struct{})}
+	if err := p.enqueue(ctx, msg); err != nil {
+		return err
+	}
+	select {
+	case <-msg.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 남은 레코드를 모두 보내고 닫는다.
+func (p *Producer) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+	<-p.done
+	return nil
+}
+
+func (p *Producer) enqueue(ctx context.Context, msg *message) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrProducerClosed
+	}
+	select {
+	case p.queue <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 버퍼에서 배치를 모아 보낸다. 배치가 가득 차거나, Linger 가 지나거나, Flush 표시를 만나면 보낸다.
+func (p *Producer) run() {
+	defer close(p.done)
+	for {
+		msg, ok := <-p.queue
+		if !ok {
+			return
+		}
+		if msg.flushed != nil {
+			close(msg.flushed)
+			continue
+		}
+
+		batch := []*message{p.sequenced(msg)}
+		var flush *message
+		timer := time.NewTimer(p.config.Linger)
+	collect:
+		for len(batch) < p.config.BatchSize {
+			select {
+			case msg, ok := <-p.queue:
+				if !ok {
+					break collect
+				}
+				if msg.flushed != nil {
+					flush = msg
+					break collect
+				}
+				batch = append(batch, p.sequenced(msg))
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		p.send(batch)
+		if flush != nil {
+			close(flush.flushed)
+		}
+	}
+}
+
+// 버퍼에서 꺼낸 순서대로 sequence 를 붙인다.
+func (p *Producer) sequenced(msg *message) *message {
+	p.sequence++
+	msg.sequence = p.sequence
+	return msg
+}
+
+// 배치를 보낸다. 다시 보내도 되는 에러면 아직 응답을 받지 못한 레코드만 다시 보낸다.
+func (p *Producer) send(batch []*message) {
+	backoff := p.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), p.config.RequestTimeout)
+		delivered, err := p.sendOnce(ctx, batch)
+		cancel()
+		if err == nil {
+			return
+		}
+		batch = batch[delivered:]
+		if !p.retryable(err) || attempt >= p.config.MaxRetries {
+			for _, msg := range batch {
+				p.deliver(msg, 0, err)
+			}
+			p.newEpoch(err)
+			return
+		}
+
+		p.logger.Warn("retrying produce",
+			zap.Int("records", len(batch)),
+			zap.Int("attempt", attempt+1),
+			zap.Error(err),
+		)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// 스트림 하나로 배치를 보내고, 추가된 레코드 수를 리턴한다.
+func (p *Producer) sendOnce(ctx context.Context, batch []*message) (int, error) {
+	stream, err := p.client.ProduceStream(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	// 서버가 응답을 보내지 못해 막히지 않도록 보내는 것과 받는 것을 동시에 한다.
+	// 보내다 실패하면 스트림이 끝나므로 에러는 Recv 에서 받는다.
+	go func() {
+		for _, msg := range batch {
+			req := &api.ProduceRequest{
+				Record:     &api.Record{Value: msg.value, Topic: p.config.Topic},
+				ProducerId: p.producerID(),
+				Sequence:   msg.sequence,
+			}
+			if err := stream.Send(req); err != nil {
+				return
+			}
+		}
+		stream.CloseSend()
+	}()
+
+	for i, msg := range batch {
+		res, err := stream.Recv()
+		if err != nil {
+			return i, err
+		}
+		p.deliver(msg, res.Offset, nil)
+	}
+	return len(batch), nil
+}
+
+/*
+배치가 끝내 실패하면 서버는 그 sequence 들을 받지 못했으므로, 같은 ID 로 다음 sequence 를 보내면 건너뛴 것으로 보고 모두 거절한다.
+sequence 를 되돌리면 타임아웃 뒤에 추가됐을지 모르는 레코드와 새 레코드를 서버가 같은 것으로 걸러낼 수 있으므로,
+ID 를 새로 만들고 sequence 를 처음부터 붙인다. 서버는 처음 보는 프로듀서는 어느 sequence 로든 받는다.
+*/
+func (p *Producer) newEpoch(err error) {
+	p.epoch++
+	p.sequence = 0
+	p.logger.Warn("batch failed, producing with a new producer id",
+		zap.String("producer_id", p.producerID()),
+		zap.Error(err),
+	)
+}
+
+// ProducerID 에 epoch 를 붙인다. 처음에는 ProducerID 그대로다.
+func (p *Producer) producerID() string {
+	if p.epoch == 0 {
+		return p.config.ProducerID
+	}
+	return fmt.Sprintf("%s-%d", p.config.ProducerID, p.epoch)
+}
+
+// 서버가 sequence 로 중복을 걸러내므로, 추가됐는지 알 수 없는 타임아웃도 다시 보낸다.
+func (p *Producer) retryable(err error) bool {
+	return retryable(err) || status.Code(err) == codes.DeadlineExceeded
+}
+
+func newProducerID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (p *Producer) deliver(msg *message, offset uint64, err error) {
+	d := Delivery{Value: msg.value, Offset: offset, Err: err}
+	if msg.callback != nil {
+		msg.callback(d)
+	}
+	if p.config.OnDelivery != nil {
+		p.config.OnDelivery(d)
+	}
+}
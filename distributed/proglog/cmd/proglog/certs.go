@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jhkim988/proglog/internal/certs"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/spf13/cobra"
+)
+
+/*
+cfssl 없이 개발용 CA 와 인증서를 만든다.
+
+	proglog certs                 # CONFIG_DIR(없으면 ~/.proglog)에 인증서를 만든다.
+	proglog certs --acl           # model.conf, policy.csv 도 만든다.
+	proglog certs --force         # CA 부터 새로 만들고 이미 있는 파일을 덮어쓴다.
+	proglog certs --skip-existing # 파일이 모두 있으면 그대로 둔다. (make init)
+*/
+func newCertsCmd() *cobra.Command {
+	var (
+		opts         certs.Options
+		acl          bool
+		skipExisting bool
+	)
+	cmd := &cobra.Command{
+		Use:   "certs",
+		Short: "Generate a development CA and the certificates the tests expect",
+		Args:  cobra.NoArgs,
+		// 플래그가 아니라 파일 때문에 실패하므로 사용법은 출력하지 않는다.
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !skipExisting || !allExist(opts.Dir, certs.Files) {
+				written, err := certs.Generate(opts)
+				for _, file := range written {
+					fmt.Fprintln(cmd.OutOrStdout(), file)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if !acl || skipExisting && allExist(opts.Dir, certs.ACLFiles) {
+				return nil
+			}
+			written, err := certs.WriteACL(opts.Dir, opts.Force)
+			for _, file := range written {
+				fmt.Fprintln(cmd.OutOrStdout(), file)
+			}
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&opts.Dir, "dir", config.Dir(), "Directory to write the certificates to.")
+	cmd.Flags().StringSliceVar(&opts.Hosts, "hosts", nil, "Host names and IP addresses for the server certificate. (default localhost,127.0.0.1,::)")
+	cmd.Flags().DurationVar(&opts.Validity, "validity", certs.DefaultValidity, "How long the certificates are valid.")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "Generate a new CA and overwrite existing files.")
+	cmd.Flags().BoolVar(&acl, "acl", false, "Also write model.conf and policy.csv.")
+	cmd.Flags().BoolVar(&skipExisting, "skip-existing", false, "Leave the certificates or ACL files alone if they all exist already.")
+	return cmd
+}
+
+// 일부만 있으면 false 라서 Generate 가 이미 있는 파일을 알려준다.
+func allExist(dir string, names []string) bool {
+	for _, name := range names {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
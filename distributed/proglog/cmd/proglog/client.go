@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/client"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+/*
+클러스터에 접속하는 명령들, proglog:/// 리졸버로 --addrs 의 서버에서 서버 목록을 받는다.
+
+	proglog produce --topic orders a b c       # 인자마다 레코드 하나
+	tail -f app.log | proglog produce          # 표준 입력의 줄마다 레코드 하나
+	proglog produce -f a.json -f b.json        # 파일마다 레코드 하나
+	proglog consume --from start --format raw  # 지금 있는 레코드를 읽고 끝낸다.
+	proglog consume --from end --follow        # 새 레코드를 기다린다.
+	proglog servers
+	proglog offsets
+
+레코드의 오프셋, 레코드, 서버 목록은 표준 출력에, 그 밖의 메시지는 표준 에러에 쓴다.
+*/
+func newClientCmds() []*cobra.Command {
+	return []*cobra.Command{
+		newProduceCmd(),
+		newConsumeCmd(),
+		newServersCmd(),
+		newOffsetsCmd(),
+	}
+}
+
+type clientFlags struct {
+	addrs   []string
+	tls     config.TLSConfig
+	token   string
+	timeout time.Duration
+	topic   string
+	format  string
+	formats []string
+}
+
+func (f *clientFlags) register(cmd *cobra.Command, formats ...string) {
+	cmd.Flags().StringSliceVar(&f.addrs, "addrs", []string{"127.0.0.1:8400"}, "RPC addresses of servers to discover the cluster from.")
+	cmd.Flags().StringVar(&f.tls.CertFile, "tls-cert-file", "", "Path to client tls cert.")
+	cmd.Flags().StringVar(&f.tls.KeyFile, "tls-key-file", "", "Path to client tls key.")
+	cmd.Flags().StringVar(&f.tls.CAFile, "tls-ca-file", "", "Path to server certificate authority.")
+	cmd.Flags().StringVar(&f.tls.ServerAddress, "tls-server-name", "127.0.0.1", "Name to verify server certificates against.")
+	cmd.Flags().StringVar(&f.token, "token", os.Getenv("PROGLOG_TOKEN"), "Bearer token (API key or JWT), defaults to $PROGLOG_TOKEN.")
+	cmd.Flags().DurationVar(&f.timeout, "timeout", 10*time.Second, "Timeout for each request.")
+	cmd.Flags().StringVar(&f.topic, "topic", "", "Topic of the records.")
+	if len(formats) > 0 {
+		f.formats = formats
+		cmd.Flags().StringVar(&f.format, "format", formats[0], fmt.Sprintf("Output format, one of %v.", formats))
+	}
+	cmd.SilenceUsage = true
+}
+
+func (f *clientFlags) validate() error {
+	if len(f.formats) == 0 {
+		return nil
+	}
+	for _, format := range f.formats {
+		if f.format == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %q, want one of %v", f.format, f.formats)
+}
+
+// 인증서나 CA 가 있으면 TLS 로 연결한다.
+func (f *clientFlags) client() (*client.Client, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	cfg := client.Config{Addrs: f.addrs, Token: f.token}
+	if f.tls.CAFile != "" || f.tls.CertFile != "" {
+		tlsConfig, err := config.SetupTLSConfig(f.tls)
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLSConfig = tlsConfig
+	}
+	return client.New(cfg)
+}
+
+// SIGINT, SIGTERM 을 받으면 취소된다.
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+func newProduceCmd() *cobra.Command {
+	var (
+		flags clientFlags
+		files []string
+	)
+	cmd := &cobra.Command{
+		Use:   "produce [values...]",
+		Short: "Append records from arguments, files or standard input lines, printing their offsets",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := flags.client()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			var (
+				mu      sync.Mutex
+				failed  error
+				printed = cmd.OutOrStdout()
+			)
+			producer := c.NewProducer(client.ProducerConfig{
+				Topic:          flags.topic,
+				RequestTimeout: flags.timeout,
+				// 보낸 순서대로 호출되므로 오프셋도 입력 순서대로 출력한다.
+				OnDelivery: func(d client.Delivery) {
+					mu.Lock()
+					defer mu.Unlock()
+					if d.Err != nil {
+						if failed == nil {
+							failed = d.Err
+						}
+						return
+					}
+					fmt.Fprintln(printed, d.Offset)
+				},
+			})
+			err = produceValues(ctx, producer, args, files, cmd.InOrStdin())
+			if closeErr := producer.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			return failed
+		},
+	}
+	flags.register(cmd)
+	cmd.Flags().StringArrayVarP(&files, "file", "f", nil, "File whose whole content is one record, can be repeated.")
+	return cmd
+}
+
+const maxLineBytes = 4 << 20
+
+// 인자와 파일이 없으면 표준 입력을 읽는다.
+func produceValues(ctx context.Context, producer *client.Producer, args, files []string, stdin io.Reader) error {
+	for _, arg := range args {
+		if err := producer.Produce(ctx, []byte(arg)); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		value, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := producer.Produce(ctx, value); err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 || len(files) > 0 {
+		return nil
+	}
+	scanner := bufio.NewScanner(stdin)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		// Scanner 는 버퍼를 다시 쓰므로 복사한다.
+		if err := producer.Produce(ctx, []byte(scanner.Text())); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func newConsumeCmd() *cobra.Command {
+	var (
+		flags         clientFlags
+		from          string
+		follow        bool
+		readCommitted bool
+	)
+	cmd := &cobra.Command{
+		Use:   "consume",
+		Short: "Print records, one per line",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := flags.client()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			ctx, cancel := signalContext()
+			defer cancel()
+
+			reqCtx, reqCancel := context.WithTimeout(ctx, flags.timeout)
+			lowest, next, err := c.Offsets(reqCtx, flags.topic)
+			reqCancel()
+			if err != nil {
+				return err
+			}
+			offset, err := parseFrom(from, lowest, next)
+			if err != nil {
+				return err
+			}
+
+			w := bufio.NewWriter(cmd.OutOrStdout())
+			defer w.Flush()
+			print := func(record *api.Record) error {
+				if err := writeRecord(w, record, flags.format); err != nil {
+					return err
+				}
+				// follow 중에는 받는 대로 보여준다.
+				if follow {
+					return w.Flush()
+				}
+				return nil
+			}
+			if follow {
+				return followRecords(ctx, c, client.ConsumerConfig{
+					Offset:        offset,
+					Topic:         flags.topic,
+					ReadCommitted: readCommitted,
+				}, print)
+			}
+			return readRecords(ctx, c, &api.ConsumeRequest{
+				Topic:         flags.topic,
+				ReadCommitted: readCommitted,
+			}, offset, next, flags.timeout, print)
+		},
+	}
+	flags.register(cmd, "json", "raw")
+	cmd.Flags().StringVar(&from, "from", "start", `Offset to start from, "start", "end" or a number.`)
+	cmd.Flags().BoolVar(&follow, "follow", false, "Keep waiting for new records.")
+	cmd.Flags().BoolVar(&readCommitted, "read-committed", false, "Skip records of open or aborted transactions.")
+	return cmd
+}
+
+func parseFrom(from string, lowest, next uint64) (uint64, error) {
+	switch from {
+	case "start":
+		return lowest, nil
+	case "end":
+		return next, nil
+	}
+	offset, err := strconv.ParseUint(from, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(`invalid --from %q, want "start", "end" or an offset`, from)
+	}
+	return offset, nil
+}
+
+/*
+요청할 때의 끝 오프셋까지 한 레코드씩 읽고 끝낸다.
+스트림은 다른 토픽의 레코드를 알려주지 않아 어디까지 읽었는지 알 수 없으므로 Consume 으로 읽는다.
+*/
+func readRecords(ctx context.Context, c *client.Client, req *api.ConsumeRequest, offset, next uint64, timeout time.Duration, print func(*api.Record) error) error {
+	// 끝 오프셋은 리더에게 물었으므로 뒤처진 팔로워가 읽으면 따라올 때까지 기다린다.
+	// 요청 timeout 보다 먼저 끝나야 기다려도 읽지 못한 레코드를 알 수 있다.
+	req.MaxWait = durationpb.New(timeout / 2)
+	for ; offset < next; offset++ {
+		req.Offset = offset
+		reqCtx, cancel := context.WithTimeout(ctx, timeout)
+		record, err := c.Read(reqCtx, req)
+		cancel()
+		switch {
+		case err == nil:
+			if err := print(record); err != nil {
+				return err
+			}
+		// 다른 토픽의 레코드와 read_committed 로 건너뛴 레코드
+		case status.Code(err) == codes.NotFound:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// 끊기면 다시 연결하면서 SIGINT 를 받을 때까지 읽는다.
+func followRecords(ctx context.Context, c *client.Client, config client.ConsumerConfig, print func(*api.Record) error) error {
+	consumer := c.NewConsumer(config)
+	defer consumer.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case record, ok := <-consumer.Records():
+			if !ok {
+				return consumer.Err()
+			}
+			if err := print(record); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// json 은 레코드를 한 줄의 protojson 으로, raw 는 값만 쓴다.
+func writeRecord(w io.Writer, record *api.Record, format string) error {
+	if format == "raw" {
+		if _, err := w.Write(record.Value); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	}
+	return writeJSONLine(w, record)
+}
+
+func writeJSONLine(w io.Writer, msg proto.Message) error {
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func newServersCmd() *cobra.Command {
+	var flags clientFlags
+	cmd := &cobra.Command{
+		Use:   "servers",
+		Short: "List the servers in the cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := flags.client()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), flags.timeout)
+			defer cancel()
+			servers, err := c.Servers(ctx)
+			if err != nil {
+				return err
+			}
+			if flags.format == "json" {
+				return writeJSONLine(cmd.OutOrStdout(), &api.GetServersResponse{Servers: servers})
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tRPC ADDR\tLEADER")
+			for _, server := range servers {
+				fmt.Fprintf(w, "%s\t%s\t%t\n", server.Id, server.RpcAddr, server.IsLeader)
+			}
+			return w.Flush()
+		},
+	}
+	flags.register(cmd, "text", "json")
+	return cmd
+}
+
+func newOffsetsCmd() *cobra.Command {
+	var flags clientFlags
+	cmd := &cobra.Command{
+		Use:   "offsets",
+		Short: "Print the lowest offset and the next offset to be written",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := flags.client()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), flags.timeout)
+			defer cancel()
+			lowest, next, err := c.Offsets(ctx, flags.topic)
+			if err != nil {
+				return err
+			}
+			if flags.format == "json" {
+				return writeJSONLine(cmd.OutOrStdout(), &api.GetOffsetsResponse{LowestOffset: lowest, NextOffset: next})
+			}
+			_, err = fmt.Fprintf(cmd.OutOrStdout(), "lowest\t%d\nnext\t%d\n", lowest, next)
+			return err
+		},
+	}
+	flags.register(cmd, "text", "json")
+	return cmd
+}
@@ -1,11 +1,20 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"syscall"
 
 	"github.com/jhkim988/proglog/internal/agent"
 	"github.com/jhkim988/proglog/internal/config"
+	"github.com/jhkim988/proglog/internal/logging"
+	"github.com/jhkim988/proglog/internal/tracing"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 func main() {
@@ -36,13 +45,218 @@ type cfg struct {
 	PeerTLSConfig   config.TLSConfig
 }
 
+/*
+플래그, 설정 파일, PROGLOG_ 환경 변수 순서로 값을 찾는다. (플래그 > 환경 변수 > 설정 파일 > 기본값)
+설정 파일과 환경 변수의 키는 플래그 이름과 같다. 환경 변수는 대문자로 쓰고 - 대신 _ 를 쓴다.
+
+	# config.yaml
+	data-dir: /var/lib/proglog
+	start-join-addrs: [127.0.0.1:8401]
+
+	PROGLOG_NODE_NAME=node-1 PROGLOG_START_JOIN_ADDRS=127.0.0.1:8401,127.0.0.1:8411 proglog --config-file config.yaml
+*/
 func setupFlags(cmd *cobra.Command) error {
-	panic("unimplemented")
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+
+	cmd.Flags().String("config-file", "", "Path to config file. (yaml, toml or json)")
+
+	dataDir := path.Join(os.TempDir(), "proglog")
+	cmd.Flags().String("data-dir", dataDir, "Directory to store log and Raft data.")
+	cmd.Flags().String("node-name", hostname, "Unique server ID.")
+	cmd.Flags().String("bind-addr", "127.0.0.1:8401", "Address to bind Serf on.")
+	cmd.Flags().Int("rpc-port", 8400, "Port for RPC clients (and Raft) connections.")
+	cmd.Flags().StringSlice("start-join-addrs", nil, "Serf addresses to join.")
+	cmd.Flags().Bool("bootstrap", false, "Bootstrap the cluster.")
+	cmd.Flags().Duration("drain-timeout", 0, "How long to wait for in-flight requests on shutdown. (default 10s)")
+	cmd.Flags().Duration("txn-timeout", 0, "How long a transaction may stay open before the leader aborts it. (default 1m)")
+	cmd.Flags().Duration("producer-idle-timeout", 0, "How long an idempotent producer may stay idle before its sequence is forgotten. (default 24h)")
+	cmd.Flags().String("metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, e.g. :9100.")
+
+	cmd.Flags().Float64("trace-sample-ratio", 0, "Fraction of requests to trace, 0 to 1.")
+	cmd.Flags().Bool("trace-parent-based", false, "Follow the sampling decision of traced clients.")
+	cmd.Flags().String("trace-exporter", "", "Where to send spans: stdout, file or otlp.")
+	cmd.Flags().String("trace-file", "", "File to append spans to as JSON lines with --trace-exporter=file.")
+	cmd.Flags().String("trace-otlp-endpoint", "", "OTLP/gRPC endpoint with --trace-exporter=otlp, e.g. localhost:4317.")
+	cmd.Flags().Bool("trace-otlp-insecure", false, "Connect to the OTLP endpoint without TLS.")
+	cmd.Flags().String("trace-service-name", "proglog", "service.name of the exported spans.")
+	cmd.Flags().String("log-level", "info", "Log level: debug, info, warn or error.")
+	cmd.Flags().StringSlice("log-levels", nil, "Per-component log levels as name=level, e.g. raft=warn,server=debug.")
+	cmd.Flags().String("log-format", "console", "Log encoding: console or json.")
+	cmd.Flags().StringSlice("log-outputs", []string{"stderr"}, "Where to write logs: stdout, stderr or file paths.")
+	cmd.Flags().Int("log-max-size", 100, "Megabytes a log file may grow to before it is rotated.")
+	cmd.Flags().Int("log-max-backups", 0, "Rotated log files to keep, 0 to keep all.")
+	cmd.Flags().Int("log-max-age", 0, "Days to keep rotated log files, 0 to keep them regardless of age.")
+	cmd.Flags().Bool("log-compress", false, "Gzip rotated log files.")
+
+	cmd.Flags().Uint64("max-apply-lag", 0, "Report not ready while more raft entries than this are unapplied. (default 1000)")
+
+	cmd.Flags().String("acl-model-file", config.ACLModelFile, "Path to ACL model.")
+	cmd.Flags().String("acl-policy-file", config.ACLPolicyFile, "Path to ACL policy.")
+	cmd.Flags().Duration("acl-reload-interval", 0, "How often to check the ACL and quota files for changes, negative to disable. (default 10s)")
+	cmd.Flags().String("quota-file", "", "Path to per-subject quota JSON.")
+
+	cmd.Flags().String("api-key-file", "", "Path to API key CSV. (key,subject)")
+	cmd.Flags().StringSlice("jwks-files", nil, "Paths to JWKS files to verify bearer JWTs with.")
+	cmd.Flags().String("jwt-issuer", "", "Required JWT issuer.")
+	cmd.Flags().String("jwt-audience", "", "Required JWT audience.")
+
+	cmd.Flags().String("audit-log-file", "", "Path to write authorization audit events to.")
+	cmd.Flags().String("audit-topic", "", "Topic to append authorization audit events to.")
+	cmd.Flags().Int("audit-allowed-sampling", 0, "Audit one of every N allowed decisions, negative to skip allowed decisions.")
+
+	cmd.Flags().String("server-tls-cert-file", "", "Path to server tls cert.")
+	cmd.Flags().String("server-tls-key-file", "", "Path to server tls key.")
+	cmd.Flags().String("server-tls-ca-file", "", "Path to server certificate authority.")
+	cmd.Flags().StringSlice("server-tls-ca-files", nil, "Additional certificate authorities to trust while rotating.")
+
+	cmd.Flags().String("peer-tls-cert-file", "", "Path to peer tls cert.")
+	cmd.Flags().String("peer-tls-key-file", "", "Path to peer tls key.")
+	cmd.Flags().String("peer-tls-ca-file", "", "Path to peer certificate authority.")
+	cmd.Flags().StringSlice("peer-tls-ca-files", nil, "Additional certificate authorities to trust while rotating.")
+	cmd.Flags().String("peer-tls-server-name", "127.0.0.1", "Name to verify peer server certificates against.")
+
+	cmd.AddCommand(newCertsCmd())
+	cmd.AddCommand(newClientCmds()...)
+
+	viper.SetEnvPrefix("PROGLOG")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
+	return viper.BindPFlags(cmd.Flags())
 }
 
-func (*cli) setupConfig(cmd *cobra.Command, args []string) error {
-	panic("unimplemented")
+func (c *cli) setupConfig(cmd *cobra.Command, args []string) error {
+	var err error
+
+	configFile, err := cmd.Flags().GetString("config-file")
+	if err != nil {
+		return err
+	}
+	if configFile == "" {
+		configFile = viper.GetString("config-file")
+	}
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+		if err = viper.ReadInConfig(); err != nil {
+			return err
+		}
+	}
+
+	c.cfg.DataDir = viper.GetString("data-dir")
+	c.cfg.NodeName = viper.GetString("node-name")
+	c.cfg.BindAddr = viper.GetString("bind-addr")
+	c.cfg.RPCPort = viper.GetInt("rpc-port")
+	c.cfg.StartJoinAddrs = getStringSlice("start-join-addrs")
+	c.cfg.Bootstrap = viper.GetBool("bootstrap")
+	c.cfg.DrainTimeout = viper.GetDuration("drain-timeout")
+	c.cfg.TxnTimeout = viper.GetDuration("txn-timeout")
+	c.cfg.ProducerIdleTimeout = viper.GetDuration("producer-idle-timeout")
+	c.cfg.MaxApplyLag = viper.GetUint64("max-apply-lag")
+	c.cfg.MetricsAddr = viper.GetString("metrics-addr")
+	c.cfg.Tracing = tracing.Config{
+		SampleRatio:  viper.GetFloat64("trace-sample-ratio"),
+		ParentBased:  viper.GetBool("trace-parent-based"),
+		Exporter:     viper.GetString("trace-exporter"),
+		File:         viper.GetString("trace-file"),
+		OTLPEndpoint: viper.GetString("trace-otlp-endpoint"),
+		OTLPInsecure: viper.GetBool("trace-otlp-insecure"),
+		ServiceName:  viper.GetString("trace-service-name"),
+	}
+	logLevels, err := getStringMap("log-levels")
+	if err != nil {
+		return err
+	}
+	c.cfg.Logging = logging.Config{
+		Level:      viper.GetString("log-level"),
+		Levels:     logLevels,
+		Format:     viper.GetString("log-format"),
+		Outputs:    getStringSlice("log-outputs"),
+		MaxSizeMB:  viper.GetInt("log-max-size"),
+		MaxBackups: viper.GetInt("log-max-backups"),
+		MaxAgeDays: viper.GetInt("log-max-age"),
+		Compress:   viper.GetBool("log-compress"),
+	}
+	c.cfg.ACLModelFile = viper.GetString("acl-model-file")
+	c.cfg.ACLPolicyFile = viper.GetString("acl-policy-file")
+	c.cfg.ACLReloadInterval = viper.GetDuration("acl-reload-interval")
+	c.cfg.QuotaFile = viper.GetString("quota-file")
+	c.cfg.APIKeyFile = viper.GetString("api-key-file")
+	c.cfg.JWKSFiles = getStringSlice("jwks-files")
+	c.cfg.JWTIssuer = viper.GetString("jwt-issuer")
+	c.cfg.JWTAudience = viper.GetString("jwt-audience")
+	c.cfg.AuditLogFile = viper.GetString("audit-log-file")
+	c.cfg.AuditTopic = viper.GetString("audit-topic")
+	c.cfg.AuditAllowedSampling = viper.GetInt("audit-allowed-sampling")
+
+	c.cfg.ServerTLSConfig.CertFile = viper.GetString("server-tls-cert-file")
+	c.cfg.ServerTLSConfig.KeyFile = viper.GetString("server-tls-key-file")
+	c.cfg.ServerTLSConfig.CAFile = viper.GetString("server-tls-ca-file")
+	c.cfg.ServerTLSConfig.CAFiles = getStringSlice("server-tls-ca-files")
+	c.cfg.PeerTLSConfig.CertFile = viper.GetString("peer-tls-cert-file")
+	c.cfg.PeerTLSConfig.KeyFile = viper.GetString("peer-tls-key-file")
+	c.cfg.PeerTLSConfig.CAFile = viper.GetString("peer-tls-ca-file")
+	c.cfg.PeerTLSConfig.CAFiles = getStringSlice("peer-tls-ca-files")
+	c.cfg.PeerTLSConfig.ServerAddress = viper.GetString("peer-tls-server-name")
+
+	if c.cfg.ServerTLSConfig.CertFile != "" && c.cfg.ServerTLSConfig.KeyFile != "" {
+		c.cfg.ServerTLSConfig.Server = true
+		c.cfg.Config.ServerTLSConfig, err = config.SetupTLSConfig(c.cfg.ServerTLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.cfg.PeerTLSConfig.CertFile != "" && c.cfg.PeerTLSConfig.KeyFile != "" {
+		c.cfg.Config.PeerTLSConfig, err = config.SetupTLSConfig(c.cfg.PeerTLSConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
-func (*cli) run(cmd *cobra.Command, args []string) error {
-	panic("unimplemented")
+
+// 환경 변수로 받은 목록은 하나의 문자열이므로 쉼표로 나눈다.
+func getStringSlice(key string) []string {
+	var values []string
+	for _, value := range viper.GetStringSlice(key) {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// 설정 파일의 맵이나 "이름=값" 목록을 읽는다.
+func getStringMap(key string) (map[string]string, error) {
+	if values := viper.GetStringMapString(key); len(values) > 0 {
+		return values, nil
+	}
+	values := make(map[string]string)
+	for _, v := range getStringSlice(key) {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s: %q is not name=value", key, v)
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values, nil
+}
+
+// 에이전트를 시작하고 SIGINT, SIGTERM 을 받으면 drain 한 뒤 종료한다.
+func (c *cli) run(cmd *cobra.Command, args []string) error {
+	var err error
+	agent, err := agent.New(c.cfg.Config)
+	if err != nil {
+		return err
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+	signal.Stop(sigc)
+	return agent.Shutdown()
 }
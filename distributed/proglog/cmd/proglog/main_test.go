@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte(`
+data-dir: /from/file
+node-name: file-node
+rpc-port: 9000
+bootstrap: true
+drain-timeout: 3s
+start-join-addrs: [127.0.0.1:9001]
+log-levels:
+  raft: warn
+  server: debug
+`), 0600))
+	t.Setenv("PROGLOG_NODE_NAME", "env-node")
+	t.Setenv("PROGLOG_RPC_PORT", "9100")
+	t.Setenv("PROGLOG_JWKS_FILES", "a.json,b.json")
+	t.Setenv("PROGLOG_LOG_FORMAT", "json")
+
+	cli := &cli{}
+	cmd := &cobra.Command{Use: "proglog"}
+	require.NoError(t, setupFlags(cmd))
+	require.NoError(t, cmd.Flags().Parse([]string{"--config-file", configFile, "--rpc-port", "9200"}))
+	require.NoError(t, cli.setupConfig(cmd, nil))
+
+	// 플래그 > 환경 변수 > 설정 파일 > 기본값
+	require.Equal(t, 9200, cli.cfg.RPCPort)
+	require.Equal(t, "env-node", cli.cfg.NodeName)
+	require.Equal(t, "/from/file", cli.cfg.DataDir)
+	require.True(t, cli.cfg.Bootstrap)
+	require.Equal(t, 3*time.Second, cli.cfg.DrainTimeout)
+	require.Equal(t, []string{"127.0.0.1:9001"}, cli.cfg.StartJoinAddrs)
+	require.Equal(t, []string{"a.json", "b.json"}, cli.cfg.JWKSFiles)
+	require.Equal(t, "127.0.0.1:8401", cli.cfg.BindAddr)
+	require.Nil(t, cli.cfg.Config.ServerTLSConfig)
+	require.Equal(t, "info", cli.cfg.Logging.Level)
+	require.Equal(t, "json", cli.cfg.Logging.Format)
+	require.Equal(t, map[string]string{"raft": "warn", "server": "debug"}, cli.cfg.Logging.Levels)
+	require.Equal(t, []string{"stderr"}, cli.cfg.Logging.Outputs)
+}
+
+func TestLogLevelsFlag(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	cli := &cli{}
+	cmd := &cobra.Command{Use: "proglog"}
+	require.NoError(t, setupFlags(cmd))
+	require.NoError(t, cmd.Flags().Parse([]string{"--log-levels", "raft=warn, membership=error"}))
+	require.NoError(t, cli.setupConfig(cmd, nil))
+	require.Equal(t, map[string]string{"raft": "warn", "membership": "error"}, cli.cfg.Logging.Levels)
+
+	require.NoError(t, cmd.Flags().Parse([]string{"--log-levels", "raft"}))
+	require.Error(t, cli.setupConfig(cmd, nil))
+}
+
+func TestCertsSkipExisting(t *testing.T) {
+	dir := t.TempDir()
+	run := func() {
+		cmd := newCertsCmd()
+		cmd.SetArgs([]string{"--dir", dir, "--acl", "--skip-existing"})
+		cmd.SetOut(io.Discard)
+		require.NoError(t, cmd.Execute())
+	}
+	run()
+	ca, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+
+	// make init 을 다시 실행해도 CA 를 바꾸지 않는다.
+	run()
+	again, err := os.ReadFile(filepath.Join(dir, "ca.pem"))
+	require.NoError(t, err)
+	require.Equal(t, ca, again)
+}
@@ -1,12 +0,0 @@
-package main
-
-import (
-	"log"
-
-	"github.com/jhkim988/proglog/internal/server"
-)
-
-func main() {
-	srv := server.NewHTTPServer(":8080")
-	log.Fatal(srv.ListenAndServe())
-}
\ No newline at end of file
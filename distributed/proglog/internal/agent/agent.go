@@ -2,30 +2,54 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/audit"
 	"github.com/jhkim988/proglog/internal/auth"
 	"github.com/jhkim988/proglog/internal/discovery"
 	"github.com/jhkim988/proglog/internal/log"
+	"github.com/jhkim988/proglog/internal/logging"
+	"github.com/jhkim988/proglog/internal/quota"
 	"github.com/jhkim988/proglog/internal/server"
+	"github.com/jhkim988/proglog/internal/tracing"
 	"github.com/soheilhy/cmux"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 type Agent struct {
-	Config     Config
+	Config Config
+	// RPC 포트의 리스너, mux 가 raft, gRPC, HTTP 로 나눠준다.
+	ln         net.Listener
 	mux        cmux.CMux
 	log        *log.DistributedLog
 	server     *grpc.Server
-	membership *discovery.Membership
+	httpServer *http.Server
+	// MetricsAddr 가 없으면 nil
+	metricsServer *http.Server
+	metrics       *agentCollector
+	drainer       *server.Drainer
+	stopACL       func()
+	stopQuota     func()
+	stopTracing   func() error
+	closeLogger   func() error
+	audit         *audit.Logger
+	drained       bool
+	membership    *discovery.Membership
+	// setupServer 뒤에 만드는 membership 을 상태 확인 요청이 읽으므로 따로 잠근다.
+	healthLock sync.RWMutex
 	// replicator *log.Replicator
 	shutdown     bool
 	shutdowns    chan struct{}
@@ -43,8 +67,46 @@ type Config struct {
 	ACLModelFile    string
 	ACLPolicyFile   string
 	Bootstrap       bool
+	// 종료할 때 진행 중인 요청을 기다리는 최대 시간
+	DrainTimeout time.Duration
+	// 리더가 끝내지 않은 트랜잭션을 중단하기까지 기다리는 시간, 0 이면 기본값
+	TxnTimeout time.Duration
+	// 마지막 요청 후 이 시간이 지난 멱등 프로듀서는 잊는다, 0 이면 기본값. 모든 노드가 같은 값을 써야 한다.
+	ProducerIdleTimeout time.Duration
+	// ACL 모델과 정책 파일, 할당량 파일이 바뀌었는지 확인하는 간격, 0 이면 기본값, 음수면 확인하지 않는다.
+	ACLReloadInterval time.Duration
+	// 있으면 이 JSON 파일의 subject 별 할당량으로 요청을 제한한다. (quota.Config)
+	QuotaFile string
+	// "키,subject" 형식의 csv, 있으면 bearer 토큰으로 API 키를 받는다.
+	APIKeyFile string
+	// 있으면 이 JWKS 파일의 키로 서명한 JWT 를 bearer 토큰으로 받는다.
+	JWKSFiles   []string
+	JWTIssuer   string
+	JWTAudience string
+	// 있으면 인가 결정을 Logging 과 같은 형식으로 이 파일에 남긴다. 없으면 설정한 로거에 "audit" 이름으로 남긴다.
+	AuditLogFile string
+	// 있으면 인가 결정을 이 토픽의 레코드로도 남긴다. 리더 노드의 결정만 남는다.
+	AuditTopic string
+	// 허용한 결정은 이 수만큼 중 하나만 기록한다. 0, 1 이면 모두, 음수면 기록하지 않는다.
+	AuditAllowedSampling int
+	// FSM 에 아직 적용하지 않은 raft 로그가 이보다 많으면 준비되지 않은 것으로 본다. 기본값 1000
+	MaxApplyLag uint64
+	// 있으면 이 주소에서 GET /metrics 로 Prometheus 형식의 지표를 제공한다. (예: ":9100")
+	MetricsAddr string
+	// 샘플링 비율과 span 을 보낼 곳, 기본값은 기록하지 않는다.
+	Tracing tracing.Config
+	// 있으면 다른 노드의 raft 에 이 함수로 연결한다. agenttest 가 네트워크 장애를 흉내낼 때 쓴다.
+	RaftDialer log.Dialer
+	// 로그 수준, 형식, 파일, 컴포넌트별 수준, 기본값은 info 수준으로 stderr 에 console 형식으로 쓴다.
+	// raft, serf, memberlist 의 로그도 이 로거로 남긴다.
+	Logging logging.Config
 }
 
+const (
+	defaultDrainTimeout      = 10 * time.Second
+	defaultACLReloadInterval = 10 * time.Second
+)
+
 func (c Config) RPCAddr() (string, error) {
 	host, _, err := net.SplitHostPort(c.BindAddr)
 	if err != nil {
@@ -61,10 +123,12 @@ func New(config Config) (*Agent, error) {
 
 	setup := []func() error{
 		a.setupLogger,
+		a.setupTracing,
 		a.setupMux,
 		a.setupLog,
 		a.setupServer,
 		a.setupMembership,
+		a.setupMetrics,
 	}
 
 	for _, fn := range setup {
@@ -77,12 +141,23 @@ func New(config Config) (*Agent, error) {
 	return a, nil
 }
 
+// 종료할 때 남은 span 을 보낸다.
+func (a *Agent) setupTracing() error {
+	stop, err := tracing.Setup(a.Config.Tracing)
+	if err != nil {
+		return err
+	}
+	a.stopTracing = stop
+	return nil
+}
+
 func (a *Agent) setupLogger() error {
-	logger, err := zap.NewDevelopment()
+	logger, closeLogger, err := logging.New(a.Config.Logging)
 	if err != nil {
 		return err
 	}
 	zap.ReplaceGlobals(logger)
+	a.closeLogger = closeLogger
 	return nil
 }
 
@@ -93,6 +168,7 @@ func (a *Agent) setupMux() error {
 	if err != nil {
 		return err
 	}
+	a.ln = ln
 	a.mux = cmux.New(ln)
 	return nil
 }
@@ -123,10 +199,14 @@ func (a *Agent) setupLog() error {
 		a.Config.ServerTLSConfig,
 		a.Config.PeerTLSConfig,
 	)
+	logConfig.Raft.StreamLayer.Dialer = a.Config.RaftDialer
 
 	logConfig.Raft.LocalID = raft.ServerID(a.Config.NodeName)
+	logConfig.Raft.Logger = logging.HCLog(zap.L().Named("raft"))
 	logConfig.Raft.Bootstrap = a.Config.Bootstrap
 	logConfig.Raft.CommitTimeout = 1000 * time.Millisecond
+	logConfig.Producer.IdleTimeout = a.Config.ProducerIdleTimeout
+	logConfig.Txn.Timeout = a.Config.TxnTimeout
 
 	var err error
 	a.log, err = log.NewDistributedLog(
@@ -146,22 +226,63 @@ func (a *Agent) setupServer() error {
 		a.Config.ACLModelFile,
 		a.Config.ACLPolicyFile,
 	)
+	interval := a.Config.ACLReloadInterval
+	if interval == 0 {
+		interval = defaultACLReloadInterval
+	}
+	a.stopACL = func() {}
+	a.stopQuota = func() {}
+	if interval > 0 {
+		a.stopACL = authorizer.Watch(interval)
+	}
+	var quotas server.Quotas
+	if a.Config.QuotaFile != "" {
+		limiter, err := quota.Load(a.Config.QuotaFile)
+		if err != nil {
+			return err
+		}
+		if interval > 0 {
+			a.stopQuota = limiter.Watch(interval)
+		}
+		quotas = limiter
+	}
+	a.drainer = server.NewDrainer()
+	authenticator, err := a.setupAuthenticator()
+	if err != nil {
+		return err
+	}
+	if err := a.setupAudit(); err != nil {
+		return err
+	}
 	serverConfig := &server.Config{
-		CommitLog:   a.log,
-		Authorizer:  authorizer,
-		GetServerer: a.log,
+		CommitLog:      a.log,
+		Authorizer:     authorizer,
+		Auditor:        a.audit,
+		Quotas:         quotas,
+		GetServerer:    a.log,
+		Administrator:  a.log,
+		ClusterWatcher: a.log,
+		Transactor:     a.log,
+		Drainer:        a.drainer,
+		Authenticator:  authenticator,
+		Health:         a,
 	}
+	/*
+		raft 가 아닌 연결은 gRPC 와 HTTP API 가 나눠 받는다.
+		TLS 를 쓰면 TLS 를 끝낸 뒤에야 둘을 구분할 수 있으므로 여기서 TLS 를 끝내고 gRPC 에는 그 상태만 넘긴다.
+	*/
+	ln := a.mux.Match(cmux.Any())
 	var opts []grpc.ServerOption
 	if a.Config.ServerTLSConfig != nil {
-		creds := credentials.NewTLS(a.Config.ServerTLSConfig)
-		opts = append(opts, grpc.Creds(creds))
+		ln = tls.NewListener(ln, a.Config.ServerTLSConfig)
+		opts = append(opts, grpc.Creds(server.TLSTerminatedCredentials()))
 	}
 
-	var err error
 	a.server, err = server.NewGRPCServer(serverConfig, opts...)
 	if err != nil {
 		return err
 	}
+	a.httpServer = server.NewHTTPServer(serverConfig)
 
 	/*
 		rpcAddr, err := a.RPCAddr()
@@ -182,15 +303,78 @@ func (a *Agent) setupServer() error {
 		}()
 	*/
 
-	grpcLn := a.mux.Match(cmux.Any())
+	apiMux := cmux.New(ln)
+	httpLn := apiMux.Match(cmux.HTTP1Fast())
+	grpcLn := apiMux.Match(cmux.Any())
 	go func() {
 		if err := a.server.Serve(grpcLn); err != nil {
 			_ = a.Shutdown()
 		}
 	}()
+	go func() {
+		if err := a.httpServer.Serve(httpLn); err != nil && err != http.ErrServerClosed {
+			_ = a.Shutdown()
+		}
+	}()
+	go apiMux.Serve()
 	return nil
 }
 
+func (a *Agent) setupAudit() error {
+	// 없으면 audit.New 가 설정한 로거의 "audit" 로거를 쓴다.
+	var logger *zap.Logger
+	if a.Config.AuditLogFile != "" {
+		// 형식, 수준, 파일 교체 설정은 에이전트의 로거와 같고 쓰는 곳만 다르다.
+		config := a.Config.Logging
+		config.Outputs = []string{a.Config.AuditLogFile}
+		auditLogger, closeAuditLogger, err := logging.New(config)
+		if err != nil {
+			return err
+		}
+		logger = auditLogger.Named("audit")
+		closeLogger := a.closeLogger
+		a.closeLogger = func() error {
+			err := closeAuditLogger()
+			if e := closeLogger(); e != nil && err == nil {
+				err = e
+			}
+			return err
+		}
+	}
+	a.audit = audit.New(audit.Config{
+		Logger:          logger,
+		CommitLog:       a.log,
+		Topic:           a.Config.AuditTopic,
+		AllowedSampling: a.Config.AuditAllowedSampling,
+	})
+	return nil
+}
+
+// 토큰은 API 키, JWT 순서로 확인하고, 토큰이 없으면 클라이언트 인증서로 인증한다.
+func (a *Agent) setupAuthenticator() (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+	if a.Config.APIKeyFile != "" {
+		keys, err := auth.NewAPIKeys(a.Config.APIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if len(a.Config.JWKSFiles) > 0 {
+		jwt, err := auth.NewJWT(auth.JWTConfig{
+			JWKSFiles: a.Config.JWKSFiles,
+			Issuer:    a.Config.JWTIssuer,
+			Audience:  a.Config.JWTAudience,
+		})
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	authenticators = append(authenticators, auth.MTLS())
+	return auth.Chain(authenticators...), nil
+}
+
 func (a *Agent) setupMembership() error {
 	rpcAddr, err := a.Config.RPCAddr()
 	if err != nil {
@@ -223,7 +407,7 @@ func (a *Agent) setupMembership() error {
 		return err
 	*/
 
-	a.membership, err = discovery.New(a.log, discovery.Config{
+	membership, err := discovery.New(a.log, discovery.Config{
 		NodeName: a.Config.NodeName,
 		BindAddr: a.Config.BindAddr,
 		Tags: map[string]string{
@@ -231,10 +415,29 @@ func (a *Agent) setupMembership() error {
 		},
 		StartJoinAddrs: a.Config.StartJoinAddrs,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	a.healthLock.Lock()
+	a.membership = membership
+	a.healthLock.Unlock()
+	return nil
 }
 
+// 진행 중인 요청을 마치고 클러스터를 떠난 뒤 멈춘다.
 func (a *Agent) Shutdown() error {
+	return a.stop(true)
+}
+
+/*
+drain 과 Serf leave 없이 바로 멈춘다.
+다른 노드는 프로세스가 죽었을 때처럼 raft heartbeat 와 Serf 장애 감지로 알아챈다. 노드 장애를 흉내내는 테스트에서 쓴다.
+*/
+func (a *Agent) Kill() error {
+	return a.stop(false)
+}
+
+func (a *Agent) stop(graceful bool) error {
 	a.shutdownLock.Lock()
 	defer a.shutdownLock.Unlock()
 	if a.shutdown {
@@ -242,15 +445,37 @@ func (a *Agent) Shutdown() error {
 	}
 	a.shutdown = true
 	close(a.shutdowns)
-	shutdown := []func() error{
-		a.membership.Leave,
+	var shutdown []func() error
+	if graceful {
+		shutdown = append(shutdown, a.drain, a.membership.Leave)
+	}
+	shutdown = append(shutdown,
+		a.membership.Shutdown,
 		// a.replicator.Close,
+		// gRPC 서버와 같은 리스너를 쓰므로, gRPC 서버가 리스너를 닫기 전에 닫는다.
+		a.httpServer.Close,
 		func() error {
+			if !a.drained {
+				// 끝나지 않은 스트림이 있으면 GracefulStop 은 영원히 기다리므로 바로 닫는다.
+				a.server.Stop()
+				return nil
+			}
 			a.server.GracefulStop()
 			return nil
 		},
+		a.closeMetrics,
+		a.audit.Close,
 		a.log.Close,
-	}
+		a.closeListener,
+		func() error {
+			a.stopACL()
+			a.stopQuota()
+			return nil
+		},
+		a.stopTracing,
+		// 종료하면서 남긴 로그까지 쓰고 로그 파일을 닫는다.
+		a.closeLogger,
+	)
 	for _, fn := range shutdown {
 		if err := fn(); err != nil {
 			return err
@@ -259,6 +484,103 @@ func (a *Agent) Shutdown() error {
 	return nil
 }
 
+// mux 가 나눠준 리스너를 모두 닫은 뒤 RPC 포트를 놓는다. 같은 포트로 다시 시작할 수 있다.
+func (a *Agent) closeListener() error {
+	if err := a.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+/*
+노드를 내리기 전에 클러스터가 선거 타임아웃을 기다리지 않고 바로 이어받을 수 있도록 한다.
+1. 리더라면 리더십을 다른 서버로 넘긴다.
+2. 새 Produce/스트림 요청을 거절하고, 진행 중인 요청을 DrainTimeout 까지 기다린다.
+3. raft 설정에서 자신을 제거한다.
+각 단계가 실패해도 종료는 계속 진행해야 하므로 에러를 로그로만 남긴다.
+*/
+func (a *Agent) drain() error {
+	logger := zap.L().Named("agent")
+	timeout := a.Config.DrainTimeout
+	if timeout == 0 {
+		timeout = defaultDrainTimeout
+	}
+
+	servers, err := a.log.GetServers()
+	if err != nil {
+		logger.Warn("failed to get servers", zap.Error(err))
+	}
+
+	if a.log.IsLeader() && len(servers) > 1 {
+		if err := a.log.TransferLeadership("", ""); err != nil {
+			logger.Warn("failed to transfer leadership", zap.Error(err))
+		}
+	}
+
+	if err := a.drainer.Drain(timeout); err != nil {
+		logger.Warn("failed to drain in-flight calls", zap.Error(err))
+	} else {
+		a.drained = true
+	}
+
+	if len(servers) > 1 {
+		if err := a.leaveRaft(timeout); err != nil {
+			logger.Warn("failed to remove server from raft", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// 설정 변경은 리더만 할 수 있으므로, 팔로워라면 리더의 Admin 서비스에 제거를 요청한다.
+func (a *Agent) leaveRaft(timeout time.Duration) error {
+	if a.log.IsLeader() {
+		return a.log.RemoveServer(a.Config.NodeName)
+	}
+
+	// 리더십을 넘긴 직후에는 새 리더를 아직 모를 수 있으므로 잠시 기다린다.
+	var leaderAddr string
+	deadline := time.Now().Add(timeout)
+	for {
+		servers, err := a.log.GetServers()
+		if err != nil {
+			return err
+		}
+		for _, srv := range servers {
+			if srv.IsLeader {
+				leaderAddr = srv.RpcAddr
+			}
+		}
+		if leaderAddr != "" {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no known leader")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	conn, err := grpc.Dial(leaderAddr, a.peerDialOptions()...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err = api.NewAdminClient(conn).RemoveServer(ctx, &api.RemoveServerRequest{
+		Id: a.Config.NodeName,
+	})
+	return err
+}
+
+// 다른 노드의 Admin 서비스에 연결할 때 쓴다.
+func (a *Agent) peerDialOptions() []grpc.DialOption {
+	if a.Config.PeerTLSConfig != nil {
+		return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(a.Config.PeerTLSConfig))}
+	}
+	return []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+}
+
 func (a *Agent) serve() error {
 	if err := a.mux.Serve(); err != nil {
 		_ = a.Shutdown()
@@ -4,80 +4,42 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
-	"os"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 	"time"
 
 	api "github.com/jhkim988/proglog/api/v1"
 	"github.com/jhkim988/proglog/internal/agent"
-	"github.com/jhkim988/proglog/internal/config"
+	"github.com/jhkim988/proglog/internal/agenttest"
 	"github.com/jhkim988/proglog/internal/loadbalance"
 	"github.com/stretchr/testify/require"
-	"github.com/travisjeffery/go-dynaport"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
 )
 
 func TestAgent(t *testing.T) {
-	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
-		CertFile:      config.ServerCertFile,
-		KeyFile:       config.ServerKeyFile,
-		CAFile:        config.CAFile,
-		Server:        true,
-		ServerAddress: "127.0.0.1",
-	})
-	require.NoError(t, err)
-
-	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
-		CertFile:      config.RootClientCertFile,
-		KeyFile:       config.RootClientKeyFile,
-		CAFile:        config.CAFile,
-		Server:        false,
-		ServerAddress: "127.0.0.1",
-	})
-	require.NoError(t, err)
-
-	var agents []*agent.Agent
-	for i := 0; i < 3; i++ {
-		ports := dynaport.Get(2)
-		bindAddr := fmt.Sprintf("%s:%d", "127.0.0.1", ports[0])
-		rpcPort := ports[1]
-
-		dataDir, err := os.MkdirTemp("", "agent-test-log")
+	cluster := agenttest.New(t, agenttest.Config{Nodes: 3})
+	agents := []*agent.Agent{cluster.Agent(0), cluster.Agent(1), cluster.Agent(2)}
+	peerTLSConfig := cluster.PeerTLSConfig
+
+	/* 모든 노드가 리더를 알고 멤버십에 참여하면 준비된 것이다. */
+	for _, agent := range agents {
+		require.NoError(t, agent.Ready())
+		rpcAddr, err := agent.Config.RPCAddr()
 		require.NoError(t, err)
-
-		var startJoinAddrs []string
-		if i != 0 {
-			startJoinAddrs = append(startJoinAddrs, agents[0].Config.BindAddr)
-		}
-		agent, err := agent.New(agent.Config{
-			NodeName:        fmt.Sprintf("%d", i),
-			StartJoinAddrs:  startJoinAddrs,
-			BindAddr:        bindAddr,
-			RPCPort:         rpcPort,
-			DataDir:         dataDir,
-			ACLModelFile:    config.ACLModelFile,
-			ACLPolicyFile:   config.ACLPolicyFile,
-			ServerTLSConfig: serverTLSConfig,
-			PeerTLSConfig:   peerTLSConfig,
-			Bootstrap:       i == 0,
-		})
+		conn, err := grpc.Dial(rpcAddr, grpc.WithTransportCredentials(credentials.NewTLS(peerTLSConfig)))
 		require.NoError(t, err)
-		agents = append(agents, agent)
+		health, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		conn.Close()
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
 	}
 
-	defer func() {
-		for _, agent := range agents {
-			err := agent.Shutdown()
-			require.NoError(t, err)
-			require.NoError(t, os.RemoveAll(agent.Config.DataDir))
-		}
-	}()
-
-	/* 서비스 발견을 기다린다. */
-	time.Sleep(3 * time.Second)
-
 	leaderClient := client(t, agents[0], peerTLSConfig)
 	produceResponse, err := leaderClient.Produce(
 		context.Background(),
@@ -89,30 +51,30 @@ func TestAgent(t *testing.T) {
 	)
 	require.NoError(t, err)
 
-	/* 복제를 기다린다. */
-	time.Sleep(3 * time.Second)
-
-	consumeResponse, err := leaderClient.Consume(
-		context.Background(),
-		&api.ConsumeRequest{
-			Offset: produceResponse.Offset,
-		},
-	)
-	require.NoError(t, err)
+	/* 로드밸런서는 읽기를 팔로워로 보내므로 복제될 때까지 기다린다. */
+	var consumeResponse *api.ConsumeResponse
+	require.Eventually(t, func() bool {
+		consumeResponse, err = leaderClient.Consume(
+			context.Background(),
+			&api.ConsumeRequest{
+				Offset: produceResponse.Offset,
+			},
+		)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
 	require.Equal(t, consumeResponse.Record.Value, []byte("foo"))
 
-	/* 복제를 기다린다. */
-	time.Sleep(3 * time.Second)
-
-	followerClient := client(t, agents[1], peerTLSConfig)
-
-	consumeResponse, err = followerClient.Consume(
-		context.Background(),
-		&api.ConsumeRequest{
-			Offset: produceResponse.Offset,
-		},
-	)
-	require.NoError(t, err)
+	/* 팔로워에 복제될 때까지 기다린다. */
+	followerClient := cluster.Client(1)
+	require.Eventually(t, func() bool {
+		consumeResponse, err = followerClient.Consume(
+			context.Background(),
+			&api.ConsumeRequest{
+				Offset: produceResponse.Offset,
+			},
+		)
+		return err == nil
+	}, 5*time.Second, 50*time.Millisecond)
 	require.Equal(t, consumeResponse.Record.Value, []byte("foo"))
 
 	consumeResponse, err = leaderClient.Consume(context.Background(), &api.ConsumeRequest{
@@ -123,6 +85,142 @@ func TestAgent(t *testing.T) {
 	got := status.Code(err)
 	want := status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err())
 	require.Equal(t, got, want)
+
+	/* 같은 포트에서 HTTP API 로도 읽을 수 있다. */
+	rpcAddr, err := agents[0].Config.RPCAddr()
+	require.NoError(t, err)
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: peerTLSConfig}}
+	res, err := httpClient.Get(fmt.Sprintf("https://%s/v1/records/%d", rpcAddr, produceResponse.Offset))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	httpConsume := &api.ConsumeResponse{}
+	require.NoError(t, protojson.Unmarshal(body, httpConsume))
+	require.Equal(t, []byte("foo"), httpConsume.Record.Value)
+
+	res, err = httpClient.Get(fmt.Sprintf("https://%s/v1/records/%d", rpcAddr, produceResponse.Offset+1))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = httpClient.Get(fmt.Sprintf("https://%s/readyz", rpcAddr))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	/* 리더의 /metrics 에는 로그, raft, Serf, gRPC 지표와 팔로워별 복제 지연이 있다. */
+	wants := []string{
+		"proglog_log_appends",
+		"proglog_log_append_bytes_bucket",
+		"proglog_log_reads",
+		"proglog_raft_commit_latency_bucket",
+		"proglog_raft_fsm_apply_latency_bucket",
+		"proglog_log_segments",
+		"proglog_log_disk_bytes",
+		"proglog_raft_leader 1",
+		`proglog_raft_state{state="Leader"} 1`,
+		`proglog_serf_members{status="alive"} 3`,
+		`proglog_raft_follower_up{follower="1"} 1`,
+		`proglog_raft_replication_lag_entries{follower="1"} 0`,
+		`proglog_raft_replication_lag_entries{follower="2"} 0`,
+		"grpc_io_server_server_latency_bucket",
+	}
+	/* 두 팔로워가 모두 따라잡아야 복제 지연이 0 이 된다. */
+	var metrics string
+	require.Eventually(t, func() bool {
+		res, err := http.Get(fmt.Sprintf("http://%s/metrics", agents[0].Config.MetricsAddr))
+		if err != nil {
+			return false
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || res.StatusCode != http.StatusOK {
+			return false
+		}
+		metrics = string(body)
+		for _, want := range wants {
+			if !strings.Contains(metrics, want) {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 100*time.Millisecond)
+	for _, want := range wants {
+		require.Contains(t, metrics, want)
+	}
+
+	/* 종료를 시작한 노드는 살아 있지도, 준비되지도 않았다. */
+	require.NoError(t, agents[2].Shutdown())
+	require.Error(t, agents[2].Live())
+	require.Error(t, agents[2].Ready())
+}
+
+func TestAgentShutdownDrainsNode(t *testing.T) {
+	cluster := agenttest.New(t, agenttest.Config{Nodes: 3})
+	agents := []*agent.Agent{cluster.Agent(0), cluster.Agent(1), cluster.Agent(2)}
+
+	/* 리더를 내리면 리더십을 넘기고 raft 설정에서 빠진다. */
+	require.NoError(t, agents[0].Shutdown())
+
+	followerClient := cluster.Client(1)
+
+	var servers []*api.Server
+	require.Eventually(t, func() bool {
+		res, err := followerClient.GetServers(context.Background(), &api.GetServersRequest{})
+		if err != nil {
+			return false
+		}
+		servers = res.Servers
+		return len(servers) == 2
+	}, 3*time.Second, 100*time.Millisecond)
+
+	var leaders int
+	for _, server := range servers {
+		require.NotEqual(t, agents[0].Config.NodeName, server.Id)
+		if server.IsLeader {
+			leaders++
+		}
+	}
+	require.Equal(t, 1, leaders)
+}
+
+func TestAgentReadyWaitsForLeaderCommit(t *testing.T) {
+	cluster := agenttest.New(t, agenttest.Config{
+		Nodes: 3,
+		Configure: func(node int, config *agent.Config) {
+			config.MaxApplyLag = 10
+		},
+	})
+	leader, err := cluster.WaitForLeader()
+	require.NoError(t, err)
+	follower := cluster.Others(leader)[0]
+
+	/* 떨어진 동안 놓친 레코드를 늦게 받으면, 리더를 알더라도 따라잡을 때까지 준비되지 않은 것이다. */
+	cluster.Partition([]int{follower}, cluster.Others(follower))
+	for i := 0; i < 500; i++ {
+		_, err := cluster.Client(leader).Produce(context.Background(), &api.ProduceRequest{
+			Record: &api.Record{Value: []byte("missed")},
+		})
+		require.NoError(t, err)
+	}
+	for _, node := range cluster.Others(follower) {
+		cluster.SetLatency(node, follower, 200*time.Millisecond)
+	}
+	cluster.Heal()
+
+	require.Eventually(t, func() bool {
+		err := cluster.Agent(follower).Ready()
+		return err != nil && strings.Contains(err.Error(), "behind")
+	}, 10*time.Second, 10*time.Millisecond)
+
+	for _, node := range cluster.Others(follower) {
+		cluster.SetLatency(node, follower, 0)
+	}
+	require.Eventually(t, func() bool {
+		return cluster.Agent(follower).Ready() == nil
+	}, 10*time.Second, 50*time.Millisecond)
 }
 
 func client(t *testing.T, agent *agent.Agent, tlsConfig *tls.Config) api.LogClient {
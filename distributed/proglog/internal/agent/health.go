@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jhkim988/proglog/internal/server"
+)
+
+const defaultMaxApplyLag = 1000
+
+var _ server.HealthChecker = (*Agent)(nil)
+
+// 종료를 시작하지 않았으면 살아 있다.
+func (a *Agent) Live() error {
+	select {
+	case <-a.shutdowns:
+		return errors.New("agent is shutting down")
+	default:
+		return nil
+	}
+}
+
+/*
+다음을 모두 만족하면 요청을 받을 준비가 된 것이다.
+1. raft 가 리더를 알고 있다. (리더가 없으면 쓰기도, 최신 읽기도 할 수 없다.)
+2. FSM 이 리더가 커밋한 raft 로그를 MaxApplyLag 안쪽까지 적용했다. (팔로워는 리더가 알려 준 커밋 인덱스로 잰다.)
+3. Serf 멤버십이 살아 있다. (다른 노드가 이 노드를 찾을 수 있다.)
+*/
+func (a *Agent) Ready() error {
+	if err := a.Live(); err != nil {
+		return err
+	}
+	if a.log.Leader() == "" {
+		return errors.New("no known raft leader")
+	}
+	maxLag := a.Config.MaxApplyLag
+	if maxLag == 0 {
+		maxLag = defaultMaxApplyLag
+	}
+	if lag := a.log.ApplyLag(); lag > maxLag {
+		return fmt.Errorf("fsm is %d entries behind the raft log", lag)
+	}
+	a.healthLock.RLock()
+	membership := a.membership
+	a.healthLock.RUnlock()
+	if membership == nil || !membership.Alive() {
+		return errors.New("serf membership is not alive")
+	}
+	return nil
+}
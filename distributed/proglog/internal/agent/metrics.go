@@ -0,0 +1,322 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	ocprometheus "contrib.go.opencensus.io/exporter/prometheus"
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.opencensus.io/stats/view"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+const followerStatsTimeout = 2 * time.Second
+
+/*
+MetricsAddr 에서 GET /metrics 로 Prometheus 텍스트 형식의 지표를 제공한다.
+  - OpenCensus 뷰: gRPC 서버 지연 시간(grpc_io_server_*), 레코드 추가/읽기 횟수와 크기, raft 커밋/FSM 적용 지연 시간,
+    인증서 만료 시각
+  - 수집할 때 읽는 값: 세그먼트 수와 디스크 사용량, raft 상태, Serf 멤버 수, 리더에서 본 팔로워별 복제 지연
+*/
+func (a *Agent) setupMetrics() error {
+	if a.Config.MetricsAddr == "" {
+		return nil
+	}
+	if err := view.Register(log.Views...); err != nil {
+		return err
+	}
+	logger := zap.L().Named("metrics")
+
+	// 수집기 지표는 에이전트마다 레지스트리를 따로 써서 자기 것만 보인다.
+	// OpenCensus 뷰는 프로세스 전역이라, 한 프로세스에 여러 에이전트가 있으면 (테스트) 모두 합친 값이 보인다.
+	registry := prometheus.NewRegistry()
+	a.metrics = &agentCollector{agent: a, logger: logger, conns: make(map[string]*grpc.ClientConn)}
+	if err := registry.Register(a.metrics); err != nil {
+		return err
+	}
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	exporter, err := ocprometheus.NewExporter(ocprometheus.Options{
+		Registry: registry,
+		OnError: func(err error) {
+			logger.Warn("failed to export opencensus views", zap.Error(err))
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", a.Config.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	a.metricsServer = &http.Server{Handler: mux}
+	go func() {
+		if err := a.metricsServer.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("metrics server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (a *Agent) closeMetrics() error {
+	if a.metricsServer == nil {
+		return nil
+	}
+	err := a.metricsServer.Close()
+	a.metrics.close()
+	return err
+}
+
+var (
+	segmentsDesc = prometheus.NewDesc(
+		"proglog_log_segments",
+		"Number of segments in the local log.",
+		nil, nil,
+	)
+	diskBytesDesc = prometheus.NewDesc(
+		"proglog_log_disk_bytes",
+		"Bytes written to the store and index files of the local log.",
+		nil, nil,
+	)
+	raftLeaderDesc = prometheus.NewDesc(
+		"proglog_raft_leader",
+		"1 if this node is the raft leader, 0 otherwise.",
+		nil, nil,
+	)
+	raftStateDesc = prometheus.NewDesc(
+		"proglog_raft_state",
+		"Current raft state of this node, 1 for the current state.",
+		[]string{"state"}, nil,
+	)
+	raftIndexDesc = prometheus.NewDesc(
+		"proglog_raft_index",
+		"Raft log indexes of this node.",
+		[]string{"kind"}, nil,
+	)
+	raftTermDesc = prometheus.NewDesc(
+		"proglog_raft_term",
+		"Current raft term.",
+		nil, nil,
+	)
+	raftApplyLagDesc = prometheus.NewDesc(
+		"proglog_raft_apply_lag_entries",
+		"Raft log entries stored but not yet applied to the FSM.",
+		nil, nil,
+	)
+	serfMembersDesc = prometheus.NewDesc(
+		"proglog_serf_members",
+		"Number of Serf members by status.",
+		[]string{"status"}, nil,
+	)
+	replicationLagDesc = prometheus.NewDesc(
+		"proglog_raft_replication_lag_entries",
+		"Raft log entries the follower is behind the leader, reported by the leader only.",
+		[]string{"follower"}, nil,
+	)
+	followerUpDesc = prometheus.NewDesc(
+		"proglog_raft_follower_up",
+		"1 if the leader could read the follower's raft stats, 0 otherwise.",
+		[]string{"follower"}, nil,
+	)
+)
+
+var (
+	raftStates  = []string{"Follower", "Candidate", "Leader", "Shutdown"}
+	raftIndexes = []string{"last_log_index", "commit_index", "applied_index", "last_snapshot_index"}
+	serfStatus  = []string{"alive", "leaving", "left", "failed"}
+)
+
+// 수집할 때마다 로그, raft, Serf 에서 값을 읽는다.
+type agentCollector struct {
+	agent  *Agent
+	logger *zap.Logger
+	mu     sync.Mutex
+	// 팔로워 RPC 주소별 연결, 수집할 때마다 다시 맺지 않는다.
+	conns map[string]*grpc.ClientConn
+}
+
+var _ prometheus.Collector = (*agentCollector)(nil)
+
+func (c *agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		segmentsDesc, diskBytesDesc, raftLeaderDesc, raftStateDesc, raftIndexDesc,
+		raftTermDesc, raftApplyLagDesc, serfMembersDesc, replicationLagDesc, followerUpDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (c *agentCollector) Collect(ch chan<- prometheus.Metric) {
+	a := c.agent
+	gauge := func(desc *prometheus.Desc, value float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+	}
+
+	usage := a.log.Usage()
+	gauge(segmentsDesc, float64(usage.Segments))
+	gauge(diskBytesDesc, float64(usage.Bytes))
+
+	stats := a.log.Stats()
+	leader := a.log.IsLeader()
+	gauge(raftLeaderDesc, boolValue(leader))
+	for _, state := range raftStates {
+		gauge(raftStateDesc, boolValue(stats["state"] == state), state)
+	}
+	for _, kind := range raftIndexes {
+		if v, err := strconv.ParseUint(stats[kind], 10, 64); err == nil {
+			gauge(raftIndexDesc, float64(v), kind)
+		}
+	}
+	if term, err := strconv.ParseUint(stats["term"], 10, 64); err == nil {
+		gauge(raftTermDesc, float64(term))
+	}
+	gauge(raftApplyLagDesc, float64(a.log.ApplyLag()))
+
+	a.healthLock.RLock()
+	membership := a.membership
+	a.healthLock.RUnlock()
+	if membership != nil {
+		counts := make(map[string]int)
+		for _, member := range membership.Members() {
+			counts[member.Status.String()]++
+		}
+		for _, status := range serfStatus {
+			gauge(serfMembersDesc, float64(counts[status]), status)
+		}
+	}
+
+	if leader {
+		last, err := strconv.ParseUint(stats["last_log_index"], 10, 64)
+		if err != nil {
+			return
+		}
+		for id, followerLast := range c.followerIndexes() {
+			if followerLast < 0 {
+				gauge(followerUpDesc, 0, id)
+				continue
+			}
+			gauge(followerUpDesc, 1, id)
+			lag := float64(0)
+			if uint64(followerLast) < last {
+				lag = float64(last - uint64(followerLast))
+			}
+			gauge(replicationLagDesc, lag, id)
+		}
+	}
+}
+
+/*
+팔로워마다 Admin GetRaftStats 로 마지막 로그 인덱스를 읽는다. 읽지 못한 팔로워는 -1 이다.
+raft 는 리더가 아는 팔로워의 인덱스를 알려주지 않으므로 수집할 때마다 팔로워에게 묻는다.
+연결은 다음 수집 때 다시 쓰고, 클러스터에서 빠진 팔로워의 연결은 닫는다.
+*/
+func (c *agentCollector) followerIndexes() map[string]int64 {
+	servers, err := c.agent.log.GetServers()
+	if err != nil {
+		c.logger.Warn("failed to get servers", zap.Error(err))
+		return nil
+	}
+	c.pruneConns(servers)
+	ctx, cancel := context.WithTimeout(context.Background(), followerStatsTimeout)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		indexes = make(map[string]int64)
+	)
+	for _, server := range servers {
+		if server.Id == c.agent.Config.NodeName {
+			continue
+		}
+		wg.Add(1)
+		go func(server *api.Server) {
+			defer wg.Done()
+			index, err := c.followerIndex(ctx, server.RpcAddr)
+			if err != nil {
+				c.logger.Debug("failed to get follower raft stats", zap.String("follower", server.Id), zap.Error(err))
+				index = -1
+			}
+			mu.Lock()
+			indexes[server.Id] = index
+			mu.Unlock()
+		}(server)
+	}
+	wg.Wait()
+	return indexes
+}
+
+func (c *agentCollector) followerIndex(ctx context.Context, addr string) (int64, error) {
+	conn, err := c.conn(addr)
+	if err != nil {
+		return 0, err
+	}
+	res, err := api.NewAdminClient(conn).GetRaftStats(ctx, &api.GetRaftStatsRequest{})
+	if err != nil {
+		return 0, err
+	}
+	index, err := strconv.ParseInt(res.Stats["last_log_index"], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return index, nil
+}
+
+// grpc.Dial 은 연결을 기다리지 않으므로 락을 잡고 맺어도 된다.
+func (c *agentCollector) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(addr, c.agent.peerDialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+func (c *agentCollector) pruneConns(servers []*api.Server) {
+	current := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		current[server.RpcAddr] = true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		if !current[addr] {
+			_ = conn.Close()
+			delete(c.conns, addr)
+		}
+	}
+}
+
+func (c *agentCollector) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		_ = conn.Close()
+		delete(c.conns, addr)
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
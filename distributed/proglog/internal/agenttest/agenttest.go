@@ -0,0 +1,390 @@
+/*
+한 프로세스 안에서 에이전트 여러 개로 클러스터를 띄우는 테스트 도구
+리더 선출과 멤버십을 정해진 시간 동안 폴링해서 기다리고, 노드 장애, 네트워크 분할, 지연을 흉내낸다.
+
+	c := agenttest.New(t, agenttest.Config{Nodes: 3})
+	leader, err := c.WaitForLeader()
+	c.Partition([]int{leader}, c.Others(leader))
+*/
+package agenttest
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/agent"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+	"github.com/travisjeffery/go-dynaport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	defaultNodes   = 3
+	defaultTimeout = 10 * time.Second
+	pollInterval   = 50 * time.Millisecond
+)
+
+type Config struct {
+	// 노드 수, 기본값 3
+	Nodes int
+	// 노드마다 agent.Config 를 고친다. 주소, 데이터 디렉터리, TLS, ACL 파일, RaftDialer 는 이미 채워져 있다.
+	Configure func(node int, config *agent.Config)
+	// WaitFor* 가 기다리는 최대 시간, 기본값 10s
+	Timeout time.Duration
+}
+
+/*
+노드 i 의 NodeName 은 "i" 이다. 0 번 노드가 클러스터를 부트스트랩한다.
+모든 노드는 config 패키지의 테스트 인증서로 TLS 를 쓰고, 클라이언트는 root 인증서로 연결한다.
+*/
+type Cluster struct {
+	t               testing.TB
+	timeout         time.Duration
+	network         *network
+	ServerTLSConfig *tls.Config
+	PeerTLSConfig   *tls.Config
+
+	configs []agent.Config
+	// 멈춘 노드는 nil
+	agents []*agent.Agent
+	conns  []*grpc.ClientConn
+}
+
+// 노드를 모두 띄우고 리더와 멤버십이 갖춰질 때까지 기다린다. 테스트가 끝나면 모든 노드를 멈춘다.
+func New(t testing.TB, c Config) *Cluster {
+	t.Helper()
+	if c.Nodes == 0 {
+		c.Nodes = defaultNodes
+	}
+	if c.Timeout == 0 {
+		c.Timeout = defaultTimeout
+	}
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		Server:        true,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+	peerTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.RootClientCertFile,
+		KeyFile:       config.RootClientKeyFile,
+		CAFile:        config.CAFile,
+		Server:        false,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	cluster := &Cluster{
+		t:               t,
+		timeout:         c.Timeout,
+		network:         newNetwork(),
+		ServerTLSConfig: serverTLSConfig,
+		PeerTLSConfig:   peerTLSConfig,
+		configs:         make([]agent.Config, c.Nodes),
+		agents:          make([]*agent.Agent, c.Nodes),
+		conns:           make([]*grpc.ClientConn, c.Nodes),
+	}
+	// 데이터 디렉터리를 먼저 만들어야 t.Cleanup 순서상 노드를 멈춘 뒤에 지운다.
+	dataDir := t.TempDir()
+	t.Cleanup(cluster.Shutdown)
+
+	for i := 0; i < c.Nodes; i++ {
+		ports := dynaport.Get(3)
+		nodeConfig := agent.Config{
+			NodeName:        fmt.Sprintf("%d", i),
+			BindAddr:        fmt.Sprintf("127.0.0.1:%d", ports[0]),
+			RPCPort:         ports[1],
+			MetricsAddr:     fmt.Sprintf("127.0.0.1:%d", ports[2]),
+			DataDir:         filepath.Join(dataDir, fmt.Sprintf("%d", i)),
+			ACLModelFile:    config.ACLModelFile,
+			ACLPolicyFile:   config.ACLPolicyFile,
+			ServerTLSConfig: serverTLSConfig,
+			PeerTLSConfig:   peerTLSConfig,
+			Bootstrap:       i == 0,
+			DrainTimeout:    time.Second,
+			StartJoinAddrs:  cluster.joinAddrs(i),
+			RaftDialer:      cluster.network.dialer(i),
+		}
+		if c.Configure != nil {
+			c.Configure(i, &nodeConfig)
+		}
+		require.NoError(t, os.MkdirAll(nodeConfig.DataDir, 0755))
+		rpcAddr, err := nodeConfig.RPCAddr()
+		require.NoError(t, err)
+		require.NoError(t, cluster.network.register(i, rpcAddr))
+
+		a, err := agent.New(nodeConfig)
+		require.NoError(t, err)
+		cluster.configs[i] = nodeConfig
+		cluster.agents[i] = a
+	}
+
+	_, err = cluster.WaitForLeader()
+	require.NoError(t, err)
+	require.NoError(t, cluster.WaitForMembers())
+	return cluster
+}
+
+// 실행 중인 노드, 멈춘 노드는 nil
+func (c *Cluster) Agent(node int) *agent.Agent {
+	return c.agents[node]
+}
+
+// 실행 중인 노드 번호
+func (c *Cluster) Live() []int {
+	var nodes []int
+	for i, a := range c.agents {
+		if a != nil {
+			nodes = append(nodes, i)
+		}
+	}
+	return nodes
+}
+
+// node 를 뺀 나머지 노드 번호, 멈춘 노드도 포함한다.
+func (c *Cluster) Others(node int) []int {
+	var nodes []int
+	for i := range c.agents {
+		if i != node {
+			nodes = append(nodes, i)
+		}
+	}
+	return nodes
+}
+
+func (c *Cluster) RPCAddr(node int) string {
+	rpcAddr, err := c.configs[node].RPCAddr()
+	require.NoError(c.t, err)
+	return rpcAddr
+}
+
+// node 에 바로 연결하는 클라이언트, 연결은 재사용하고 노드를 멈추면 닫는다.
+func (c *Cluster) Client(node int) api.LogClient {
+	return api.NewLogClient(c.conn(node))
+}
+
+func (c *Cluster) AdminClient(node int) api.AdminClient {
+	return api.NewAdminClient(c.conn(node))
+}
+
+func (c *Cluster) conn(node int) *grpc.ClientConn {
+	if c.conns[node] == nil {
+		conn, err := grpc.Dial(
+			c.RPCAddr(node),
+			grpc.WithTransportCredentials(credentials.NewTLS(c.PeerTLSConfig)),
+		)
+		require.NoError(c.t, err)
+		c.conns[node] = conn
+	}
+	return c.conns[node]
+}
+
+func (c *Cluster) closeConn(node int) {
+	if c.conns[node] != nil {
+		_ = c.conns[node].Close()
+		c.conns[node] = nil
+	}
+}
+
+// drain 과 Serf leave 없이 노드를 멈춘다. (agent.Kill)
+func (c *Cluster) Kill(node int) error {
+	a := c.agents[node]
+	if a == nil {
+		return fmt.Errorf("agenttest: node %d is not running", node)
+	}
+	c.closeConn(node)
+	c.agents[node] = nil
+	return a.Kill()
+}
+
+// 멈춘 노드를 같은 주소와 데이터 디렉터리로 다시 띄우고, 실행 중인 노드를 통해 클러스터에 다시 들어간다.
+func (c *Cluster) Restart(node int) error {
+	if c.agents[node] != nil {
+		return fmt.Errorf("agenttest: node %d is running", node)
+	}
+	nodeConfig := c.configs[node]
+	// raft 상태가 이미 있으므로 다시 부트스트랩하지 않는다.
+	nodeConfig.Bootstrap = false
+	nodeConfig.StartJoinAddrs = c.joinAddrs(node)
+	a, err := agent.New(nodeConfig)
+	if err != nil {
+		return err
+	}
+	c.agents[node] = a
+	return nil
+}
+
+func (c *Cluster) joinAddrs(node int) []string {
+	var addrs []string
+	for i, a := range c.agents {
+		if a != nil && i != node {
+			addrs = append(addrs, c.configs[i].BindAddr)
+		}
+	}
+	return addrs
+}
+
+/*
+서로 다른 그룹에 속한 노드 사이의 raft 연결을 끊는다. 어느 그룹에도 없는 노드의 연결은 그대로 둔다.
+이미 끊은 링크는 Heal 할 때까지 끊긴 채로 남는다.
+*/
+func (c *Cluster) Partition(groups ...[]int) {
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					c.network.block(a, b)
+				}
+			}
+		}
+	}
+}
+
+// 끊은 링크를 모두 잇는다. 지연은 그대로 둔다.
+func (c *Cluster) Heal() {
+	c.network.heal()
+}
+
+// from 이 to 로 보내는 raft 메시지를 d 만큼 늦춘다. 0 이면 지연을 없앤다.
+func (c *Cluster) SetLatency(from, to int, d time.Duration) {
+	c.network.setLatency(link{from, to}, d)
+}
+
+// 모든 노드 사이의 raft 메시지를 d 만큼 늦춘다. 0 이면 지연을 없앤다.
+func (c *Cluster) SetLatencyAll(d time.Duration) {
+	c.network.setAllLatency(d)
+}
+
+/*
+nodes (기본값은 실행 중인 모든 노드) 가 모두 같은 리더를 알고, 그 리더가 nodes 중 하나일 때까지 기다린다.
+분할한 뒤에는 리더를 뽑을 수 있는 쪽의 노드를 넘긴다.
+*/
+func (c *Cluster) WaitForLeader(nodes ...int) (int, error) {
+	if len(nodes) == 0 {
+		nodes = c.Live()
+	}
+	var leader int
+	err := c.waitFor("leader", func() error {
+		var err error
+		leader, err = c.leaderOf(nodes)
+		return err
+	})
+	return leader, err
+}
+
+func (c *Cluster) leaderOf(nodes []int) (int, error) {
+	leaderID := ""
+	for _, node := range nodes {
+		servers, err := c.servers(node)
+		if err != nil {
+			return 0, err
+		}
+		id := ""
+		for _, server := range servers {
+			if server.IsLeader {
+				id = server.Id
+			}
+		}
+		if id == "" {
+			return 0, fmt.Errorf("node %d knows no leader", node)
+		}
+		if leaderID != "" && id != leaderID {
+			return 0, fmt.Errorf("node %d follows %s, not %s", node, id, leaderID)
+		}
+		leaderID = id
+	}
+	for _, node := range nodes {
+		if c.configs[node].NodeName == leaderID {
+			return node, nil
+		}
+	}
+	return 0, fmt.Errorf("leader %s is not one of %v", leaderID, nodes)
+}
+
+/*
+실행 중인 노드가 모두 준비되고 (agent.Ready), 각 노드의 raft 설정에 실행 중인 노드만 있을 때까지 기다린다.
+멈춘 노드는 Serf 가 장애를 감지해서 리더가 raft 설정에서 뺄 때까지 남아 있다.
+*/
+func (c *Cluster) WaitForMembers() error {
+	return c.waitFor("members", func() error {
+		live := c.Live()
+		var want []string
+		for _, node := range live {
+			want = append(want, c.configs[node].NodeName)
+		}
+		sort.Strings(want)
+		for _, node := range live {
+			if err := c.agents[node].Ready(); err != nil {
+				return fmt.Errorf("node %d is not ready: %w", node, err)
+			}
+			servers, err := c.servers(node)
+			if err != nil {
+				return err
+			}
+			var got []string
+			for _, server := range servers {
+				got = append(got, server.Id)
+			}
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				return fmt.Errorf("node %d has servers %v, want %v", node, got, want)
+			}
+		}
+		return nil
+	})
+}
+
+// 노드가 아는 raft 설정, 리더 표시는 그 노드가 아는 리더다.
+func (c *Cluster) servers(node int) ([]*api.Server, error) {
+	if c.agents[node] == nil {
+		return nil, fmt.Errorf("node %d is not running", node)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	res, err := c.Client(node).GetServers(ctx, &api.GetServersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return res.Servers, nil
+}
+
+// 조건이 만족될 때까지 폴링한다. 시간이 지나면 마지막 에러를 리턴한다.
+func (c *Cluster) waitFor(what string, cond func() error) error {
+	deadline := time.Now().Add(c.timeout)
+	for {
+		err := cond()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("agenttest: timed out waiting for %s: %w", what, err)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// 실행 중인 노드를 모두 멈추고 클라이언트 연결을 닫는다. New 가 t.Cleanup 에 등록한다.
+func (c *Cluster) Shutdown() {
+	for i, a := range c.agents {
+		c.closeConn(i)
+		if a == nil {
+			continue
+		}
+		c.agents[i] = nil
+		if err := a.Kill(); err != nil {
+			c.t.Errorf("agenttest: failed to stop node %d: %v", i, err)
+		}
+	}
+}
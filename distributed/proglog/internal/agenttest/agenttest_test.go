@@ -0,0 +1,94 @@
+package agenttest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/agenttest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKillRestart(t *testing.T) {
+	c := agenttest.New(t, agenttest.Config{Nodes: 3})
+	leader, err := c.WaitForLeader()
+	require.NoError(t, err)
+
+	/* 리더가 죽으면 남은 두 노드가 새 리더를 뽑는다. */
+	require.NoError(t, c.Kill(leader))
+	others := c.Others(leader)
+	newLeader, err := c.WaitForLeader(others...)
+	require.NoError(t, err)
+	require.NotEqual(t, leader, newLeader)
+
+	offset := produce(t, c, newLeader, "after kill")
+
+	/* 다시 띄운 노드는 클러스터에 들어가서 놓친 레코드를 따라잡는다. */
+	require.NoError(t, c.Restart(leader))
+	require.NoError(t, c.WaitForMembers())
+	_, err = c.WaitForLeader()
+	require.NoError(t, err)
+	waitForRecord(t, c, leader, offset, "after kill")
+}
+
+func TestPartition(t *testing.T) {
+	c := agenttest.New(t, agenttest.Config{Nodes: 3})
+	leader, err := c.WaitForLeader()
+	require.NoError(t, err)
+	majority := c.Others(leader)
+
+	/* 리더를 떼어내면 과반인 쪽이 새 리더를 뽑고, 떨어진 리더는 쓰기를 커밋하지 못한다. */
+	c.Partition([]int{leader}, majority)
+	newLeader, err := c.WaitForLeader(majority...)
+	require.NoError(t, err)
+	require.NotEqual(t, leader, newLeader)
+
+	offset := produce(t, c, newLeader, "during partition")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = c.Client(leader).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("lost")}})
+	require.Error(t, err)
+
+	/* 다시 이으면 모두 한 리더를 따르고, 떨어졌던 노드도 레코드를 따라잡는다. */
+	c.Heal()
+	_, err = c.WaitForLeader()
+	require.NoError(t, err)
+	waitForRecord(t, c, leader, offset, "during partition")
+}
+
+func TestLatency(t *testing.T) {
+	c := agenttest.New(t, agenttest.Config{Nodes: 3})
+	leader, err := c.WaitForLeader()
+	require.NoError(t, err)
+
+	/* 커밋하려면 팔로워에게 로그를 보내야 하므로 쓰기가 지연만큼 늦어진다. */
+	latency := 200 * time.Millisecond
+	c.SetLatencyAll(latency)
+	start := time.Now()
+	offset := produce(t, c, leader, "slow")
+	require.GreaterOrEqual(t, time.Since(start), latency)
+
+	c.SetLatencyAll(0)
+	for _, node := range c.Others(leader) {
+		waitForRecord(t, c, node, offset, "slow")
+	}
+}
+
+func produce(t *testing.T, c *agenttest.Cluster, node int, value string) uint64 {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := c.Client(node).Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}})
+	require.NoError(t, err)
+	return res.Offset
+}
+
+func waitForRecord(t *testing.T, c *agenttest.Cluster, node int, offset uint64, value string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		res, err := c.Client(node).Consume(context.Background(), &api.ConsumeRequest{Offset: offset})
+		return err == nil && string(res.Record.Value) == value
+	}, 10*time.Second, 50*time.Millisecond)
+}
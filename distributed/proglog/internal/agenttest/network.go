@@ -0,0 +1,173 @@
+package agenttest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jhkim988/proglog/internal/log"
+)
+
+var errPartitioned = errors.New("agenttest: link is partitioned")
+
+// 연결을 맺은 노드에서 받는 노드로 가는 방향
+type link struct {
+	from, to int
+}
+
+/*
+노드 사이의 raft 연결을 가로챈다.
+각 노드의 log.StreamLayer 가 network 의 Dialer 로 연결을 맺으므로, 끊긴 링크로는 연결을 맺지 못하고
+이미 맺은 연결은 끊을 때 닫는다. 지연은 연결을 맺은 쪽이 쓸 때마다 더한다.
+Serf 와 gRPC 클라이언트 연결은 가로채지 않는다.
+*/
+type network struct {
+	mu sync.Mutex
+	// RPC 포트로 노드를 찾는다. 부트스트랩한 노드는 raft 설정에 리스너 주소 ([::]:포트) 로 남으므로 호스트는 보지 않는다.
+	ports   map[string]int
+	blocked map[link]bool
+	latency map[link]time.Duration
+	conns   map[*faultConn]struct{}
+}
+
+func newNetwork() *network {
+	return &network{
+		ports:   make(map[string]int),
+		blocked: make(map[link]bool),
+		latency: make(map[link]time.Duration),
+		conns:   make(map[*faultConn]struct{}),
+	}
+}
+
+func (n *network) register(node int, rpcAddr string) error {
+	_, port, err := net.SplitHostPort(rpcAddr)
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.ports[port] = node
+	return nil
+}
+
+// from 노드의 StreamLayer 가 쓰는 Dialer
+func (n *network) dialer(from int) log.Dialer {
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		to, known := n.node(addr)
+		if known && n.isBlocked(link{from, to}) {
+			return nil, errPartitioned
+		}
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil || !known {
+			return conn, err
+		}
+		fc := &faultConn{Conn: conn, network: n, link: link{from, to}}
+		n.mu.Lock()
+		n.conns[fc] = struct{}{}
+		n.mu.Unlock()
+		return fc, nil
+	}
+}
+
+func (n *network) node(addr string) (int, bool) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	node, ok := n.ports[port]
+	return node, ok
+}
+
+func (n *network) isBlocked(l link) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.blocked[l]
+}
+
+// 링크를 양방향으로 끊고 그 링크로 맺은 연결을 닫는다.
+func (n *network) block(a, b int) {
+	n.mu.Lock()
+	n.blocked[link{a, b}] = true
+	n.blocked[link{b, a}] = true
+	var closing []*faultConn
+	for fc := range n.conns {
+		if fc.link == (link{a, b}) || fc.link == (link{b, a}) {
+			closing = append(closing, fc)
+		}
+	}
+	n.mu.Unlock()
+	for _, fc := range closing {
+		_ = fc.Close()
+	}
+}
+
+func (n *network) heal() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blocked = make(map[link]bool)
+}
+
+func (n *network) setLatency(l link, d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if d <= 0 {
+		delete(n.latency, l)
+		return
+	}
+	n.latency[l] = d
+}
+
+func (n *network) setAllLatency(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency = make(map[link]time.Duration)
+	if d <= 0 {
+		return
+	}
+	for _, from := range n.ports {
+		for _, to := range n.ports {
+			if from != to {
+				n.latency[link{from, to}] = d
+			}
+		}
+	}
+}
+
+func (n *network) conditions(l link) (bool, time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.blocked[l], n.latency[l]
+}
+
+type faultConn struct {
+	net.Conn
+	network *network
+	link    link
+	once    sync.Once
+}
+
+func (c *faultConn) Write(b []byte) (int, error) {
+	blocked, latency := c.network.conditions(c.link)
+	if blocked {
+		_ = c.Close()
+		return 0, errPartitioned
+	}
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *faultConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.network.mu.Lock()
+		delete(c.network.conns, c)
+		c.network.mu.Unlock()
+		err = c.Conn.Close()
+	})
+	return err
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"go.uber.org/zap"
+)
+
+// 인가 결정 하나
+type Event struct {
+	Time    time.Time `json:"time"`
+	Subject string    `json:"subject"`
+	Action  string    `json:"action"`
+	Object  string    `json:"object"`
+	Allowed bool      `json:"allowed"`
+	// 요청한 클라이언트 주소
+	Peer string `json:"peer,omitempty"`
+	// gRPC 메서드 이름이나 HTTP 요청의 "메서드 경로"
+	Method string `json:"method,omitempty"`
+	// 거절한 이유
+	Reason string `json:"reason,omitempty"`
+}
+
+// 감사 이벤트를 토픽의 레코드로 쓸 로그
+type Appender interface {
+	Append(*api.Record) (uint64, error)
+}
+
+type Config struct {
+	// nil 이면 zap.L().Named("audit") 를 쓴다.
+	Logger *zap.Logger
+	// CommitLog 와 Topic 이 있으면 이벤트를 JSON 으로 인코딩해 이 토픽의 레코드로도 남긴다.
+	CommitLog Appender
+	Topic     string
+	// 허용한 결정은 AllowedSampling 개 중 하나만 기록한다. 0, 1 이면 모두 기록하고 음수면 기록하지 않는다.
+	// 거절한 결정은 항상 기록한다.
+	AllowedSampling int
+	// 토픽에 쓰기 전에 쌓아두는 이벤트 수, 가득 차면 토픽에는 쓰지 않고 버린다. 기본값 1024
+	BufferSize int
+}
+
+const defaultBufferSize = 1024
+
+/*
+인가 결정을 구조화된 로그로 남긴다.
+토픽에 쓰는 일은 요청을 늦추지 않도록 별도 고루틴에서 한다.
+토픽에 쓰지 못한 이벤트도 로거에는 남는다. (예: 리더가 아닌 노드에서는 DistributedLog 에 Append 할 수 없다.)
+*/
+type Logger struct {
+	Config
+	allowed atomic.Uint64
+	events  chan Event
+	closed  chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func New(config Config) *Logger {
+	if config.Logger == nil {
+		config.Logger = zap.L().Named("audit")
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaultBufferSize
+	}
+	l := &Logger{
+		Config: config,
+		closed: make(chan struct{}),
+	}
+	if config.CommitLog != nil && config.Topic != "" {
+		l.events = make(chan Event, config.BufferSize)
+		l.wg.Add(1)
+		go l.appendEvents()
+	}
+	return l
+}
+
+func (l *Logger) Audit(event Event) {
+	if event.Allowed && !l.sample() {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	fields := []zap.Field{
+		zap.String("subject", event.Subject),
+		zap.String("action", event.Action),
+		zap.String("object", event.Object),
+		zap.Bool("allowed", event.Allowed),
+		zap.String("peer", event.Peer),
+		zap.String("method", event.Method),
+	}
+	if event.Allowed {
+		l.Logger.Info("authorization allowed", fields...)
+	} else {
+		l.Logger.Warn("authorization denied", append(fields, zap.String("reason", event.Reason))...)
+	}
+
+	if l.events == nil {
+		return
+	}
+	select {
+	case <-l.closed:
+	case l.events <- event:
+	default:
+		l.Logger.Debug("audit buffer is full, dropping event from topic", zap.String("topic", l.Topic))
+	}
+}
+
+func (l *Logger) sample() bool {
+	switch n := l.AllowedSampling; {
+	case n < 0:
+		return false
+	case n <= 1:
+		return true
+	default:
+		return (l.allowed.Add(1)-1)%uint64(n) == 0
+	}
+}
+
+func (l *Logger) appendEvents() {
+	defer l.wg.Done()
+	for {
+		select {
+		case <-l.closed:
+			return
+		case event := <-l.events:
+			value, err := json.Marshal(event)
+			if err != nil {
+				l.Logger.Error("failed to encode audit event", zap.Error(err))
+				continue
+			}
+			if _, err := l.CommitLog.Append(&api.Record{Value: value, Topic: l.Topic}); err != nil {
+				l.Logger.Debug("failed to append audit event", zap.String("topic", l.Topic), zap.Error(err))
+			}
+		}
+	}
+}
+
+// 토픽에 쓰는 고루틴을 멈춘다. 아직 쓰지 못한 이벤트는 버린다.
+func (l *Logger) Close() error {
+	l.once.Do(func() {
+		close(l.closed)
+	})
+	l.wg.Wait()
+	return nil
+}
@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type memoryLog struct {
+	mu      sync.Mutex
+	records []*api.Record
+}
+
+func (m *memoryLog) Append(record *api.Record) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, record)
+	return uint64(len(m.records) - 1), nil
+}
+
+func (m *memoryLog) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.records)
+}
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	clog := &memoryLog{}
+	l := New(Config{
+		Logger:          zap.New(core),
+		CommitLog:       clog,
+		Topic:           "__audit",
+		AllowedSampling: 3,
+	})
+	defer l.Close()
+
+	for i := 0; i < 6; i++ {
+		l.Audit(Event{Subject: "root", Action: "produce", Object: "*", Allowed: true, Method: "/log.v1.Log/Produce"})
+	}
+	l.Audit(Event{Subject: "nobody", Action: "consume", Object: "*", Reason: "nobody not permitted to consume to *"})
+
+	// 허용은 3 개 중 하나만, 거절은 항상 남는다.
+	require.Equal(t, 2, logs.FilterMessage("authorization allowed").Len())
+	denied := logs.FilterMessage("authorization denied").All()
+	require.Len(t, denied, 1)
+	require.Equal(t, "nobody", denied[0].ContextMap()["subject"])
+	require.Equal(t, "nobody not permitted to consume to *", denied[0].ContextMap()["reason"])
+
+	require.Eventually(t, func() bool { return clog.len() == 3 }, time.Second, 10*time.Millisecond)
+	clog.mu.Lock()
+	last := clog.records[2]
+	clog.mu.Unlock()
+	require.Equal(t, "__audit", last.Topic)
+	var event Event
+	require.NoError(t, json.Unmarshal(last.Value, &event))
+	require.False(t, event.Allowed)
+	require.Equal(t, "consume", event.Action)
+	require.False(t, event.Time.IsZero())
+}
+
+func TestLoggerWithoutAllowed(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	l := New(Config{Logger: zap.New(core), AllowedSampling: -1})
+	defer l.Close()
+
+	l.Audit(Event{Subject: "root", Action: "admin", Object: "*", Allowed: true})
+	l.Audit(Event{Subject: "nobody", Action: "admin", Object: "*"})
+	require.Equal(t, 1, logs.Len())
+	require.Equal(t, "authorization denied", logs.All()[0].Message)
+}
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"os"
+)
+
+/*
+정적 API 키로 subject 를 찾는다.
+키 파일은 "키,subject" 형식의 csv 로, 찾은 subject 는 인증서의 CN 처럼 ACL 에 쓰인다.
+*/
+type APIKeys struct {
+	subjects map[[sha256.Size]byte]string
+}
+
+func NewAPIKeys(path string) (*APIKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("auth: read api key file: %w", err)
+	}
+	keys := make(map[string]string, len(rows))
+	for _, row := range rows {
+		keys[row[0]] = row[1]
+	}
+	return NewAPIKeysFromMap(keys), nil
+}
+
+// 키 → subject
+func NewAPIKeysFromMap(keys map[string]string) *APIKeys {
+	a := &APIKeys{subjects: make(map[[sha256.Size]byte]string, len(keys))}
+	for key, subject := range keys {
+		a.subjects[sha256.Sum256([]byte(key))] = subject
+	}
+	return a
+}
+
+// 모르는 키는 JWT 일 수 있으므로 거절하지 않고 넘긴다.
+func (a *APIKeys) Authenticate(creds Credentials) (*Identity, error) {
+	if creds.Token == "" {
+		return nil, ErrNoCredentials
+	}
+	/* 모든 키의 해시를 같은 시간에 비교하므로 응답 시간으로 키를 알아낼 수 없다. */
+	sum := sha256.Sum256([]byte(creds.Token))
+	var subject string
+	var found bool
+	for key, s := range a.subjects {
+		if subtle.ConstantTimeCompare(key[:], sum[:]) == 1 {
+			subject, found = s, true
+		}
+	}
+	if !found {
+		return nil, ErrNoCredentials
+	}
+	return &Identity{Subject: subject, Method: "api_key"}, nil
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+/*
+인증: 요청을 보낸 주체(subject)를 확인한다. 확인한 subject 로 Authorizer 가 권한을 확인한다.
+클라이언트 인증서(mTLS), 정적 API 키, JWT 를 Chain 으로 묶어서 함께 쓸 수 있다.
+*/
+type Authenticator interface {
+	// 처리할 인증 정보가 없으면 ErrNoCredentials 를 리턴하고, Chain 은 다음 Authenticator 로 넘어간다.
+	Authenticate(creds Credentials) (*Identity, error)
+}
+
+// 요청에서 꺼낸 인증 정보
+type Credentials struct {
+	// TLS 없이 연결했으면 nil
+	TLS *tls.ConnectionState
+	// "Authorization: Bearer <토큰>" 의 토큰, 없으면 빈 문자열
+	Token string
+}
+
+// 인증한 주체
+type Identity struct {
+	// ACL 의 subject, 인증 정보가 없으면 빈 문자열
+	Subject string
+	// 인증한 방법: "mtls", "api_key", "jwt", 인증 정보가 없으면 "anonymous"
+	Method string
+	// JWT 의 클레임, 다른 방법이면 nil
+	Claims map[string]interface{}
+}
+
+var ErrNoCredentials = errors.New("auth: no credentials")
+
+var anonymous = &Identity{Method: "anonymous"}
+
+type identityContextKey struct{}
+
+func NewContext(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, id)
+}
+
+// 인증하지 않은 요청이면 false
+func FromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityContextKey{}).(*Identity)
+	return id, ok
+}
+
+/*
+순서대로 인증을 시도한다.
+토큰을 보냈는데 아무도 받지 않으면 인증서가 있어도 거절하고, 인증 정보가 아예 없으면 subject 가 빈 익명으로 통과시킨다.
+(익명 요청은 Authorizer 가 거절한다.)
+*/
+func Chain(authenticators ...Authenticator) Authenticator {
+	return chain(authenticators)
+}
+
+type chain []Authenticator
+
+func (c chain) Authenticate(creds Credentials) (*Identity, error) {
+	for _, a := range c {
+		id, err := a.Authenticate(creds)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return id, err
+	}
+	if creds.Token != "" {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return anonymous, nil
+}
+
+// 검증한 클라이언트 인증서의 CN 을 subject 로 쓴다. 토큰이 있으면 토큰으로 인증하도록 넘긴다.
+func MTLS() Authenticator {
+	return mtls{}
+}
+
+type mtls struct{}
+
+func (mtls) Authenticate(creds Credentials) (*Identity, error) {
+	if creds.Token != "" || creds.TLS == nil ||
+		len(creds.TLS.VerifiedChains) == 0 || len(creds.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	return &Identity{
+		Subject: creds.TLS.VerifiedChains[0][0].Subject.CommonName,
+		Method:  "mtls",
+	}, nil
+}
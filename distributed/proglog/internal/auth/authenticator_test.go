@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0600))
+
+	authenticator, err := NewJWT(JWTConfig{JWKSFiles: []string{path}, Issuer: "proglog"})
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{"sub": "root", "iss": "proglog", "exp": time.Now().Add(time.Hour).Unix()}
+	}
+
+	for _, token := range []string{
+		sign(jwt.SigningMethodRS256, "rsa", rsaKey, valid()),
+		sign(jwt.SigningMethodES256, "ec", ecKey, valid()),
+	} {
+		id, err := authenticator.Authenticate(Credentials{Token: token})
+		require.NoError(t, err)
+		require.Equal(t, "root", id.Subject)
+		require.Equal(t, "jwt", id.Method)
+		require.Equal(t, "proglog", id.Claims["iss"])
+	}
+
+	expired := valid()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	otherIssuer := valid()
+	otherIssuer["iss"] = "other"
+	noExpiry := valid()
+	delete(noExpiry, "exp")
+	for name, token := range map[string]string{
+		"expired":      sign(jwt.SigningMethodRS256, "rsa", rsaKey, expired),
+		"other issuer": sign(jwt.SigningMethodRS256, "rsa", rsaKey, otherIssuer),
+		"no expiry":    sign(jwt.SigningMethodRS256, "rsa", rsaKey, noExpiry),
+		"wrong key":    sign(jwt.SigningMethodES256, "rsa", ecKey, valid()),
+		"unknown kid":  sign(jwt.SigningMethodRS256, "other", rsaKey, valid()),
+		"hmac":         sign(jwt.SigningMethodHS256, "rsa", []byte("secret"), valid()),
+	} {
+		_, err := authenticator.Authenticate(Credentials{Token: token})
+		require.Equal(t, codes.Unauthenticated, status.Code(err), name)
+	}
+
+	// JWT 가 아닌 토큰은 다른 Authenticator 에 넘긴다.
+	_, err = authenticator.Authenticate(Credentials{Token: "api-key"})
+	require.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain(NewAPIKeysFromMap(map[string]string{"key": "root"}), MTLS())
+
+	id, err := chain.Authenticate(Credentials{Token: "key"})
+	require.NoError(t, err)
+	require.Equal(t, &Identity{Subject: "root", Method: "api_key"}, id)
+
+	// 아무도 받지 않은 토큰은 거절한다.
+	_, err = chain.Authenticate(Credentials{Token: "wrong"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// 인증 정보가 없으면 익명으로 통과시킨다.
+	id, err = chain.Authenticate(Credentials{})
+	require.NoError(t, err)
+	require.Equal(t, "", id.Subject)
+	require.Equal(t, "anonymous", id.Method)
+}
@@ -3,31 +3,48 @@
 ACL: Access Control List, 접근제어목록. "subject 는 object 에 action 할 권한이 있다"는 식으로 저장된다.
 ACL 은 테이블일 뿐이며, Map 이나 csv, 관계형 데이터베이스 등으로 전환할 수 있다.
 Casbin 이라는 라이브러리를 이용
+
+모델에 g 규칙(역할)과 keyMatch 를 쓰면 "team-a 역할은 team-a/* 토픽에 produce 할 수 있다" 처럼 정할 수 있다.
+정책 파일은 Reload 나 Watch 로 서버를 재시작하지 않고 다시 읽는다.
 */
 package auth
 
 import (
 	"fmt"
+	"os"
+	"sync"
+	"time"
 
 	"github.com/casbin/casbin"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
 type Authorizer struct {
+	model  string
+	policy string
+
+	mu       sync.RWMutex
 	enforcer *casbin.Enforcer
 }
 
 func New(model, policy string) *Authorizer {
 	enforcer := casbin.NewEnforcer(model, policy)
-	return &Authorizer {
+	return &Authorizer{
+		model:    model,
+		policy:   policy,
 		enforcer: enforcer,
 	}
 }
 
 func (a *Authorizer) Authorize(subject, object, action string) error {
+	a.mu.RLock()
+	enforcer := a.enforcer
+	a.mu.RUnlock()
+
 	/* 권한이 없다면 error 리턴한다. */
-	if !a.enforcer.Enforce(subject, object, action) {
+	if !enforcer.Enforce(subject, object, action) {
 		msg := fmt.Sprintf("%s not permitted to %s to %s", subject, action, object)
 		st := status.New(codes.PermissionDenied, msg)
 		return st.Err()
@@ -35,3 +52,78 @@ func (a *Authorizer) Authorize(subject, object, action string) error {
 	return nil
 }
 
+// 모델과 정책 파일을 다시 읽는다. 파일이 잘못됐으면 에러를 리턴하고 이전 정책을 계속 쓴다.
+func (a *Authorizer) Reload() error {
+	enforcer, err := newEnforcer(a.model, a.policy)
+	if err != nil {
+		return fmt.Errorf("auth: reload acl: %w", err)
+	}
+	a.mu.Lock()
+	a.enforcer = enforcer
+	a.mu.Unlock()
+	return nil
+}
+
+/*
+interval 마다 모델과 정책 파일의 수정 시각을 확인하고, 바뀌었으면 다시 읽는다.
+리턴한 함수로 멈춘다.
+*/
+func (a *Authorizer) Watch(interval time.Duration) (stop func()) {
+	logger := zap.L().Named("auth")
+	done := make(chan struct{})
+	last := a.modTime()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			modTime := a.modTime()
+			if modTime.Equal(last) {
+				continue
+			}
+			if err := a.Reload(); err != nil {
+				logger.Error("failed to reload acl", zap.Error(err))
+				continue
+			}
+			last = modTime
+			logger.Info("reloaded acl", zap.String("policy", a.policy))
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// 두 파일 중 나중에 수정한 시각
+func (a *Authorizer) modTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{a.model, a.policy} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// casbin 은 잘못된 파일에 panic 하거나 에러를 무시하므로 정책을 한 번 더 읽어 확인한다.
+func newEnforcer(model, policy string) (enforcer *casbin.Enforcer, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			enforcer, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	if _, err := os.Stat(policy); err != nil {
+		return nil, err
+	}
+	enforcer, err = casbin.NewEnforcerSafe(model, policy)
+	if err != nil {
+		return nil, err
+	}
+	if err := enforcer.LoadPolicy(); err != nil {
+		return nil, err
+	}
+	return enforcer, nil
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testModel = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && r.act == p.act
+`
+
+func TestAuthorizer(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.conf")
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(model, []byte(testModel), 0600))
+	require.NoError(t, os.WriteFile(policy, []byte("p,team-a,team-a/*,produce\ng,alice,team-a\n"), 0600))
+
+	authorizer := New(model, policy)
+
+	// 역할을 통해 토픽 패턴에 맞는 object 에만 권한이 있다.
+	require.NoError(t, authorizer.Authorize("alice", "team-a/orders", "produce"))
+	denied := func(subject, object, action string) {
+		t.Helper()
+		err := authorizer.Authorize(subject, object, action)
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+	denied("alice", "team-b/orders", "produce")
+	denied("alice", "*", "produce")
+	denied("alice", "team-a/orders", "consume")
+	denied("bob", "team-a/orders", "produce")
+
+	// 정책 파일을 바꾸고 다시 읽는다.
+	require.NoError(t, os.WriteFile(policy, []byte("p,team-a,team-a/*,produce\ng,alice,team-a\ng,bob,team-a\n"), 0600))
+	require.NoError(t, authorizer.Reload())
+	require.NoError(t, authorizer.Authorize("bob", "team-a/orders", "produce"))
+
+	// 잘못된 파일이면 이전 정책을 계속 쓴다.
+	require.NoError(t, os.Remove(policy))
+	require.Error(t, authorizer.Reload())
+	require.NoError(t, authorizer.Authorize("bob", "team-a/orders", "produce"))
+}
+
+func TestAuthorizerWatch(t *testing.T) {
+	dir := t.TempDir()
+	model := filepath.Join(dir, "model.conf")
+	policy := filepath.Join(dir, "policy.csv")
+	require.NoError(t, os.WriteFile(model, []byte(testModel), 0600))
+	require.NoError(t, os.WriteFile(policy, []byte("p,root,*,admin\n"), 0600))
+
+	authorizer := New(model, policy)
+	stop := authorizer.Watch(10 * time.Millisecond)
+	defer stop()
+
+	require.Error(t, authorizer.Authorize("alice", "team-a/orders", "consume"))
+	require.NoError(t, os.WriteFile(policy, []byte("p,root,*,admin\np,alice,team-a/*,consume\n"), 0600))
+	// 파일 시스템의 시각 정밀도와 상관없이 바뀐 것을 알 수 있도록 수정 시각을 옮긴다.
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(policy, later, later))
+
+	require.Eventually(t, func() bool {
+		return authorizer.Authorize("alice", "team-a/orders", "consume") == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type JWTConfig struct {
+	// 서명을 확인할 공개키가 담긴 JWKS 파일, 여러 파일의 키를 모두 쓴다.
+	JWKSFiles []string
+	// 비어 있지 않으면 iss 클레임이 같아야 한다.
+	Issuer string
+	// 비어 있지 않으면 aud 클레임에 있어야 한다.
+	Audience string
+	// subject 로 쓸 클레임, 기본값 "sub"
+	SubjectClaim string
+}
+
+/*
+로컬 JWKS 파일의 공개키로 서명을 확인한 JWT 의 클레임으로 인증한다.
+비대칭 서명(RS*, PS*, ES*, EdDSA)만 받는다. 토큰 헤더의 kid 로 키를 고르고, 키가 하나뿐이면 kid 가 없어도 된다.
+*/
+type JWT struct {
+	config JWTConfig
+	keys   map[string]interface{} // kid → 공개키
+	parser *jwt.Parser
+}
+
+func NewJWT(config JWTConfig) (*JWT, error) {
+	if config.SubjectClaim == "" {
+		config.SubjectClaim = "sub"
+	}
+	keys := make(map[string]interface{})
+	for _, path := range config.JWKSFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := parseJWKS(data, keys); err != nil {
+			return nil, fmt.Errorf("auth: %s: %w", path, err)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("auth: no signing keys in %v", config.JWKSFiles)
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{
+			"RS256", "RS384", "RS512", "PS256", "PS384", "PS512",
+			"ES256", "ES384", "ES512", "EdDSA",
+		}),
+		jwt.WithExpirationRequired(),
+	}
+	if config.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(config.Audience))
+	}
+	return &JWT{
+		config: config,
+		keys:   keys,
+		parser: jwt.NewParser(opts...),
+	}, nil
+}
+
+// JWT 형식이 아닌 토큰은 API 키일 수 있으므로 넘긴다.
+func (j *JWT) Authenticate(creds Credentials) (*Identity, error) {
+	if creds.Token == "" || strings.Count(creds.Token, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+	claims := jwt.MapClaims{}
+	if _, err := j.parser.ParseWithClaims(creds.Token, claims, j.key); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+	subject, _ := claims[j.config.SubjectClaim].(string)
+	if subject == "" {
+		return nil, status.Errorf(codes.Unauthenticated, "token has no %s claim", j.config.SubjectClaim)
+	}
+	return &Identity{Subject: subject, Method: "jwt", Claims: claims}, nil
+}
+
+func (j *JWT) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := j.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(j.keys) == 1 {
+		for _, key := range j.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// 서명용 공개키를 keys 에 추가한다. 암호화용 키는 건너뛴다.
+func parseJWKS(data []byte, keys map[string]interface{}) error {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if _, ok := keys[k.Kid]; ok {
+			return fmt.Errorf("duplicate key id %q", k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	return nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		var ecdhCurve ecdh.Curve
+		switch k.Crv {
+		case "P-256":
+			curve, ecdhCurve = elliptic.P256(), ecdh.P256()
+		case "P-384":
+			curve, ecdhCurve = elliptic.P384(), ecdh.P384()
+		case "P-521":
+			curve, ecdhCurve = elliptic.P521(), ecdh.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		/* 곡선 위의 점인지 확인한다. */
+		size := (curve.Params().BitSize + 7) / 8
+		if x.BitLen() > size*8 || y.BitLen() > size*8 {
+			return nil, fmt.Errorf("invalid ec point")
+		}
+		point := make([]byte, 1+2*size)
+		point[0] = 4
+		x.FillBytes(point[1 : 1+size])
+		y.FillBytes(point[1+size:])
+		if _, err := ecdhCurve.NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key size")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
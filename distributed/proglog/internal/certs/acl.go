@@ -0,0 +1,66 @@
+package certs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// test/model.conf 와 같다. subject 는 역할(g)을 가질 수 있고 object 는 keyMatch 패턴이다.
+const DefaultModel = `[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && r.act == p.act
+`
+
+// test/policy.csv 와 같다. 테스트가 쓰는 root, alice(team-a) 권한이다.
+const DefaultPolicy = `p,root,*,produce
+p,root,*,consume
+p,root,*,admin
+p,team-a,team-a/*,produce
+p,team-a,team-a/*,consume
+g,alice,team-a`
+
+// WriteACL 이 만드는 파일
+var ACLFiles = []string{"model.conf", "policy.csv"}
+
+// Makefile 의 init 과 같이 model.conf 와 policy.csv 를 만든다.
+func WriteACL(dir string, force bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files := []struct {
+		name string
+		data string
+	}{
+		{"model.conf", DefaultModel},
+		{"policy.csv", DefaultPolicy},
+	}
+	if !force {
+		for _, file := range files {
+			path := filepath.Join(dir, file.name)
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("certs: %s already exists, use force to overwrite", path)
+			}
+		}
+	}
+	var written []string
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		if err := os.WriteFile(path, []byte(file.data), 0644); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,274 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	keyBits = 2048
+	// test/ca-config.json 의 expiry 와 같다.
+	DefaultValidity = 8760 * time.Hour
+)
+
+// test/*-csr.json 의 names 와 같다.
+var subjectNames = pkix.Name{
+	Country:            []string{"Country"},
+	Locality:           []string{"Locality"},
+	Province:           []string{"State"},
+	Organization:       []string{"Organization"},
+	OrganizationalUnit: []string{"Organizational Unit"},
+}
+
+// 인증서를 서명하는 CA
+type Authority struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+}
+
+// 자체 서명한 CA 를 만든다.
+func NewAuthority(commonName string, validity time.Duration) (*Authority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(commonName, validity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+// Issue 할 인증서, test/ca-config.json 의 server, client 프로필에 맞춰 만든다.
+type Request struct {
+	CommonName string
+	// 서버 인증서의 SAN, IP 주소는 IP SAN 으로 넣는다.
+	Hosts    []string
+	Server   bool
+	Validity time.Duration
+}
+
+// CA 로 서명한 인증서와 키를 PEM 으로 리턴한다.
+func (a *Authority) Issue(req Request) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	template, err := newTemplate(req.CommonName, req.Validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	if req.Server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	for _, host := range req.Hosts {
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.Cert, &key.PublicKey, a.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encodeCert(der), encodeKey(key), nil
+}
+
+func (a *Authority) CertPEM() []byte {
+	return encodeCert(a.Cert.Raw)
+}
+
+func (a *Authority) KeyPEM() []byte {
+	return encodeKey(a.Key)
+}
+
+// PEM 파일로 저장한 CA 를 읽는다.
+func LoadAuthority(certFile, keyFile string) (*Authority, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("certs: no certificate in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, fmt.Errorf("certs: %s is not a CA certificate", certFile)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("certs: no private key in %s", keyFile)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &Authority{Cert: cert, Key: key}, nil
+}
+
+func newTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	subject := subjectNames
+	subject.CommonName = commonName
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		// 시계가 조금 어긋난 노드에서도 바로 쓸 수 있도록 조금 앞당긴다.
+		NotBefore: now.Add(-5 * time.Minute),
+		NotAfter:  now.Add(validity),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// cfssl 과 같은 PKCS #1 형식
+func encodeKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+type Options struct {
+	// 파일을 쓸 디렉터리, 테스트가 읽는 곳은 config.Dir()
+	Dir string
+	// 서버 인증서의 SAN, 비어 있으면 test/server-csr.json 과 같이 localhost, 127.0.0.1, :: 를 넣는다.
+	Hosts    []string
+	Validity time.Duration
+	// 이미 있는 파일을 덮어쓴다.
+	Force bool
+}
+
+var defaultHosts = []string{"localhost", "127.0.0.1", "::"}
+
+// Generate 가 만드는 파일
+var Files = []string{
+	"ca.pem", "ca-key.pem",
+	"server.pem", "server-key.pem",
+	"client.pem", "client-key.pem",
+	"root-client.pem", "root-client-key.pem",
+	"nobody-client.pem", "nobody-client-key.pem",
+}
+
+/*
+Makefile 의 gencert 와 같은 파일을 만든다.
+
+	ca.pem, ca-key.pem
+	server.pem, server-key.pem            (CN 127.0.0.1, server 프로필)
+	client.pem, client-key.pem            (CN client, client 프로필)
+	root-client.pem, root-client-key.pem  (CN root)
+	nobody-client.pem, nobody-client-key.pem (CN nobody)
+
+Force 가 아니면 이미 있는 CA 로 나머지 인증서를 다시 서명하고, CA 가 아닌 파일이 이미 있으면 에러를 리턴한다.
+*/
+func Generate(opts Options) ([]string, error) {
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	path := func(name string) string { return filepath.Join(opts.Dir, name) }
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = defaultHosts
+	}
+
+	leaves := []struct {
+		name string
+		req  Request
+	}{
+		{"server", Request{CommonName: "127.0.0.1", Hosts: hosts, Server: true}},
+		{"client", Request{CommonName: "client"}},
+		{"root-client", Request{CommonName: "root"}},
+		{"nobody-client", Request{CommonName: "nobody"}},
+	}
+	if !opts.Force {
+		for _, leaf := range leaves {
+			for _, file := range []string{path(leaf.name + ".pem"), path(leaf.name + "-key.pem")} {
+				if _, err := os.Stat(file); err == nil {
+					return nil, fmt.Errorf("certs: %s already exists, use force to overwrite", file)
+				}
+			}
+		}
+	}
+
+	var written []string
+	write := func(name string, data []byte, perm os.FileMode) error {
+		if err := os.WriteFile(path(name), data, perm); err != nil {
+			return err
+		}
+		written = append(written, path(name))
+		return nil
+	}
+
+	ca, err := LoadAuthority(path("ca.pem"), path("ca-key.pem"))
+	if opts.Force || errors.Is(err, os.ErrNotExist) {
+		ca, err = NewAuthority("My Awesome CA", opts.Validity)
+		if err != nil {
+			return nil, err
+		}
+		if err := write("ca.pem", ca.CertPEM(), 0644); err != nil {
+			return written, err
+		}
+		if err := write("ca-key.pem", ca.KeyPEM(), 0600); err != nil {
+			return written, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, leaf := range leaves {
+		leaf.req.Validity = opts.Validity
+		certPEM, keyPEM, err := ca.Issue(leaf.req)
+		if err != nil {
+			return written, err
+		}
+		if err := write(leaf.name+".pem", certPEM, 0644); err != nil {
+			return written, err
+		}
+		if err := write(leaf.name+"-key.pem", keyPEM, 0600); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
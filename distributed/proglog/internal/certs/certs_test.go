@@ -0,0 +1,106 @@
+package certs
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	written, err := Generate(Options{Dir: dir})
+	require.NoError(t, err)
+	require.Len(t, written, 10)
+
+	// 만든 인증서로 config.SetupTLSConfig 가 쓰는 상호 TLS 연결을 할 수 있다.
+	serverConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile: path("server.pem"),
+		KeyFile:  path("server-key.pem"),
+		CAFile:   path("ca.pem"),
+		Server:   true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "root", peerCommonName(t, serverConfig, path("root-client.pem"), path("root-client-key.pem"), path("ca.pem")))
+	require.Equal(t, "nobody", peerCommonName(t, serverConfig, path("nobody-client.pem"), path("nobody-client-key.pem"), path("ca.pem")))
+
+	// 이미 있는 파일은 덮어쓰지 않는다.
+	_, err = Generate(Options{Dir: dir})
+	require.Error(t, err)
+
+	// CA 만 남아 있으면 그 CA 로 다시 서명한다.
+	ca, err := os.ReadFile(path("ca.pem"))
+	require.NoError(t, err)
+	for _, name := range written[2:] {
+		require.NoError(t, os.Remove(name))
+	}
+	_, err = Generate(Options{Dir: dir})
+	require.NoError(t, err)
+	reissued, err := os.ReadFile(path("ca.pem"))
+	require.NoError(t, err)
+	require.Equal(t, ca, reissued)
+
+	_, err = Generate(Options{Dir: dir, Force: true})
+	require.NoError(t, err)
+	reissued, err = os.ReadFile(path("ca.pem"))
+	require.NoError(t, err)
+	require.NotEqual(t, ca, reissued)
+}
+
+// 클라이언트 인증서로 접속했을 때 서버가 확인한 CN
+func peerCommonName(t *testing.T, serverConfig *tls.Config, certFile, keyFile, caFile string) string {
+	t.Helper()
+	clientConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      certFile,
+		KeyFile:       keyFile,
+		CAFile:        caFile,
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer ln.Close()
+	cn := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			cn <- ""
+			return
+		}
+		defer conn.Close()
+		tlsConn := conn.(*tls.Conn)
+		if err := tlsConn.Handshake(); err != nil {
+			cn <- ""
+			return
+		}
+		cn <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	require.NoError(t, err)
+	defer conn.Close()
+	return <-cn
+}
+
+func TestWriteACL(t *testing.T) {
+	dir := t.TempDir()
+	_, err := WriteACL(dir, false)
+	require.NoError(t, err)
+	_, err = WriteACL(dir, false)
+	require.Error(t, err)
+
+	// test/ 의 파일과 같아야 테스트가 같은 권한으로 돈다.
+	for _, name := range []string{"model.conf", "policy.csv"} {
+		want, err := os.ReadFile(filepath.Join("..", "..", "test", name))
+		require.NoError(t, err)
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, string(want), string(got))
+	}
+}
@@ -20,12 +20,17 @@ var (
 )
 
 func configFile(filename string) string {
+	return filepath.Join(Dir(), filename)
+}
+
+// 인증서와 ACL 파일이 있는 디렉터리, CONFIG_DIR 이 없으면 ~/.proglog
+func Dir() string {
 	if dir := os.Getenv("CONFIG_DIR"); dir != "" {
-		return filepath.Join(dir, filename)
+		return dir
 	}
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
-	return filepath.Join(homeDir, ".proglog", filename)
+	return filepath.Join(homeDir, ".proglog")
 }
\ No newline at end of file
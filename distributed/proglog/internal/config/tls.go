@@ -1,51 +1,289 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+	"go.uber.org/zap"
 )
 
 type TLSConfig struct {
 	CertFile string
-	KeyFile string
-	CAFile string
+	KeyFile  string
+	CAFile   string
+	// CA 를 바꾸는 동안 함께 신뢰할 CA 번들, CAFile 과 함께 읽는다.
+	CAFiles       []string
 	ServerAddress string
-	Server bool
+	Server        bool
+	// 핸드셰이크할 때 이 간격보다 오래됐으면 파일이 바뀌었는지 확인한다. 0 이면 기본값, 음수면 다시 읽지 않는다.
+	ReloadInterval time.Duration
 }
 
+const defaultTLSReloadInterval = 10 * time.Second
+
+/*
+인증서와 키는 GetCertificate/GetClientCertificate 로, 서버가 신뢰하는 CA 는 GetConfigForClient 로 넘기므로
+파일을 바꾸면 재시작하지 않아도 다음 핸드셰이크부터 새 인증서를 쓴다. (gRPC, HTTP, raft StreamLayer 모두)
+클라이언트는 핸드셰이크마다 RootCAs 를 바꿀 방법이 없으므로, 기본 검증을 끄고 VerifyConnection 에서 다시 읽은 CA 로 서버 인증서를 검증한다.
+다만 ServerAddress 가 없으면 IP 로 연결할 때 검증할 이름을 알 수 없으므로, RootCAs 로 기본 검증을 하고 CA 는 다시 읽지 않는다.
+CA 를 바꿀 때는 새 CA 를 CAFiles 에 추가해서 모든 노드에 배포한 뒤 인증서를 바꾸고, 마지막에 이전 CA 를 뺀다.
+*/
 func SetupTLSConfig(cfg TLSConfig) (*tls.Config, error) {
-	var err error
+	if err := view.Register(CertificateExpiryView); err != nil {
+		return nil, err
+	}
+	if cfg.ReloadInterval == 0 {
+		cfg.ReloadInterval = defaultTLSReloadInterval
+	}
+	r := &certReloader{cfg: cfg, logger: zap.L().Named("tls")}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
 	tlsConfig := &tls.Config{}
-	
-	if cfg.CertFile != "" && cfg.KeyFile != "" {
-		tlsConfig.Certificates = make([]tls.Certificate, 1)
-		tlsConfig.Certificates[0], err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
-		if err != nil {
-			return nil, err
+	if r.cert != nil {
+		if cfg.Server {
+			tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				r.maybeReload()
+				return r.certificate(), nil
+			}
+		} else {
+			tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				r.maybeReload()
+				return r.certificate(), nil
+			}
 		}
 	}
-	
-	if cfg.CAFile != "" {
-		b, err := ioutil.ReadFile(cfg.CAFile)
-		if err != nil {
-			return nil, err
-		}
-		ca := x509.NewCertPool()
-		ok := ca.AppendCertsFromPEM([]byte(b))
-		if !ok {
-			return nil, fmt.Errorf("filed to parse root certificate: %q", cfg.CAFile,)
-		}
 
+	if r.pool != nil {
 		if cfg.Server {
-			tlsConfig.ClientCAs = ca
+			tlsConfig.ClientCAs = r.pool
 			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			base := tlsConfig.Clone()
+			tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				r.maybeReload()
+				c := base.Clone()
+				c.ClientCAs = r.certPool()
+				return c, nil
+			}
+		} else if cfg.ServerAddress == "" {
+			tlsConfig.RootCAs = r.pool
 		} else {
-			tlsConfig.RootCAs = ca
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+				r.maybeReload()
+				return verifyServer(cs, r.certPool(), cfg.ServerAddress)
+			}
 		}
 		tlsConfig.ServerName = cfg.ServerAddress
 	}
 
 	return tlsConfig, nil
-}
\ No newline at end of file
+}
+
+/*
+InsecureSkipVerify 가 끈 기본 검증과 같이, 서버가 보낸 인증서 체인과 이름을 검증한다.
+IP 주소는 SNI 로 보내지 않아서 cs.ServerName 이 비어 있으므로 ServerAddress 로 검증한다.
+*/
+func verifyServer(cs tls.ConnectionState, roots *x509.CertPool, serverAddress string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("tls: server did not send a certificate")
+	}
+	name := cs.ServerName
+	if name == "" {
+		name = serverAddress
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// 인증서, 키, CA 파일을 읽어두고, 파일의 수정 시각이 바뀌면 다시 읽는다.
+type certReloader struct {
+	cfg     TLSConfig
+	logger  *zap.Logger
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+func (r *certReloader) files() []string {
+	var files []string
+	if r.cfg.CertFile != "" && r.cfg.KeyFile != "" {
+		files = append(files, r.cfg.CertFile, r.cfg.KeyFile)
+	}
+	if r.cfg.CAFile != "" {
+		files = append(files, r.cfg.CAFile)
+	}
+	return append(files, r.cfg.CAFiles...)
+}
+
+// 파일 중 나중에 수정한 시각
+func (r *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *certReloader) load() error {
+	modTime := r.latestModTime()
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" && r.cfg.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		if c.Leaf == nil {
+			if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+				return err
+			}
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	caFiles := r.cfg.CAFiles
+	if r.cfg.CAFile != "" {
+		caFiles = append([]string{r.cfg.CAFile}, caFiles...)
+	}
+	for _, caFile := range caFiles {
+		b, err := os.ReadFile(caFile)
+		if err != nil {
+			return err
+		}
+		if pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if ok := pool.AppendCertsFromPEM(b); !ok {
+			return fmt.Errorf("filed to parse root certificate: %q", caFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool, r.modTime = cert, pool, modTime
+	r.mu.Unlock()
+	if cert != nil {
+		recordExpiry(r.cfg.CertFile, cert.Leaf.NotAfter)
+	}
+	for _, caFile := range caFiles {
+		if notAfter, err := earliestExpiry(caFile); err == nil {
+			recordExpiry(caFile, notAfter)
+		}
+	}
+	return nil
+}
+
+// ReloadInterval 이 지났고 파일이 바뀌었으면 다시 읽는다. 읽지 못하면 이전 인증서를 계속 쓴다.
+func (r *certReloader) maybeReload() {
+	if r.cfg.ReloadInterval < 0 {
+		return
+	}
+	r.mu.Lock()
+	if time.Since(r.checked) < r.cfg.ReloadInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.checked = time.Now()
+	last := r.modTime
+	r.mu.Unlock()
+
+	if r.latestModTime().Equal(last) {
+		return
+	}
+	if err := r.load(); err != nil {
+		r.logger.Error("failed to reload certificates", zap.String("cert", r.cfg.CertFile), zap.Error(err))
+		return
+	}
+	r.logger.Info("reloaded certificates", zap.String("cert", r.cfg.CertFile))
+}
+
+func (r *certReloader) certificate() *tls.Certificate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cert
+}
+
+func (r *certReloader) certPool() *x509.CertPool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pool
+}
+
+// 번들에서 가장 먼저 만료하는 인증서의 만료 시각
+func earliestExpiry(file string) (time.Time, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var earliest time.Time
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if earliest.IsZero() || cert.NotAfter.Before(earliest) {
+			earliest = cert.NotAfter
+		}
+	}
+	if earliest.IsZero() {
+		return earliest, errors.New("no certificate found")
+	}
+	return earliest, nil
+}
+
+var (
+	certificateExpiry = stats.Int64(
+		"proglog/tls/certificate_expiry",
+		"Unix time at which the certificate expires",
+		stats.UnitSeconds,
+	)
+	// 인증서와 CA 파일 별 만료 시각, 다시 읽을 때마다 갱신한다.
+	CertificateExpiryView = &view.View{
+		Name:        "proglog/tls/certificate_expiry_timestamp_seconds",
+		Measure:     certificateExpiry,
+		Description: "Unix time at which the loaded certificate or CA bundle expires",
+		TagKeys:     []tag.Key{certificateFileKey},
+		Aggregation: view.LastValue(),
+	}
+	certificateFileKey = tag.MustNewKey("file")
+)
+
+func recordExpiry(file string, notAfter time.Time) {
+	_ = stats.RecordWithTags(
+		context.Background(),
+		[]tag.Mutator{tag.Upsert(certificateFileKey, file)},
+		certificateExpiry.M(notAfter.Unix()),
+	)
+}
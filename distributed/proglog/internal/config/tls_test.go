@@ -0,0 +1,240 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/stats/view"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// CA 로 서명한 인증서와 키를 파일로 쓴다.
+func (ca *testCA) issue(t *testing.T, certFile, keyFile, cn string, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+// 핸드셰이크하고 서버가 보낸 인증서의 CN 을 리턴한다.
+func handshake(t *testing.T, serverConfig, clientConfig *tls.Config) (string, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		errc <- tls.Server(conn, serverConfig).Handshake()
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := tls.Client(conn, clientConfig)
+	if err := client.Handshake(); err != nil {
+		return "", err
+	}
+	if err := <-errc; err != nil {
+		return "", err
+	}
+	return client.ConnectionState().PeerCertificates[0].Subject.CommonName, nil
+}
+
+func TestSetupTLSConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	oldCA, newCA := newTestCA(t, "old-ca"), newTestCA(t, "new-ca")
+	require.NoError(t, os.WriteFile(file("ca.pem"), oldCA.pem, 0600))
+	require.NoError(t, os.WriteFile(file("new-ca.pem"), newCA.pem, 0600))
+	expiry := time.Now().Add(12 * time.Hour).Truncate(time.Second)
+	oldCA.issue(t, file("server.pem"), file("server-key.pem"), "server-1", expiry)
+	oldCA.issue(t, file("client.pem"), file("client-key.pem"), "client", time.Now().Add(time.Hour))
+
+	serverConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:       file("server.pem"),
+		KeyFile:        file("server-key.pem"),
+		CAFile:         file("ca.pem"),
+		CAFiles:        []string{file("new-ca.pem")},
+		Server:         true,
+		ReloadInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	clientConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:       file("client.pem"),
+		KeyFile:        file("client-key.pem"),
+		CAFile:         file("ca.pem"),
+		CAFiles:        []string{file("new-ca.pem")},
+		ServerAddress:  "127.0.0.1",
+		ReloadInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	cn, err := handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, "server-1", cn)
+
+	// 만료 시각을 메트릭으로 남긴다.
+	rows, err := view.RetrieveData(CertificateExpiryView.Name)
+	require.NoError(t, err)
+	var found bool
+	for _, row := range rows {
+		if row.Tags[0].Value == file("server.pem") {
+			found = true
+			require.Equal(t, float64(expiry.Unix()), row.Data.(*view.LastValueData).Value)
+		}
+	}
+	require.True(t, found)
+
+	// 새 CA 로 서명한 인증서로 바꾸면 재시작하지 않아도 다음 핸드셰이크부터 쓴다.
+	newCA.issue(t, file("server.pem"), file("server-key.pem"), "server-2", time.Now().Add(time.Hour))
+	newCA.issue(t, file("client.pem"), file("client-key.pem"), "client", time.Now().Add(time.Hour))
+	later := time.Now().Add(time.Second)
+	for _, name := range []string{"server.pem", "server-key.pem", "client.pem", "client-key.pem"} {
+		require.NoError(t, os.Chtimes(file(name), later, later))
+	}
+	time.Sleep(5 * time.Millisecond)
+	cn, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, "server-2", cn)
+
+	// 읽을 수 없는 파일로 바뀌면 이전 인증서를 계속 쓴다.
+	require.NoError(t, os.WriteFile(file("server.pem"), []byte("broken"), 0600))
+	later = later.Add(time.Second)
+	require.NoError(t, os.Chtimes(file("server.pem"), later, later))
+	time.Sleep(5 * time.Millisecond)
+	cn, err = handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, "server-2", cn)
+}
+
+func TestSetupTLSConfigUntrustedCA(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	ca, other := newTestCA(t, "ca"), newTestCA(t, "other-ca")
+	require.NoError(t, os.WriteFile(file("ca.pem"), ca.pem, 0600))
+	ca.issue(t, file("server.pem"), file("server-key.pem"), "server", time.Now().Add(time.Hour))
+	other.issue(t, file("client.pem"), file("client-key.pem"), "client", time.Now().Add(time.Hour))
+
+	serverConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: file("server.pem"),
+		KeyFile:  file("server-key.pem"),
+		CAFile:   file("ca.pem"),
+		Server:   true,
+	})
+	require.NoError(t, err)
+	clientConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:      file("client.pem"),
+		KeyFile:       file("client-key.pem"),
+		CAFile:        file("ca.pem"),
+		ServerAddress: "127.0.0.1",
+	})
+	require.NoError(t, err)
+
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+}
+
+func TestSetupTLSConfigReloadRootCAs(t *testing.T) {
+	dir := t.TempDir()
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	oldCA, newCA := newTestCA(t, "old-ca"), newTestCA(t, "new-ca")
+	require.NoError(t, os.WriteFile(file("server-ca.pem"), append(oldCA.pem, newCA.pem...), 0600))
+	require.NoError(t, os.WriteFile(file("client-ca.pem"), oldCA.pem, 0600))
+	newCA.issue(t, file("server.pem"), file("server-key.pem"), "server", time.Now().Add(time.Hour))
+	oldCA.issue(t, file("client.pem"), file("client-key.pem"), "client", time.Now().Add(time.Hour))
+
+	serverConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile: file("server.pem"),
+		KeyFile:  file("server-key.pem"),
+		CAFile:   file("server-ca.pem"),
+		Server:   true,
+	})
+	require.NoError(t, err)
+	clientConfig, err := SetupTLSConfig(TLSConfig{
+		CertFile:       file("client.pem"),
+		KeyFile:        file("client-key.pem"),
+		CAFile:         file("client-ca.pem"),
+		ServerAddress:  "127.0.0.1",
+		ReloadInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// 클라이언트가 아직 새 CA 를 모르면 새 CA 로 서명한 서버 인증서를 거절한다.
+	_, err = handshake(t, serverConfig, clientConfig)
+	require.Error(t, err)
+
+	// CA 파일을 바꾸면 재시작하지 않아도 다음 연결부터 새 CA 로 검증한다.
+	require.NoError(t, os.WriteFile(file("client-ca.pem"), newCA.pem, 0600))
+	later := time.Now().Add(time.Second)
+	require.NoError(t, os.Chtimes(file("client-ca.pem"), later, later))
+	time.Sleep(5 * time.Millisecond)
+	cn, err := handshake(t, serverConfig, clientConfig)
+	require.NoError(t, err)
+	require.Equal(t, "server", cn)
+
+	// 기본 검증처럼 서버 이름도 확인한다.
+	otherName := clientConfig.Clone()
+	otherName.ServerName = "other.example"
+	_, err = handshake(t, serverConfig, otherName)
+	require.Error(t, err)
+}
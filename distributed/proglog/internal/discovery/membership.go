@@ -5,6 +5,7 @@ import (
 
 	"github.com/hashicorp/raft"
 	"github.com/hashicorp/serf/serf"
+	"github.com/jhkim988/proglog/internal/logging"
 	"go.uber.org/zap"
 )
 
@@ -53,6 +54,9 @@ func (m *Membership) setupSerf() error {
 	config.MemberlistConfig.BindAddr = addr.IP.String()
 	config.MemberlistConfig.BindPort = addr.Port
 	config.EventCh = m.events
+	// serf 와 memberlist 의 로그도 zap 으로 남긴다.
+	config.Logger = logging.StdLogger(m.logger.Named("serf"))
+	config.MemberlistConfig.Logger = logging.StdLogger(m.logger.Named("memberlist"))
 	config.NodeName = m.Config.NodeName
 	config.Tags = m.Tags // 클러스터에 노드를 어떻게 다룰지 알려주고, 서로 RPC 를 요청할 수 있다. 클러스터 합의 시에 투표자 여부또한 공유
 
@@ -119,10 +123,20 @@ func (m *Membership) Members() []serf.Member {
 	return m.serf.Members()
 }
 
+// 로컬 멤버가 클러스터를 떠나지 않았고 Serf 가 동작 중이면 true
+func (m *Membership) Alive() bool {
+	return m.serf.State() == serf.SerfAlive && m.serf.LocalMember().Status == serf.StatusAlive
+}
+
 func (m *Membership) Leave() error {
 	return m.serf.Leave()
 }
 
+// Serf 를 멈추고 포트를 닫는다. Leave 없이 부르면 다른 멤버는 이 멤버를 장애로 본다.
+func (m *Membership) Shutdown() error {
+	return m.serf.Shutdown()
+}
+
 func (m *Membership) logError(err error, msg string, member serf.Member) {
 	log := m.logger.Error
 	if err == raft.ErrNotLeader {
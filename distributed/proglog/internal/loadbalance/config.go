@@ -0,0 +1,107 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 요청을 어느 서버로 보낼지 정한다.
+type Route string
+
+const (
+	RouteLeader   Route = "leader"   // 리더로만 보낸다.
+	RouteFollower Route = "follower" // 팔로워로 보내고, 팔로워가 없으면 리더로 보낸다.
+	RouteAny      Route = "any"      // 처리 중인 요청이 가장 적은 서버로 보낸다.
+	RouteNearest  Route = "nearest"  // 응답 시간이 가장 짧은 서버로 보낸다.
+)
+
+func (r Route) valid() bool {
+	switch r {
+	case RouteLeader, RouteFollower, RouteAny, RouteNearest:
+		return true
+	}
+	return false
+}
+
+/*
+서비스 설정(JSON)의 loadBalancingConfig 로 받는 밸런서 설정
+
+	{"loadBalancingConfig":[{"proglog":{
+		"routes":{"/log.v1.Log/Consume":"nearest","/log.v1.Admin/":"leader"},
+		"default":"any"
+	}}]}
+
+routes 의 키는 전체 메서드 이름이거나, "/" 로 끝나는 서비스 이름이다.
+routes 에 없는 메서드는 기본 라우팅 테이블(defaultRoutes)을 따르고, 거기에도 없으면 default 를 따른다.
+*/
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Routes  map[string]Route `json:"routes,omitempty"`
+	Default Route            `json:"default,omitempty"`
+}
+
+// 쓰기는 리더로, 읽기는 팔로워로 보낸다.
+var defaultRoutes = map[string]Route{
+	api.Log_Produce_FullMethodName:                RouteLeader,
+	api.Log_ProduceStream_FullMethodName:          RouteLeader,
+	api.Log_Consume_FullMethodName:                RouteFollower,
+	api.Log_ConsumeStream_FullMethodName:          RouteFollower,
+	api.Log_BeginTxn_FullMethodName:               RouteLeader,
+	api.Log_AppendTxn_FullMethodName:              RouteLeader,
+	api.Log_CommitTxn_FullMethodName:              RouteLeader,
+	api.Log_AbortTxn_FullMethodName:               RouteLeader,
+	"/" + api.Admin_ServiceDesc.ServiceName + "/": RouteLeader,
+}
+
+// 스트리밍 RPC 는 스트림이 끝날 때 Done 이 호출되므로 응답 시간 계산에서 뺀다.
+var streamingMethods = func() map[string]bool {
+	methods := make(map[string]bool)
+	for _, sd := range []grpc.ServiceDesc{api.Log_ServiceDesc, api.Admin_ServiceDesc} {
+		for _, s := range sd.Streams {
+			methods["/"+sd.ServiceName+"/"+s.StreamName] = true
+		}
+	}
+	return methods
+}()
+
+func parseConfig(js json.RawMessage) (*Config, error) {
+	config := &Config{}
+	if len(js) > 0 {
+		if err := json.Unmarshal(js, config); err != nil {
+			return nil, fmt.Errorf("invalid %s balancer config: %w", Name, err)
+		}
+	}
+	if config.Default == "" {
+		config.Default = RouteAny
+	}
+	if !config.Default.valid() {
+		return nil, fmt.Errorf("invalid default route %q", config.Default)
+	}
+	for method, route := range config.Routes {
+		if !route.valid() {
+			return nil, fmt.Errorf("invalid route %q for %s", route, method)
+		}
+	}
+	return config, nil
+}
+
+// 메서드 이름으로 라우팅 방식을 찾는다. 설정의 routes, 기본 라우팅 테이블, default 순서로 찾는다.
+func (c *Config) route(method string) Route {
+	for _, routes := range []map[string]Route{c.Routes, defaultRoutes} {
+		if route, ok := routes[method]; ok {
+			return route
+		}
+		if i := strings.LastIndex(method, "/"); i > 0 {
+			if route, ok := routes[method[:i+1]]; ok {
+				return route
+			}
+		}
+	}
+	return c.Default
+}
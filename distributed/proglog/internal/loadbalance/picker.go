@@ -1,29 +1,81 @@
 package loadbalance
 
 import (
-	"strings"
+	"encoding/json"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	api "github.com/jhkim988/proglog/api/v1"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
 )
 
 var _ base.PickerBuilder = (*Picker)(nil)
 
+// 응답 시간 지수이동평균에서 새 값의 비중
+const latencyWeight = 0.3
+
 type Picker struct {
 	mu        sync.RWMutex
+	cc        balancer.ClientConn // 서버 목록을 다시 받도록 리졸버에 알릴 때 사용한다.
+	config    *Config
 	leader    balancer.SubConn
 	followers []balancer.SubConn
+	stats     map[balancer.SubConn]*subConnStats
 	current   uint64
 }
 
+// 서버마다 처리 중인 요청 수와 응답 시간을 기록한다.
+type subConnStats struct {
+	outstanding int64
+	latency     int64 // 응답 시간 지수이동평균 (ns), 아직 응답을 받지 못했으면 0
+}
+
+func (s *subConnStats) observe(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&s.latency)
+		next := int64(d)
+		if old != 0 {
+			next = int64(latencyWeight*float64(d) + (1-latencyWeight)*float64(old))
+		}
+		if atomic.CompareAndSwapInt64(&s.latency, old, next) {
+			return
+		}
+	}
+}
+
+// cc 가 nil 이 아니면, 고른 서버가 리더가 아니거나 쓸 수 없다고 응답할 때 cc.ResolveNow 를 호출한다.
+// config 가 nil 이면 기본 라우팅 테이블을 쓴다.
+func NewPicker(cc balancer.ClientConn, config *Config) *Picker {
+	return &Picker{cc: cc, config: config}
+}
+
+// 서비스 설정으로 받은 라우팅 테이블을 적용한다.
+func (p *Picker) setConfig(config *Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config = config
+}
+
 func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	var followers []balancer.SubConn
+	// 연결이 유지된 서버는 기록을 이어서 쓴다.
+	stats := make(map[balancer.SubConn]*subConnStats, len(buildInfo.ReadySCs))
+	p.leader = nil
 	for sc, scInfo := range buildInfo.ReadySCs {
+		if s, ok := p.stats[sc]; ok {
+			stats[sc] = s
+		} else {
+			stats[sc] = &subConnStats{}
+		}
 		isLeader := scInfo.Address.Attributes.Value("is_leader").(bool)
 		if isLeader {
 			p.leader = sc
@@ -32,6 +84,7 @@ func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 		followers = append(followers, sc)
 	}
 	p.followers = followers
+	p.stats = stats
 	return p
 }
 
@@ -39,28 +92,148 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
+	config := p.config
+	if config == nil {
+		config = &Config{Default: RouteAny}
+	}
+
 	var result balancer.PickResult
-	if strings.Contains(info.FullMethodName, "Produce") || len(p.followers) == 0 { // Produce 메서드는 Leader 로 보낸다.
+	switch config.route(info.FullMethodName) {
+	case RouteLeader:
 		result.SubConn = p.leader
-	} else if strings.Contains(info.FullMethodName, "Consume") { // Consume 메서드는 Follower 로 보낸다.
-		result.SubConn = p.nextFollower()
+	case RouteFollower:
+		if len(p.followers) == 0 {
+			result.SubConn = p.leader
+		} else {
+			result.SubConn = p.pickBest(p.followers, false)
+		}
+	case RouteAny:
+		result.SubConn = p.pickBest(p.all(), false)
+	case RouteNearest:
+		result.SubConn = p.pickBest(p.all(), true)
 	}
 
 	if result.SubConn == nil {
 		return result, balancer.ErrNoSubConnAvailable
 	}
+
+	stats := p.stats[result.SubConn]
+	atomic.AddInt64(&stats.outstanding, 1)
+	start := time.Now()
+	measure := !streamingMethods[info.FullMethodName]
+	result.Done = func(done balancer.DoneInfo) {
+		atomic.AddInt64(&stats.outstanding, -1)
+		if measure && done.Err == nil {
+			stats.observe(time.Since(start))
+		}
+		p.done(done)
+	}
 	return result, nil
 }
 
-// 라운드 로빈 방식으로 다음 follower 고른다.
-func (p *Picker) nextFollower() balancer.SubConn {
-	cur := atomic.AddUint64(&p.current, uint64(1))
-	len := uint64(len(p.followers))
-	idx := int(cur % len)
-	return p.followers[idx]
+// 요청이 끝나면 호출된다. 리더가 바뀌었거나 서버가 내려갔다면 다음 요청 전에 서버 목록을 다시 받는다.
+func (p *Picker) done(info balancer.DoneInfo) {
+	if p.cc == nil || info.Err == nil {
+		return
+	}
+	if status.Code(info.Err) == codes.Unavailable || api.IsNotLeader(info.Err) {
+		p.cc.ResolveNow(resolver.ResolveNowOptions{})
+	}
+}
+
+func (p *Picker) all() []balancer.SubConn {
+	if p.leader == nil {
+		return p.followers
+	}
+	return append([]balancer.SubConn{p.leader}, p.followers...)
+}
+
+/*
+처리 중인 요청 수에 응답 시간을 곱한 값이 가장 작은 서버를 고른다. (nearest 면 응답 시간만 본다.)
+아직 응답 시간을 모르는 서버는 다른 서버 중 가장 짧은 응답 시간으로 계산하고, nearest 에서는 먼저 골라서 응답 시간을 잰다.
+점수가 같으면 라운드 로빈처럼 돌아가며 고른다.
+*/
+func (p *Picker) pickBest(scs []balancer.SubConn, nearest bool) balancer.SubConn {
+	if len(scs) == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint64(&p.current, uint64(1)) % uint64(len(scs)))
+
+	var minLatency int64
+	for _, sc := range scs {
+		latency := atomic.LoadInt64(&p.stats[sc].latency)
+		if latency > 0 && (minLatency == 0 || latency < minLatency) {
+			minLatency = latency
+		}
+	}
+
+	var best balancer.SubConn
+	var bestScore float64
+	for i := range scs {
+		sc := scs[(start+i)%len(scs)]
+		stats := p.stats[sc]
+		outstanding := atomic.LoadInt64(&stats.outstanding)
+		latency := atomic.LoadInt64(&stats.latency)
+
+		var score float64
+		if nearest {
+			score = float64(latency)
+		} else {
+			if latency == 0 {
+				latency = minLatency
+			}
+			score = float64(outstanding+1) * float64(latency+1)
+		}
+		if best == nil || score < bestScore {
+			best, bestScore = sc, score
+		}
+	}
+	return best
+}
+
+/*
+클라이언트 연결마다 피커를 새로 만들어서, 피커가 자신의 연결에 ResolveNow 를 요청할 수 있게 한다.
+서비스 설정의 밸런서 설정을 파싱하고(balancer.ConfigParser), 상태가 바뀔 때마다 피커에 적용한다.
+*/
+type balancerBuilder struct{}
+
+var _ balancer.ConfigParser = balancerBuilder{}
+
+func (balancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	picker := NewPicker(cc, nil)
+	return &configBalancer{
+		Balancer: base.NewBalancerBuilder(Name, picker, base.Config{}).Build(cc, opts),
+		picker:   picker,
+	}
+}
+
+func (balancerBuilder) Name() string {
+	return Name
+}
+
+func (balancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return parseConfig(js)
+}
+
+type configBalancer struct {
+	balancer.Balancer
+	picker *Picker
+}
+
+func (b *configBalancer) UpdateClientConnState(state balancer.ClientConnState) error {
+	if config, ok := state.BalancerConfig.(*Config); ok {
+		b.picker.setConfig(config)
+	}
+	return b.Balancer.UpdateClientConnState(state)
+}
+
+func (b *configBalancer) ExitIdle() {
+	if ei, ok := b.Balancer.(balancer.ExitIdler); ok {
+		ei.ExitIdle()
+	}
 }
 
 // gRPC 에 등록
 func init() {
-	balancer.Register(base.NewBalancerBuilder(Name, &Picker{}, base.Config{}))
+	balancer.Register(balancerBuilder{})
 }
@@ -1,21 +1,25 @@
 package loadbalance_test
 
 import (
+	"errors"
 	"testing"
 
+	api "github.com/jhkim988/proglog/api/v1"
 	"github.com/jhkim988/proglog/internal/loadbalance"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 )
 
 func TestPickerNoSubConnAvailable(t *testing.T) {
 	picker := &loadbalance.Picker{}
 	for _, method := range []string{
-		"/log.vX.Log/Produce",
-		"/log.vX.Log/Consume",
+		api.Log_Produce_FullMethodName,
+		api.Log_Consume_FullMethodName,
 	} {
 		info := balancer.PickInfo{
 			FullMethodName: method,
@@ -29,7 +33,7 @@ func TestPickerNoSubConnAvailable(t *testing.T) {
 func TestPickerProducesToLeader(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
-		FullMethodName: "/log.vX.Log/Produce",
+		FullMethodName: api.Log_Produce_FullMethodName,
 	}
 	for i := 0; i < 5; i++ {
 		gotPick, err := picker.Pick(info)
@@ -41,7 +45,7 @@ func TestPickerProducesToLeader(t *testing.T) {
 func TestPickerConsumesToLeader(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
-		FullMethodName: "/log.vX.Log/Consume",
+		FullMethodName: api.Log_Consume_FullMethodName,
 	}
 	for i := 0; i < 5; i++ {
 		gotPick, err := picker.Pick(info)
@@ -50,6 +54,101 @@ func TestPickerConsumesToLeader(t *testing.T) {
 	}
 }
 
+func TestPickerResolvesOnLeaderErrors(t *testing.T) {
+	cc := &balancerConn{}
+	picker := loadbalance.NewPicker(cc, nil)
+	_, subConns := setupTest()
+	picker.Build(buildInfo(subConns))
+
+	info := balancer.PickInfo{
+		FullMethodName: api.Log_Produce_FullMethodName,
+	}
+	for _, tc := range []struct {
+		err     error
+		resolve bool
+	}{
+		{err: nil, resolve: false},
+		{err: errors.New("boom"), resolve: false},
+		{err: api.ErrOffsetOutOfRange{Offset: 1}, resolve: false},
+		{err: status.Error(codes.Unavailable, "down"), resolve: true},
+		{err: api.ErrNotLeader{Leader: "1"}, resolve: true},
+	} {
+		result, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.NotNil(t, result.Done)
+
+		before := cc.resolved
+		result.Done(balancer.DoneInfo{Err: tc.err})
+		require.Equal(t, tc.resolve, cc.resolved > before, "%v", tc.err)
+	}
+}
+
+func TestPickerRoutesByConfig(t *testing.T) {
+	_, subConns := setupTest()
+
+	// 라우팅 테이블에 없는 메서드는 어느 서버로든 보낸다.
+	picker := loadbalance.NewPicker(nil, nil)
+	picker.Build(buildInfo(subConns))
+	_, err := picker.Pick(balancer.PickInfo{FullMethodName: api.Log_GetServers_FullMethodName})
+	require.NoError(t, err)
+
+	parser := balancer.Get(loadbalance.Name).(balancer.ConfigParser)
+	_, err = parser.ParseConfig([]byte(`{"default":"somewhere"}`))
+	require.Error(t, err)
+
+	lbConfig, err := parser.ParseConfig([]byte(`{
+		"routes": {"/log.v1.Log/Consume": "leader"},
+		"default": "follower"
+	}`))
+	require.NoError(t, err)
+	picker = loadbalance.NewPicker(nil, lbConfig.(*loadbalance.Config))
+	picker.Build(buildInfo(subConns))
+
+	for _, tc := range []struct {
+		method string
+		leader bool
+	}{
+		{method: api.Log_Consume_FullMethodName, leader: true},
+		{method: api.Log_Produce_FullMethodName, leader: true},
+		{method: api.Admin_AddServer_FullMethodName, leader: true},
+		{method: api.Log_GetServers_FullMethodName, leader: false},
+	} {
+		for i := 0; i < 3; i++ {
+			result, err := picker.Pick(balancer.PickInfo{FullMethodName: tc.method})
+			require.NoError(t, err)
+			require.Equal(t, tc.leader, result.SubConn.(*subConn) == subConns[0], tc.method)
+		}
+	}
+}
+
+func TestPickerLeastOutstandingFollower(t *testing.T) {
+	_, subConns := setupTest()
+	picker := loadbalance.NewPicker(nil, nil)
+	picker.Build(buildInfo(subConns))
+	info := balancer.PickInfo{FullMethodName: api.Log_Consume_FullMethodName}
+
+	// 첫 번째 팔로워의 요청이 끝나지 않으면 다른 팔로워를 고른다.
+	busy, err := picker.Pick(info)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		result, err := picker.Pick(info)
+		require.NoError(t, err)
+		require.True(t, result.SubConn != busy.SubConn)
+		result.Done(balancer.DoneInfo{})
+	}
+	busy.Done(balancer.DoneInfo{})
+}
+
+func buildInfo(subConns []*subConn) base.PickerBuildInfo {
+	info := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for _, sc := range subConns {
+		info.ReadySCs[sc] = base.SubConnInfo{Address: sc.addrs[0]}
+	}
+	return info
+}
+
 func setupTest() (*loadbalance.Picker, []*subConn) {
 	var subConns []*subConn
 	buildInfo := base.PickerBuildInfo{
@@ -84,3 +183,13 @@ func (s *subConn) Connect() {}
 func (s *subConn) GetOrBuildProducer(builder balancer.ProducerBuilder) (p balancer.Producer, close func()) {
 	return nil, nil
 }
+
+// Mock
+type balancerConn struct {
+	balancer.ClientConn
+	resolved int
+}
+
+func (c *balancerConn) ResolveNow(resolver.ResolveNowOptions) {
+	c.resolved++
+}
@@ -2,15 +2,21 @@ package loadbalance
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	api "github.com/jhkim988/proglog/api/v1"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
 )
 
 /*
@@ -20,11 +26,22 @@ gRPC 리졸버가 GetServers() 엔드포인트를 호출하여 회신받은 정
 
 // resolver.Builder, resolver.Resolver 인터페이스 구현
 type Resolver struct {
+	// 서버 목록을 주기적으로 다시 받는 간격, 0 이면 defaultRefreshInterval
+	RefreshInterval time.Duration
+	// 서버 목록을 받지 못했을 때 다시 시도하기까지 기다리는 최대 시간, 0 이면 defaultMaxBackoff
+	MaxBackoff time.Duration
+	// 서비스 설정으로 밸런서에 전달할 라우팅 테이블, nil 이면 기본 라우팅 테이블을 쓴다.
+	Config *Config
+
 	mu            sync.Mutex
 	clientConn    resolver.ClientConn
-	resolverConn  *grpc.ClientConn
+	resolverConns []*grpc.ClientConn // 시드 주소마다 하나씩
+	current       int                // 마지막으로 응답한 시드
 	serviceConfig *serviceconfig.ParseResult
 	logger        *zap.Logger
+	cancel        context.CancelFunc
+	// ResolveNow 가 refresh 고루틴에 resolve 를 요청한다. 크기가 1이라 밀린 요청은 하나로 합친다.
+	trigger chan struct{}
 }
 
 var _ resolver.Builder = (*Resolver)(nil)
@@ -32,25 +49,155 @@ var _ resolver.Resolver = (*Resolver)(nil)
 
 const Name = "proglog"
 
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultMaxBackoff      = 10 * time.Second
+	initialBackoff         = 100 * time.Millisecond
+	resolveTimeout         = 5 * time.Second
+)
+
 // Build 인터페이스 메서드
 // 서버를 찾는 데 필요한 데이터와, 찾아낸 서버 정보로 업데이트할 클라이언트 연결을 받는다.
 func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	r.logger = zap.L().Named("resolver")
-	r.clientConn = cc
+	// 전역으로 등록한 Resolver 는 빌더 역할만 하고, 클라이언트 연결마다 새 리졸버를 만든다.
+	res := &Resolver{
+		RefreshInterval: r.RefreshInterval,
+		MaxBackoff:      r.MaxBackoff,
+		Config:          r.Config,
+		logger:          zap.L().Named("resolver"),
+		clientConn:      cc,
+		trigger:         make(chan struct{}, 1),
+	}
+	if res.RefreshInterval == 0 {
+		res.RefreshInterval = defaultRefreshInterval
+	}
+	if res.MaxBackoff == 0 {
+		res.MaxBackoff = defaultMaxBackoff
+	}
 	var dialOpts []grpc.DialOption
 	if opts.DialCreds != nil {
 		dialOpts = append(dialOpts, grpc.WithTransportCredentials(opts.DialCreds))
 	}
-	r.serviceConfig = r.clientConn.ParseServiceConfig(
-		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, Name),
+	lbConfig := []byte("{}")
+	if r.Config != nil {
+		var err error
+		if lbConfig, err = json.Marshal(r.Config); err != nil {
+			return nil, err
+		}
+	}
+	res.serviceConfig = res.clientConn.ParseServiceConfig(
+		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":%s}]}`, Name, lbConfig),
 	)
-	var err error
-	r.resolverConn, err = grpc.Dial(target.URL.Host, dialOpts...)
-	if err != nil {
-		return nil, err
+	if res.serviceConfig != nil && res.serviceConfig.Err != nil {
+		return nil, res.serviceConfig.Err
+	}
+
+	seeds := seedAddrs(target)
+	if len(seeds) == 0 {
+		return nil, fmt.Errorf("no seed address in target %q", target.URL.String())
+	}
+	for _, seed := range seeds {
+		conn, err := grpc.Dial(seed, dialOpts...)
+		if err != nil {
+			res.closeConns()
+			return nil, err
+		}
+		res.resolverConns = append(res.resolverConns, conn)
+	}
+	err := res.resolve()
+
+	var ctx context.Context
+	ctx, res.cancel = context.WithCancel(context.Background())
+	go res.watch(ctx)
+	go res.refresh(ctx, err)
+	return res, nil
+}
+
+// target 에서 시드 주소를 꺼낸다.
+// proglog://host1:8400,host2:8400 또는 proglog:///host1:8400,host2:8400 형식을 모두 받는다.
+func seedAddrs(target resolver.Target) []string {
+	hosts := target.URL.Host
+	if hosts == "" {
+		hosts = target.Endpoint()
+	}
+	var seeds []string
+	for _, seed := range strings.Split(hosts, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seeds = append(seeds, seed)
+		}
+	}
+	return seeds
+}
+
+// RefreshInterval 마다, 또는 ResolveNow 가 요청할 때마다 서버 목록을 다시 받는다.
+// 실패하면 initialBackoff 부터 두 배씩 MaxBackoff 까지 늘려가며 다시 시도한다.
+// resolve 는 이 고루틴에서만 하므로 GetServers 요청이 겹치지 않는다.
+func (r *Resolver) refresh(ctx context.Context, lastErr error) {
+	backoff := initialBackoff
+	wait := r.RefreshInterval
+	if lastErr != nil {
+		wait = backoff
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-r.trigger:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+
+		if err := r.resolve(); err != nil {
+			wait = backoff
+			backoff *= 2
+			if backoff > r.MaxBackoff {
+				backoff = r.MaxBackoff
+			}
+		} else {
+			wait = r.RefreshInterval
+			backoff = initialBackoff
+		}
+		timer.Reset(wait)
+	}
+}
+
+// 클러스터 이벤트를 구독하고, 이벤트가 올 때마다 서버 목록을 다시 받는다.
+// 리더가 바뀌면 바로 피커가 새 리더로 요청을 보낼 수 있다.
+func (r *Resolver) watch(ctx context.Context) {
+	for {
+		client := api.NewLogClient(r.currentConn())
+		stream, err := client.WatchCluster(ctx, &api.WatchClusterRequest{})
+		if err == nil {
+			for {
+				if _, err = stream.Recv(); err != nil {
+					break
+				}
+				r.ResolveNow(resolver.ResolveNowOptions{})
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			r.logger.Debug("server does not support cluster events", zap.Error(err))
+			return
+		}
+		r.logger.Error("failed to watch cluster", zap.Error(err))
+
+		// 다시 구독하기 전에 잠시 기다린다. 그동안 바뀐 내용을 놓치지 않도록 한 번 더 resolve 한다.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		r.ResolveNow(resolver.ResolveNowOptions{})
 	}
-	r.ResolveNow(resolver.ResolveNowOptions{})
-	return r, nil
 }
 
 // Build 인터페이스 메서드
@@ -66,26 +213,70 @@ func init() {
 
 // Resolver 인터페이스 메서드
 func (r *Resolver) Close() {
-	if err := r.resolverConn.Close(); err != nil {
-		r.logger.Error("failed to close conn", zap.Error(err))
+	if r.cancel != nil {
+		r.cancel()
 	}
+	r.closeConns()
+}
+
+func (r *Resolver) closeConns() {
+	for _, conn := range r.resolverConns {
+		if err := conn.Close(); err != nil {
+			r.logger.Error("failed to close conn", zap.Error(err))
+		}
+	}
+}
+
+func (r *Resolver) currentConn() *grpc.ClientConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.resolverConns[r.current]
 }
 
 // Resolver 인터페이스 메서드
 // target 에서 정보를 얻고, 서버를 찾아서 클라이언트 연결을 업데이트할 때 호출한다.
-// 리졸버가 어떻게 서버를 찾는지에 대해 구현한다.
+// gRPC 뿐만 아니라 피커도 요청이 리더가 아니거나 서버를 쓸 수 없다는 에러로 끝나면 호출한다.
+// 피커의 Done 콜백에서 호출하므로 기다리지 않고 refresh 고루틴에 맡긴다.
 func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+		// 이미 요청해 둔 resolve 가 있다.
+	}
+}
 
-	client := api.NewLogClient(r.resolverConn)
-	ctx := context.Background()
+// 리졸버가 어떻게 서버를 찾는지에 대해 구현한다.
+// 마지막으로 응답한 시드부터 차례대로 GetServers 를 요청하고, 모두 실패하면 gRPC 에 에러를 알린다.
+// 시드마다 resolveTimeout 까지 걸릴 수 있으므로 요청하는 동안 r.mu 를 잡지 않는다.
+func (r *Resolver) resolve() error {
+	r.mu.Lock()
+	start := r.current
+	r.mu.Unlock()
 
-	// GetServers 요청
-	res, err := client.GetServers(ctx, &api.GetServersRequest{})
-	if err != nil {
+	var res *api.GetServersResponse
+	var errs []error
+	for i := 0; i < len(r.resolverConns); i++ {
+		idx := (start + i) % len(r.resolverConns)
+		client := api.NewLogClient(r.resolverConns[idx])
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		// GetServers 요청
+		got, err := client.GetServers(ctx, &api.GetServersRequest{})
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.resolverConns[idx].Target(), err))
+			continue
+		}
+		r.mu.Lock()
+		r.current = idx
+		r.mu.Unlock()
+		res = got
+		break
+	}
+	if res == nil {
+		err := errors.Join(errs...)
 		r.logger.Error("failed to resolve server", zap.Error(err))
-		return
+		r.clientConn.ReportError(err)
+		return err
 	}
 
 	// GetServers 응답으로 addr 생성, 로드 밸런스가 Address 중에서 서버를 고르게 한다.
@@ -101,7 +292,7 @@ func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
 	}
 
 	// 업데이트
-	r.clientConn.UpdateState(resolver.State{
+	return r.clientConn.UpdateState(resolver.State{
 		Addresses:     addrs,
 		ServiceConfig: r.serviceConfig,
 	})
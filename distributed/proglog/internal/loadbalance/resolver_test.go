@@ -4,17 +4,21 @@ import (
 	"fmt"
 	"net"
 	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	api "github.com/jhkim988/proglog/api/v1"
 
 	"github.com/jhkim988/proglog/internal/config"
 	"github.com/jhkim988/proglog/internal/loadbalance"
 	"github.com/jhkim988/proglog/internal/server"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
 )
@@ -55,7 +59,7 @@ func TestResolver(t *testing.T) {
 	r := &loadbalance.Resolver{}
 	urlAddr, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
 	require.NoError(t, err)
-	_, err = r.Build(
+	res, err := r.Build(
 		resolver.Target{
 			URL: *urlAddr,
 		},
@@ -63,6 +67,7 @@ func TestResolver(t *testing.T) {
 		opts,
 	)
 	require.NoError(t, err)
+	defer res.Close()
 
 	wantState := resolver.State{
 		Addresses: []resolver.Address{{
@@ -73,30 +78,214 @@ func TestResolver(t *testing.T) {
 			Attributes: attributes.New("is_leader", false),
 		}},
 	}
-	require.Equal(t, wantState, conn.state)
+	require.Equal(t, wantState, conn.State())
 
-	conn.state.Addresses = nil
-	r.ResolveNow(resolver.ResolveNowOptions{})
-	require.Equal(t, wantState, conn.state)
+	conn.UpdateState(resolver.State{})
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(wantState, conn.State())
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolveNowDoesNotBlock(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	servers := &getServers{}
+	srv, err := server.NewGRPCServer(&server.Config{GetServerer: servers})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn := &clientConn{}
+	urlAddr, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
+	require.NoError(t, err)
+	res, err := (&loadbalance.Resolver{}).Build(
+		resolver.Target{URL: *urlAddr},
+		conn,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	defer res.Close()
+	require.Equal(t, 1, servers.callCount())
+
+	// 시드가 응답하지 않아도 ResolveNow 는 기다리지 않는다.
+	release := servers.hold()
+	res.ResolveNow(resolver.ResolveNowOptions{})
+	require.Eventually(t, func() bool {
+		return servers.callCount() == 2
+	}, time.Second, 10*time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		res.ResolveNow(resolver.ResolveNowOptions{})
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+
+	// 응답을 기다리는 동안 밀린 요청은 하나로 합친다.
+	release()
+	require.Eventually(t, func() bool {
+		return servers.callCount() == 3
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 3, servers.callCount())
+}
+
+func TestResolverWatchesCluster(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	servers := &getServers{}
+	watcher := &clusterWatcher{events: make(chan *api.ClusterEvent)}
+	srv, err := server.NewGRPCServer(&server.Config{
+		GetServerer:    servers,
+		ClusterWatcher: watcher,
+	})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn := &clientConn{}
+	urlAddr, err := url.Parse(fmt.Sprintf("http://%s", l.Addr().String()))
+	require.NoError(t, err)
+	res, err := (&loadbalance.Resolver{}).Build(
+		resolver.Target{URL: *urlAddr},
+		conn,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	defer res.Close()
+	require.Equal(t, "localhost:9001", conn.State().Addresses[0].Addr)
+
+	/* 리더가 바뀌었다는 이벤트를 받으면 서버 목록을 다시 받는다. */
+	servers.setLeader("localhost:9002")
+	watcher.events <- &api.ClusterEvent{
+		Type:   api.ClusterEvent_LEADER_CHANGED,
+		Server: &api.Server{Id: "follower", RpcAddr: "localhost:9002", IsLeader: true},
+	}
+	require.Eventually(t, func() bool {
+		for _, addr := range conn.State().Addresses {
+			if addr.Addr == "localhost:9002" {
+				return addr.Attributes.Value("is_leader").(bool)
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolverRefreshesFromSeeds(t *testing.T) {
+	// 응답하지 않는 시드
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadAddr := dead.Addr().String()
+	require.NoError(t, dead.Close())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// ClusterWatcher 가 없으므로 주기적인 갱신으로만 바뀐 리더를 알 수 있다.
+	servers := &getServers{}
+	srv, err := server.NewGRPCServer(&server.Config{
+		GetServerer: servers,
+	})
+	require.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	conn := &clientConn{}
+	urlAddr, err := url.Parse(fmt.Sprintf("%s:///%s,%s", loadbalance.Name, deadAddr, l.Addr().String()))
+	require.NoError(t, err)
+	res, err := (&loadbalance.Resolver{
+		RefreshInterval: 50 * time.Millisecond,
+	}).Build(
+		resolver.Target{URL: *urlAddr},
+		conn,
+		resolver.BuildOptions{DialCreds: insecure.NewCredentials()},
+	)
+	require.NoError(t, err)
+	defer res.Close()
+	require.Equal(t, "localhost:9001", conn.State().Addresses[0].Addr)
+
+	servers.setLeader("localhost:9002")
+	require.Eventually(t, func() bool {
+		addrs := conn.State().Addresses
+		return len(addrs) == 2 && addrs[1].Attributes.Value("is_leader").(bool)
+	}, time.Second, 10*time.Millisecond)
 }
 
 // Mock
-type getServers struct{}
+type getServers struct {
+	mu     sync.Mutex
+	leader string
+	calls  int
+	// 닫힐 때까지 응답하지 않는다.
+	held chan struct{}
+}
 
 func (s *getServers) GetServers() ([]*api.Server, error) {
+	s.mu.Lock()
+	s.calls++
+	held := s.held
+	s.mu.Unlock()
+	if held != nil {
+		<-held
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leader := s.leader
+	if leader == "" {
+		leader = "localhost:9001"
+	}
 	return []*api.Server{{
 		Id:       "leader",
 		RpcAddr:  "localhost:9001",
-		IsLeader: true,
+		IsLeader: leader == "localhost:9001",
 	}, {
-		Id:      "follower",
-		RpcAddr: "localhost:9002",
+		Id:       "follower",
+		RpcAddr:  "localhost:9002",
+		IsLeader: leader == "localhost:9002",
 	}}, nil
 }
 
+func (s *getServers) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// 리턴한 함수를 호출할 때까지 GetServers 가 응답하지 않는다.
+func (s *getServers) hold() (release func()) {
+	held := make(chan struct{})
+	s.mu.Lock()
+	s.held = held
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		s.held = nil
+		s.mu.Unlock()
+		close(held)
+	}
+}
+
+func (s *getServers) setLeader(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leader = addr
+}
+
+// Mock
+type clusterWatcher struct {
+	events chan *api.ClusterEvent
+}
+
+func (w *clusterWatcher) WatchCluster() (<-chan *api.ClusterEvent, func()) {
+	return w.events, func() {}
+}
+
 // Mock
 type clientConn struct {
 	resolver.ClientConn
+	mu    sync.Mutex
 	state resolver.State
 }
 
@@ -111,6 +300,14 @@ func (*clientConn) ParseServiceConfig(serviceConfigJSON string) *serviceconfig.P
 func (*clientConn) ReportError(error) {}
 
 func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.state = state
 	return nil
 }
+
+func (c *clientConn) State() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
@@ -1,6 +1,10 @@
 package log
 
-import "github.com/hashicorp/raft"
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+)
 
 type Config struct {
 	Raft struct {
@@ -13,4 +17,13 @@ type Config struct {
 		MaxIndexBytes uint64
 		InitialOffset uint64
 	}
+	Producer struct {
+		// 마지막 요청 후 IdleTimeout 이 지난 프로듀서는 잊는다. 0 이면 defaultProducerIdleTimeout
+		// FSM 이 쓰므로 모든 노드가 같은 값을 써야 한다.
+		IdleTimeout time.Duration
+	}
+	Txn struct {
+		// 리더는 시작한 지 Timeout 이 지난 트랜잭션을 중단한다. 0 이면 defaultTxnTimeout
+		Timeout time.Duration
+	}
 }
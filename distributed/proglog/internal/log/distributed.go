@@ -2,7 +2,10 @@ package log
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net"
@@ -13,19 +16,29 @@ import (
 	"github.com/hashicorp/raft"
 	raftboltdb "github.com/hashicorp/raft-boltdb"
 	api "github.com/jhkim988/proglog/api/v1"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/propagation"
 	"google.golang.org/protobuf/proto"
 )
 
 type DistributedLog struct {
-	config  Config
-	log     *Log
-	raftLog *logStore
-	raft    *raft.Raft
+	config       Config
+	log          *Log
+	fsm          *fsm
+	raftLog      *logStore
+	stableStore  *raftboltdb.BoltStore
+	raft         *raft.Raft
+	transport    *commitTrackingTransport
+	observer     *raft.Observer
+	observations chan raft.Observation
+	events       eventBus
+	shutdown     chan struct{}
 }
 
 func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
 	l := &DistributedLog{
-		config: config,
+		config:   config,
+		shutdown: make(chan struct{}),
 	}
 
 	if err := l.setupLog(dataDir); err != nil {
@@ -35,6 +48,7 @@ func NewDistributedLog(dataDir string, config Config) (*DistributedLog, error) {
 	if err := l.setupRaft(dataDir); err != nil {
 		return nil, err
 	}
+	go l.expireTxns()
 	return l, nil
 }
 
@@ -58,7 +72,14 @@ func (l *DistributedLog) setupLog(dataDir string) error {
 5. 다른 서버에 연결할 때 사용하는 transport
 */
 func (l *DistributedLog) setupRaft(dataDir string) error {
-	fsm := &fsm{log: l.log}
+	fsm := &fsm{
+		log:          l.log,
+		producers:    producerTable{},
+		producerIdle: l.config.Producer.IdleTimeout,
+		txns:         newTxnTable(),
+		localID:      l.config.Raft.LocalID,
+	}
+	l.fsm = fsm
 
 	/* 로그 저장소 설정 */
 	logDir := filepath.Join(dataDir, "raft", "log")
@@ -81,31 +102,55 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 	if err != nil {
 		return err
 	}
+	l.stableStore = stableStore
 
 	/* snapshot store 설정 */
 	/* 새로운 인스턴스가 래프트 리더로부터 모든 데이터를 스트리밍 받는 것보다 스냅숏에서 복원하는 편이 효율적이다. */
 	retain := 1
-	snapshotStore, err := raft.NewFileSnapshotStore(
-		filepath.Join(dataDir, "raft"),
-		retain,
-		os.Stderr,
-	)
-	if err != nil {
-		return err
-	}
-
 	/* transport 설정 */
 	maxPool := 5
 	timeout := 10 * time.Second
-	transport := raft.NewNetworkTransport(
-		l.config.Raft.StreamLayer,
-		maxPool,
-		timeout,
-		os.Stderr,
+
+	var (
+		snapshotStore *raft.FileSnapshotStore
+		transport     *raft.NetworkTransport
 	)
+	/* Logger 가 있으면 raft 의 모든 로그를 Logger 로 남기고, 없으면 stderr 에 쓴다. */
+	if logger := l.config.Raft.Logger; logger != nil {
+		snapshotStore, err = raft.NewFileSnapshotStoreWithLogger(
+			filepath.Join(dataDir, "raft"),
+			retain,
+			logger.Named("snapshot"),
+		)
+		if err != nil {
+			return err
+		}
+		transport = raft.NewNetworkTransportWithLogger(
+			l.config.Raft.StreamLayer,
+			maxPool,
+			timeout,
+			logger.Named("transport"),
+		)
+	} else {
+		snapshotStore, err = raft.NewFileSnapshotStore(
+			filepath.Join(dataDir, "raft"),
+			retain,
+			os.Stderr,
+		)
+		if err != nil {
+			return err
+		}
+		transport = raft.NewNetworkTransport(
+			l.config.Raft.StreamLayer,
+			maxPool,
+			timeout,
+			os.Stderr,
+		)
+	}
 
 	config := raft.DefaultConfig()
 	config.LocalID = l.config.Raft.LocalID
+	config.Logger = l.config.Raft.Logger
 	if l.config.Raft.HeartbeatTimeout != 0 {
 		config.HeartbeatTimeout = l.config.Raft.HeartbeatTimeout
 	}
@@ -120,18 +165,31 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 	}
 
 	/* raft 인스턴스 생성 */
+	l.transport = newCommitTrackingTransport(transport, l.shutdown)
 	l.raft, err = raft.NewRaft(
 		config,
 		fsm,
 		l.raftLog,
 		stableStore,
 		snapshotStore,
-		transport,
+		l.transport,
 	)
 	if err != nil {
 		return err
 	}
 
+	/* 리더 변경, 서버 추가/제거, heartbeat 실패를 관찰해서 이벤트 버스로 보낸다. */
+	l.observations = make(chan raft.Observation, eventBufferSize)
+	l.observer = raft.NewObserver(l.observations, false, func(o *raft.Observation) bool {
+		switch o.Data.(type) {
+		case raft.LeaderObservation, raft.PeerObservation, raft.FailedHeartbeatObservation:
+			return true
+		}
+		return false
+	})
+	l.raft.RegisterObserver(l.observer)
+	go l.observe()
+
 	hasState, err := raft.HasExistingState(
 		l.raftLog,
 		stableStore,
@@ -157,10 +215,27 @@ func (l *DistributedLog) setupRaft(dataDir string) error {
 
 /* DistributedLog 구조체는 Log 구조체와 같은 API 를 가지도록 하여, 서로 호환되도록 한다. */
 func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
+	return l.AppendContext(context.Background(), record, "", 0)
+}
+
+/*
+같은 프로듀서가 같은 sequence 로 다시 보낸 레코드는 추가하지 않고 처음 추가한 오프셋을 리턴한다.
+sequence 가 건너뛰면 api.ErrOutOfOrderSequence 를 리턴한다.
+*/
+func (l *DistributedLog) AppendIdempotent(record *api.Record, producerID string, sequence uint64) (uint64, error) {
+	return l.AppendContext(context.Background(), record, producerID, sequence)
+}
+
+/*
+ctx 의 trace 를 이어서 raft 커밋과 각 노드의 FSM 적용을 기록한다.
+producerID 가 비어 있으면 Append, 아니면 AppendIdempotent 와 같다.
+*/
+func (l *DistributedLog) AppendContext(ctx context.Context, record *api.Record, producerID string, sequence uint64) (uint64, error) {
 	/* 서버의 로그에 직접 추가하지 않고, FSM 이 레코드를 로그에 추가하도록 한다. */
 	res, err := l.apply(
+		ctx,
 		AppendRequestType,
-		&api.ProduceRequest{Record: record},
+		&api.ProduceRequest{Record: record, ProducerId: producerID, Sequence: sequence},
 	)
 	if err != nil {
 		return 0, err
@@ -168,8 +243,18 @@ func (l *DistributedLog) Append(record *api.Record) (uint64, error) {
 	return res.(*api.ProduceResponse).Offset, nil
 }
 
-/* raft API 를 감싸고, API 응답을 리턴한다. */
-func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interface{}, error) {
+/*
+raft API 를 감싸고, API 응답을 리턴한다.
+ctx 의 span 이 기록 중이면 span context 를 raft 로그의 Extensions 로 복제해서 모든 노드의 FSM 적용을 같은 trace 로 남긴다.
+*/
+func (l *DistributedLog) apply(ctx context.Context, reqType RequestType, req proto.Message) (interface{}, error) {
+	_, span := trace.StartSpan(ctx, "proglog.DistributedLog.apply")
+	defer span.End()
+	span.AddAttributes(
+		trace.Int64Attribute("proglog.request_type", int64(reqType)),
+		trace.StringAttribute("proglog.node", string(l.config.Raft.LocalID)),
+	)
+
 	// 요청을 직렬화하여 byte 로 만든다.
 	var buf bytes.Buffer
 
@@ -188,17 +273,27 @@ func (l *DistributedLog) apply(reqType RequestType, req proto.Message) (interfac
 		return nil, err
 	}
 
+	entry := raft.Log{Data: buf.Bytes()}
+	if span.IsRecordingEvents() {
+		entry.Extensions = propagation.Binary(span.SpanContext())
+	}
+
 	// 로그복제를 수행하고 리더의 로그에 레코드를 추가한다.
 	timeout := 10 * time.Second
-	future := l.raft.Apply(buf.Bytes(), timeout)
+	start := time.Now()
+	future := l.raft.ApplyLog(entry, timeout)
 	// Error(): raft 복제가 잘못되었을 때 에러를 리턴한다. (수행시간이 너무 오래 걸리거나, 정지해야할 때)
-	if future.Error() != nil {
-		return nil, future.Error()
+	if err := future.Error(); err != nil {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnavailable, Message: err.Error()})
+		return nil, l.notLeader(err)
 	}
+	recordLatency(raftCommitLatency, start)
+	span.AddAttributes(trace.Int64Attribute("raft.index", int64(future.Index())))
 
 	// Response(): FSM 의 Apply() 메서드가 리턴하는 것을 받아 리턴한다.
 	res := future.Response()
 	if err, ok := res.(error); ok {
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
 		return nil, err
 	}
 	return res, nil
@@ -208,6 +303,113 @@ func (l *DistributedLog) Read(offset uint64) (*api.Record, error) {
 	return l.log.Read(offset)
 }
 
+// 이 서버의 로그에 있는 가장 작은 오프셋, 팔로워는 리더보다 뒤처져 있을 수 있다.
+func (l *DistributedLog) LowestOffset() (uint64, error) {
+	return l.log.LowestOffset()
+}
+
+func (l *DistributedLog) HighestOffset() (uint64, error) {
+	return l.log.HighestOffset()
+}
+
+// FSM 이 레코드나 트랜잭션 표시를 추가하면 닫히는 채널, 컨슈머가 새 레코드를 기다릴 때 사용한다.
+func (l *DistributedLog) Changed() <-chan struct{} {
+	return l.log.Changed()
+}
+
+/*
+offset 부터 read_committed 로 읽을 수 있는 첫 레코드를 리턴한다.
+열려 있는 트랜잭션의 레코드에 닿으면 트랜잭션이 끝날 때까지 api.ErrOffsetOutOfRange 를 리턴한다.
+레코드를 읽은 다음 트랜잭션 상태를 확인하므로, 그 사이에 추가된 트랜잭션 레코드도 걸러낸다.
+*/
+func (l *DistributedLog) ReadCommitted(offset uint64) (*api.Record, error) {
+	for {
+		record, err := l.log.Read(offset)
+		if err != nil {
+			return nil, err
+		}
+		visible, barrier := l.fsm.txns.visible(record)
+		if barrier {
+			return nil, api.ErrOffsetOutOfRange{Offset: offset}
+		}
+		if visible {
+			return record, nil
+		}
+		offset++
+	}
+}
+
+/*
+트랜잭션 API
+트랜잭션 ID 는 BeginTxn 요청이 커밋된 raft 로그의 인덱스라서 모든 노드에서 같다.
+AppendTxn 은 레코드를 바로 로그에 추가하고, read_committed 컨슈머는 CommitTxn 후에야 읽을 수 있다.
+AppendContext 처럼 ctx 의 trace 를 이어서 기록한다.
+*/
+func (l *DistributedLog) BeginTxn(ctx context.Context) (uint64, error) {
+	res, err := l.apply(ctx, BeginTxnRequestType, &api.BeginTxnRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return res.(*api.BeginTxnResponse).TxnId, nil
+}
+
+func (l *DistributedLog) AppendTxn(ctx context.Context, txnID uint64, record *api.Record) (uint64, error) {
+	res, err := l.apply(
+		ctx,
+		AppendTxnRequestType,
+		&api.AppendTxnRequest{TxnId: txnID, Record: record},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.(*api.AppendTxnResponse).Offset, nil
+}
+
+func (l *DistributedLog) CommitTxn(ctx context.Context, txnID uint64) (uint64, error) {
+	res, err := l.apply(ctx, CommitTxnRequestType, &api.CommitTxnRequest{TxnId: txnID})
+	if err != nil {
+		return 0, err
+	}
+	return res.(*api.CommitTxnResponse).Offset, nil
+}
+
+func (l *DistributedLog) AbortTxn(ctx context.Context, txnID uint64) (uint64, error) {
+	res, err := l.apply(ctx, AbortTxnRequestType, &api.AbortTxnRequest{TxnId: txnID})
+	if err != nil {
+		return 0, err
+	}
+	return res.(*api.AbortTxnResponse).Offset, nil
+}
+
+const defaultTxnTimeout = time.Minute
+
+/*
+끝내지 않은 트랜잭션이 있으면 read_committed 컨슈머가 그 뒤를 읽지 못하므로,
+리더는 시작한 지 Txn.Timeout 이 지난 트랜잭션을 raft 로 중단해서 모든 노드가 같은 상태를 갖게 한다.
+중단에 실패하면 다음 확인 때 다시 시도한다.
+*/
+func (l *DistributedLog) expireTxns() {
+	timeout := l.config.Txn.Timeout
+	if timeout == 0 {
+		timeout = defaultTxnTimeout
+	}
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.shutdown:
+			return
+		case <-ticker.C:
+		}
+		if !l.IsLeader() {
+			continue
+		}
+		for _, txnID := range l.fsm.txns.expired(timeout, time.Now()) {
+			_, _ = l.AbortTxn(context.Background(), txnID)
+		}
+	}
+}
+
 // fsm 이 raft.FSM 인터페이스를 만족하는지 확인.
 var _ raft.FSM = (*fsm)(nil)
 
@@ -219,29 +421,76 @@ var _ raft.FSM = (*fsm)(nil)
 */
 
 type fsm struct {
-	log *Log
+	log       *Log
+	producers producerTable
+	// 0 이면 defaultProducerIdleTimeout
+	producerIdle time.Duration
+	// 마지막으로 프로듀서 테이블을 정리한 요청의 시각
+	producersPruned time.Time
+	txns            *txnTable
+	localID         raft.ServerID // span 에 남길 노드 ID
 }
 
 type RequestType uint8
 
 // 여러 명령을 지원하도록 구현하려면, RequestType 상수를 추가한다.
 const (
-	AppendRequestType RequestType = 0
+	AppendRequestType    RequestType = 0
+	BeginTxnRequestType  RequestType = 1
+	AppendTxnRequestType RequestType = 2
+	CommitTxnRequestType RequestType = 3
+	AbortTxnRequestType  RequestType = 4
 )
 
 func (l *fsm) Apply(record *raft.Log) interface{} {
+	defer recordLatency(fsmApplyLatency, time.Now())
+	// 리더가 기록 중인 요청이면 리더의 apply span 아래에 이 노드의 적용을 기록한다.
+	if sc, ok := propagation.FromBinary(record.Extensions); ok && sc.IsSampled() {
+		_, span := trace.StartSpanWithRemoteParent(context.Background(), "proglog.fsm.Apply", sc, trace.WithSampler(trace.AlwaysSample()))
+		span.AddAttributes(
+			trace.StringAttribute("proglog.node", string(l.localID)),
+			trace.Int64Attribute("raft.index", int64(record.Index)),
+			trace.Int64Attribute("raft.term", int64(record.Term)),
+		)
+		defer span.End()
+	}
 	buf := record.Data
 	reqType := RequestType(buf[0])
 
 	// RequestType 에 따라 처리한다.
 	switch reqType {
 	case AppendRequestType:
-		return l.applyAppend(buf[1:])
+		return l.applyAppend(buf[1:], record.AppendedAt)
+	case BeginTxnRequestType:
+		return l.applyBeginTxn(record.Index, record.AppendedAt)
+	case AppendTxnRequestType:
+		return l.applyAppendTxn(buf[1:])
+	case CommitTxnRequestType:
+		var req api.CommitTxnRequest
+		if err := proto.Unmarshal(buf[1:], &req); err != nil {
+			return err
+		}
+		offset, err := l.endTxn(req.TxnId, true)
+		if err != nil {
+			return err
+		}
+		return &api.CommitTxnResponse{Offset: offset}
+	case AbortTxnRequestType:
+		var req api.AbortTxnRequest
+		if err := proto.Unmarshal(buf[1:], &req); err != nil {
+			return err
+		}
+		offset, err := l.endTxn(req.TxnId, false)
+		if err != nil {
+			return err
+		}
+		return &api.AbortTxnResponse{Offset: offset}
 	}
 	return nil
 }
 
-func (l *fsm) applyAppend(b []byte) interface{} {
+// appended 는 리더가 요청을 raft 로그에 추가한 시각이다.
+func (l *fsm) applyAppend(b []byte, appended time.Time) interface{} {
 	// 요청을 역직렬화한다.
 	var req api.ProduceRequest
 	err := proto.Unmarshal(b, &req)
@@ -249,32 +498,143 @@ func (l *fsm) applyAppend(b []byte) interface{} {
 		return err
 	}
 
+	cutoff := l.producerCutoff(appended)
+	if !cutoff.IsZero() && appended.Sub(l.producersPruned) >= producerPruneInterval {
+		l.producers.prune(cutoff)
+		l.producersPruned = appended
+	}
+
+	// 프로듀서 ID 가 있으면 이미 추가한 요청인지 확인한다.
+	if req.ProducerId != "" {
+		offset, dup, err := l.producers.check(req.ProducerId, req.Sequence, cutoff)
+		if err != nil {
+			return err
+		}
+		if dup {
+			return &api.ProduceResponse{Offset: offset}
+		}
+	}
+
+	// 트랜잭션 필드는 트랜잭션 요청으로만 채운다.
+	if req.Record == nil {
+		req.Record = &api.Record{}
+	}
+	req.Record.TxnId = 0
+	req.Record.Marker = api.Record_NONE
+
 	// 로그에 추가한다.
 	offset, err := l.log.Append(req.Record)
 	if err != nil {
 		return err
 	}
+	if req.ProducerId != "" {
+		l.producers.record(req.ProducerId, req.Sequence, offset, appended, cutoff)
+	}
 	return &api.ProduceResponse{Offset: offset}
 }
 
+// 이 시각보다 먼저 마지막 요청을 보낸 프로듀서는 잊는다. 요청 시각을 모르면 zero
+func (l *fsm) producerCutoff(appended time.Time) time.Time {
+	if appended.IsZero() {
+		return time.Time{}
+	}
+	idle := l.producerIdle
+	if idle == 0 {
+		idle = defaultProducerIdleTimeout
+	}
+	return appended.Add(-idle)
+}
+
+// started 는 리더가 요청을 raft 로그에 추가한 시각이다. 로그 저장소가 함께 저장하므로 모든 노드에서 같다.
+func (l *fsm) applyBeginTxn(txnID uint64, started time.Time) interface{} {
+	l.txns.begin(txnID, started)
+	return &api.BeginTxnResponse{TxnId: txnID}
+}
+
+func (l *fsm) applyAppendTxn(b []byte) interface{} {
+	var req api.AppendTxnRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return err
+	}
+	if err := l.txns.checkOpen(req.TxnId); err != nil {
+		return err
+	}
+
+	if req.Record == nil {
+		req.Record = &api.Record{}
+	}
+	req.Record.TxnId = req.TxnId
+	req.Record.Marker = api.Record_NONE
+	offset, err := l.log.Append(req.Record)
+	if err != nil {
+		return err
+	}
+	l.txns.append(req.TxnId, offset)
+	return &api.AppendTxnResponse{Offset: offset}
+}
+
+// 트랜잭션을 끝내는 표시 레코드를 추가하고 그 오프셋을 리턴한다.
+func (l *fsm) endTxn(txnID uint64, commit bool) (uint64, error) {
+	if err := l.txns.checkOpen(txnID); err != nil {
+		return 0, err
+	}
+	marker := api.Record_ABORT
+	if commit {
+		marker = api.Record_COMMIT
+	}
+	offset, err := l.log.Append(&api.Record{TxnId: txnID, Marker: marker})
+	if err != nil {
+		return 0, err
+	}
+	l.txns.end(txnID, commit, offset)
+	if lowest, err := l.log.LowestOffset(); err == nil {
+		l.txns.prune(lowest)
+	}
+	return offset, nil
+}
+
 /* FSM의 상태에 대한 특정 시점의 snapshot 을 리턴한다. */
 /* raft 는 snapshot 을 찍을 시간을 체크하는 SnapshotInterval 설정과, 마지막 snapshot 이후 추가한 로그 개수인 SnapshotThreshold 설정에 따라 Snpashot 메서드를 호출한다. */
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 	// io.Reader 를 리턴하여 모든 로그 데이터를 읽을 수 있게 한다.
 	r := f.log.Reader()
-	return &snapshot{reader: r}, nil
+	header := snapshotHeader{
+		Producers: f.producers.clone(),
+		Txns:      f.txns.state(),
+	}
+	return &snapshot{header: header, reader: r}, nil
+}
+
+/*
+스냅샷 형식
+
+	snapshotMagic (8 byte) | 헤더 길이 (8 byte) | 헤더 (JSON) | 로그 레코드 ...
+
+헤더에는 로그 레코드 외의 FSM 상태를 담는다.
+snapshotMagic 은 레코드 길이로 읽으면 말이 안 되는 값이므로, 헤더가 없는 이전 형식의 스냅샷도 복원할 수 있다.
+*/
+var snapshotMagic = []byte{0xff, 'p', 'r', 'o', 'g', 'l', 'o', 'g'}
+
+type snapshotHeader struct {
+	Producers producerTable `json:"producers"`
+	Txns      txnState      `json:"txns"`
 }
 
 /* snapshot 이 raft.FSMSnapShot 인터페이스를 만족하는지 확인하는 코드 */
 var _ raft.FSMSnapshot = (*snapshot)(nil)
 
 type snapshot struct {
+	header snapshotHeader
 	reader io.Reader
 }
 
 /* FSMSnapshot 에 Persist 를 호출하여 상태를 sink 에 쓰도록 한다. */
 /* sink 는 snapshot 의 저장소, 인메모리, 파일, S3 bucket 등을 설정할 수 있다. */
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
+	if err := s.persistHeader(sink); err != nil {
+		_ = sink.Cancel()
+		return err
+	}
 	if _, err := io.Copy(sink, s.reader); err != nil {
 		_ = sink.Cancel()
 		return err
@@ -282,14 +642,40 @@ func (s *snapshot) Persist(sink raft.SnapshotSink) error {
 	return sink.Close()
 }
 
+func (s *snapshot) persistHeader(w io.Writer) error {
+	header, err := json.Marshal(s.header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(snapshotMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(w, enc, uint64(len(header))); err != nil {
+		return err
+	}
+	_, err = w.Write(header)
+	return err
+}
+
 /* Snapshot 을 찍고나면 Release 를 호출한다. */
 func (s *snapshot) Release() {}
 
 /* 기존의 상태를 없애고, 리더의 복제 상태와 똑같아지도록 한다. */
-func (f *fsm) Restore(r io.ReadCloser) error {
+func (f *fsm) Restore(rc io.ReadCloser) error {
 	b := make([]byte, lenWidth)
 	var buf bytes.Buffer
 
+	var header snapshotHeader
+	r, err := restoreHeader(rc, &header)
+	if err != nil {
+		return err
+	}
+	f.producers = header.Producers
+	if f.producers == nil {
+		f.producers = producerTable{}
+	}
+	f.txns.restore(header.Txns)
+
 	for i := 0; ; i++ {
 		_, err := io.ReadFull(r, b)
 		if err == io.EOF {
@@ -321,9 +707,43 @@ func (f *fsm) Restore(r io.ReadCloser) error {
 
 		buf.Reset()
 	}
+	// 스냅샷이 로그 앞부분을 지웠으면 그 안에서 끝난 중단 트랜잭션은 잊는다.
+	lowest, err := f.log.LowestOffset()
+	if err != nil {
+		return err
+	}
+	f.txns.prune(lowest)
 	return nil
 }
 
+// 헤더가 있으면 읽고, 이어서 로그 레코드를 읽을 Reader 를 리턴한다.
+func restoreHeader(r io.Reader, header *snapshotHeader) (io.Reader, error) {
+	magic := make([]byte, len(snapshotMagic))
+	n, err := io.ReadFull(r, magic)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return bytes.NewReader(magic[:n]), nil
+	} else if err != nil {
+		return nil, err
+	}
+	// 이전 형식의 스냅샷은 첫 레코드의 길이부터 시작한다.
+	if !bytes.Equal(magic, snapshotMagic) {
+		return io.MultiReader(bytes.NewReader(magic), r), nil
+	}
+
+	var size uint64
+	if err := binary.Read(r, enc, &size); err != nil {
+		return nil, err
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
 /* raft 는 managed 로그 저장소에서 *raft.Log 를 읽어, FSM 의 Apply() 에 넣는다. */
 /* logStore 가 raft.LogStore 인터페이스를 만족하는지 확인 */
 var _ raft.LogStore = (*logStore)(nil)
@@ -337,6 +757,7 @@ func newLogStore(dir string, c Config) (*logStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	log.skipMetrics = true
 	return &logStore{log}, nil
 }
 
@@ -358,6 +779,10 @@ func (l *logStore) GetLog(index uint64, out *raft.Log) error {
 	out.Index = in.Offset
 	out.Type = raft.LogType(in.Type)
 	out.Term = in.Term
+	out.Extensions = in.Extensions
+	if in.AppendedAt != 0 {
+		out.AppendedAt = time.Unix(0, in.AppendedAt)
+	}
 	return nil
 }
 
@@ -365,12 +790,19 @@ func (l *logStore) StoreLog(record *raft.Log) error {
 	return l.StoreLogs([]*raft.Log{record})
 }
 
+// FSM 은 AppendedAt 으로 시각을 재므로, 팔로워와 재시작한 노드도 리더와 같은 시각을 읽도록 함께 저장한다.
 func (l *logStore) StoreLogs(records []*raft.Log) error {
 	for _, record := range records {
+		var appendedAt int64
+		if !record.AppendedAt.IsZero() {
+			appendedAt = record.AppendedAt.UnixNano()
+		}
 		if _, err := l.Append(&api.Record{
-			Value: record.Data,
-			Term:  record.Term,
-			Type:  uint32(record.Type),
+			Value:      record.Data,
+			Term:       record.Term,
+			Type:       uint32(record.Type),
+			Extensions: record.Extensions,
+			AppendedAt: appendedAt,
 		}); err != nil {
 			return err
 		}
@@ -390,8 +822,14 @@ type StreamLayer struct {
 	ln              net.Listener
 	serverTLSConfig *tls.Config
 	peerTLSConfig   *tls.Config
+	// 다른 서버에 TCP 연결을 맺는다. nil 이면 net.Dialer 를 쓴다.
+	// 테스트에서 네트워크 분할이나 지연을 흉내낼 때 바꾼다. (agenttest)
+	Dialer Dialer
 }
 
+// addr 로 연결을 맺는다. 연결한 뒤의 RaftRPC 바이트와 TLS 는 StreamLayer 가 처리한다.
+type Dialer func(addr string, timeout time.Duration) (net.Conn, error)
+
 func NewStreamLayer(ln net.Listener, serverTLSConfig, peerTLSConfig *tls.Config) *StreamLayer {
 	return &StreamLayer{
 		ln:              ln,
@@ -404,8 +842,14 @@ const RaftRPC = 1
 
 // Raft 클러스트의 다른 서버와 연결한다.
 func (s *StreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
-	dialer := &net.Dialer{Timeout: timeout}
-	var conn, err = dialer.Dial("tcp", string(addr))
+	dial := s.Dialer
+	if dial == nil {
+		dial = func(addr string, timeout time.Duration) (net.Conn, error) {
+			dialer := &net.Dialer{Timeout: timeout}
+			return dialer.Dial("tcp", addr)
+		}
+	}
+	var conn, err = dial(string(addr), timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -515,11 +959,87 @@ func (l *DistributedLog) WaitForLeader(timeout time.Duration) error {
 	}
 }
 
+func (l *DistributedLog) observe() {
+	for o := range l.observations {
+		event := &api.ClusterEvent{}
+		switch data := o.Data.(type) {
+		case raft.LeaderObservation:
+			event.Type = api.ClusterEvent_LEADER_CHANGED
+			if data.LeaderID != "" {
+				event.Server = &api.Server{
+					Id:       string(data.LeaderID),
+					RpcAddr:  string(data.LeaderAddr),
+					IsLeader: true,
+				}
+			}
+		case raft.PeerObservation:
+			event.Type = api.ClusterEvent_SERVER_ADDED
+			if data.Removed {
+				event.Type = api.ClusterEvent_SERVER_REMOVED
+			}
+			event.Server = &api.Server{
+				Id:      string(data.Peer.ID),
+				RpcAddr: string(data.Peer.Address),
+			}
+		case raft.FailedHeartbeatObservation:
+			event.Type = api.ClusterEvent_HEARTBEAT_FAILED
+			event.Server = &api.Server{Id: string(data.PeerID)}
+		}
+		l.events.publish(event)
+	}
+}
+
+// 클러스터 이벤트를 구독한다. 다 쓰면 리턴한 함수를 호출해서 구독을 취소해야 한다.
+func (l *DistributedLog) WatchCluster() (<-chan *api.ClusterEvent, func()) {
+	return l.events.subscribe()
+}
+
+func (l *DistributedLog) Usage() Usage {
+	return l.log.Usage()
+}
+
+// 알고 있는 리더의 ID, 모르면 빈 문자열
+func (l *DistributedLog) Leader() string {
+	_, id := l.raft.LeaderWithID()
+	return string(id)
+}
+
+/*
+저장했거나 리더가 커밋했다고 알려 왔지만 아직 FSM 에 적용하지 않은 raft 로그 수
+팔로워는 리더에게 받은 커밋 인덱스와 비교하므로, 리더보다 뒤처져 아직 저장하지 못한 로그도 센다.
+*/
+func (l *DistributedLog) ApplyLag() uint64 {
+	last, applied := l.raft.LastIndex(), l.raft.AppliedIndex()
+	if commit := l.transport.leaderCommit.Load(); commit > last {
+		last = commit
+	}
+	if applied >= last {
+		return 0
+	}
+	return last - applied
+}
+
+func (l *DistributedLog) IsLeader() bool {
+	return l.raft.State() == raft.Leader
+}
+
 func (l *DistributedLog) Close() error {
+	close(l.shutdown)
+	l.raft.DeregisterObserver(l.observer)
+	close(l.observations)
+	l.events.close()
+
 	f := l.raft.Shutdown()
 	if err := f.Error(); err != nil {
 		return err
 	}
+	// 같은 디렉터리로 다시 열 수 있도록 raft 가 쓰던 저장소도 닫는다. (bolt 는 파일을 잠근다.)
+	if err := l.stableStore.Close(); err != nil {
+		return err
+	}
+	if err := l.raftLog.Close(); err != nil {
+		return err
+	}
 	return l.log.Close()
 }
 
@@ -529,13 +1049,83 @@ func (l *DistributedLog) GetServers() ([]*api.Server, error) {
 		return nil, err
 	}
 
+	// 리더가 알려준 주소는 리스너 주소라서 설정에 등록된 주소와 다를 수 있으므로 ID 로 비교한다.
+	_, leaderID := l.raft.LeaderWithID()
 	var servers []*api.Server
 	for _, server := range future.Configuration().Servers {
 		servers = append(servers, &api.Server{
 			Id:       string(server.ID),
 			RpcAddr:  string(server.Address),
-			IsLeader: l.raft.Leader() == server.Address,
+			IsLeader: leaderID == server.ID,
 		})
 	}
 	return servers, nil
 }
+
+/*
+클러스터 관리 API
+Serf 이벤트를 기다리지 않고 운영자가 직접 raft 설정을 바꿀 때 사용한다. (노드 점검 전 리더십 이전 등)
+설정 변경은 리더만 할 수 있으므로, 팔로워에서 호출하면 api.ErrNotLeader 를 리턴한다.
+*/
+func (l *DistributedLog) AddServer(id, addr string, voter bool) error {
+	var future raft.IndexFuture
+	if voter {
+		future = l.raft.AddVoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	} else {
+		future = l.raft.AddNonvoter(raft.ServerID(id), raft.ServerAddress(addr), 0, 0)
+	}
+	return l.notLeader(future.Error())
+}
+
+func (l *DistributedLog) RemoveServer(id string) error {
+	future := l.raft.RemoveServer(raft.ServerID(id), 0, 0)
+	return l.notLeader(future.Error())
+}
+
+// id 와 addr 가 비어있으면 raft 가 가장 최신 로그를 가진 팔로워를 고른다.
+func (l *DistributedLog) TransferLeadership(id, addr string) error {
+	var future raft.Future
+	if id == "" && addr == "" {
+		future = l.raft.LeadershipTransfer()
+	} else {
+		future = l.raft.LeadershipTransferToServer(raft.ServerID(id), raft.ServerAddress(addr))
+	}
+	if err := future.Error(); err != nil {
+		return l.notLeader(err)
+	}
+
+	// future 는 대상 서버에 선거를 시작하라고 알린 직후 완료된다.
+	// 그 사이에 로그를 추가하면 대상 서버가 선거에서 지므로, 실제로 물러날 때까지 기다린다.
+	timeoutc := time.After(5 * time.Second)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for l.IsLeader() {
+		select {
+		case <-timeoutc:
+			return fmt.Errorf("timed out waiting for leadership transfer")
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (l *DistributedLog) Stats() map[string]string {
+	return l.raft.Stats()
+}
+
+func (l *DistributedLog) Snapshot() error {
+	err := l.raft.Snapshot().Error()
+	if err == raft.ErrNothingNewToSnapshot {
+		// 마지막 스냅숏이 이미 최신 상태다.
+		return nil
+	}
+	return err
+}
+
+// raft.ErrNotLeader 를 클라이언트가 리더를 찾아갈 수 있는 에러로 바꾼다.
+func (l *DistributedLog) notLeader(err error) error {
+	if err == raft.ErrNotLeader {
+		return api.ErrNotLeader{Leader: string(l.raft.Leader())}
+	}
+	return err
+}
@@ -1,10 +1,12 @@
 package log_test
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,48 +15,13 @@ import (
 	"github.com/jhkim988/proglog/internal/log"
 	"github.com/stretchr/testify/require"
 	"github.com/travisjeffery/go-dynaport"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/trace"
 )
 
 func TestMultipleNodes(t *testing.T) {
-	var logs []*log.DistributedLog
 	nodeCount := 3
-	ports := dynaport.Get(nodeCount)
-
-	for i := 0; i < nodeCount; i++ {
-		dataDir, err := os.MkdirTemp("", "distributed-log-test")
-		require.NoError(t, err)
-
-		defer func(dir string) {
-			_ = os.RemoveAll(dir)
-		}(dataDir)
-
-		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", ports[i]))
-		require.NoError(t, err)
-
-		config := log.Config{}
-		config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
-		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
-		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
-		config.Raft.ElectionTimeout = 50 * time.Millisecond
-		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
-		config.Raft.CommitTimeout = 5 * time.Millisecond
-
-		if i == 0 {
-			config.Raft.Bootstrap = true
-		}
-
-		l, err := log.NewDistributedLog(dataDir, config)
-		require.NoError(t, err)
-
-		if i != 0 {
-			err = logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String())
-			require.NoError(t, err)
-		} else {
-			err = l.WaitForLeader(3 * time.Second)
-			require.NoError(t, err)
-		}
-		logs = append(logs, l)
-	}
+	logs := setupNodes(t, nodeCount)
 
 	records := []*api.Record{
 		{Value: []byte("first")},
@@ -120,3 +87,288 @@ func TestMultipleNodes(t *testing.T) {
 	require.Equal(t, []byte("third"), record.Value)
 	require.Equal(t, off, record.Offset)
 }
+
+func TestAdmin(t *testing.T) {
+	logs := setupNodes(t, 3)
+
+	// 리더가 아닌 노드는 설정을 바꿀 수 없다.
+	err := logs[1].RemoveServer("2")
+	require.IsType(t, api.ErrNotLeader{}, err)
+
+	// 1번 노드로 리더십을 넘긴다.
+	events, cancel := logs[2].WatchCluster()
+	defer cancel()
+	servers, err := logs[0].GetServers()
+	require.NoError(t, err)
+	err = logs[0].TransferLeadership(servers[1].Id, servers[1].RpcAddr)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		servers, err := logs[1].GetServers()
+		return err == nil && servers[1].IsLeader
+	}, 3*time.Second, 50*time.Millisecond)
+
+	// 다른 노드에서도 리더가 바뀐 것을 이벤트로 받는다.
+	require.Eventually(t, func() bool {
+		select {
+		case event := <-events:
+			return event.Type == api.ClusterEvent_LEADER_CHANGED &&
+				event.Server.GetId() == "1"
+		default:
+			return false
+		}
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 새 리더에서 이전 리더를 제거한다.
+	addr := servers[0].RpcAddr
+	err = logs[1].RemoveServer("0")
+	require.NoError(t, err)
+	servers, err = logs[1].GetServers()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(servers))
+
+	// 제거한 노드를 투표하지 않는 서버로 다시 추가한다.
+	err = logs[1].AddServer("0", addr, false)
+	require.NoError(t, err)
+	servers, err = logs[1].GetServers()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(servers))
+
+	stats := logs[1].Stats()
+	require.Equal(t, "Leader", stats["state"])
+	require.NoError(t, logs[1].Snapshot())
+}
+
+func TestIdempotentAppend(t *testing.T) {
+	logs := setupNodes(t, 1)
+	record := &api.Record{Value: []byte("once")}
+
+	first, err := logs[0].AppendIdempotent(record, "producer", 1)
+	require.NoError(t, err)
+	// 같은 요청을 다시 보내면 처음 추가한 오프셋을 돌려준다.
+	again, err := logs[0].AppendIdempotent(record, "producer", 1)
+	require.NoError(t, err)
+	require.Equal(t, first, again)
+
+	next, err := logs[0].AppendIdempotent(record, "producer", 2)
+	require.NoError(t, err)
+	require.Equal(t, first+1, next)
+
+	_, err = logs[0].AppendIdempotent(record, "producer", 4)
+	require.IsType(t, api.ErrOutOfOrderSequence{}, err)
+
+	_, err = logs[0].Read(next + 1)
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+}
+
+func TestTransactions(t *testing.T) {
+	logs := setupNodes(t, 2)
+	ctx := context.Background()
+
+	txnID, err := logs[0].BeginTxn(ctx)
+	require.NoError(t, err)
+	first, err := logs[0].AppendTxn(ctx, txnID, &api.Record{Value: []byte("txn-1")})
+	require.NoError(t, err)
+	_, err = logs[0].AppendTxn(ctx, txnID, &api.Record{Value: []byte("txn-2")})
+	require.NoError(t, err)
+	plain, err := logs[0].Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+
+	// 트랜잭션이 열려 있으면 첫 레코드부터 읽을 수 없다.
+	_, err = logs[0].ReadCommitted(first)
+	require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+	record, err := logs[0].Read(first)
+	require.NoError(t, err)
+	require.Equal(t, txnID, record.TxnId)
+
+	commit, err := logs[0].CommitTxn(ctx, txnID)
+	require.NoError(t, err)
+	_, err = logs[0].AppendTxn(ctx, txnID, &api.Record{Value: []byte("late")})
+	require.IsType(t, api.ErrTxnNotOpen{}, err)
+
+	// 중단한 트랜잭션의 레코드와 표시 레코드는 건너뛴다.
+	aborted, err := logs[0].BeginTxn(ctx)
+	require.NoError(t, err)
+	_, err = logs[0].AppendTxn(ctx, aborted, &api.Record{Value: []byte("aborted")})
+	require.NoError(t, err)
+	_, err = logs[0].AbortTxn(ctx, aborted)
+	require.NoError(t, err)
+	last, err := logs[0].Append(&api.Record{Value: []byte("last")})
+	require.NoError(t, err)
+
+	// 팔로워도 같은 트랜잭션 상태를 가진다.
+	for _, l := range logs {
+		require.Eventually(t, func() bool {
+			_, err := l.Read(last)
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+
+		var got []string
+		for offset := first; ; {
+			record, err := l.ReadCommitted(offset)
+			if err != nil {
+				require.IsType(t, api.ErrOffsetOutOfRange{}, err)
+				break
+			}
+			got = append(got, string(record.Value))
+			offset = record.Offset + 1
+		}
+		require.Equal(t, []string{"txn-1", "txn-2", "plain", "last"}, got)
+
+		record, err := l.Read(commit)
+		require.NoError(t, err)
+		require.Equal(t, api.Record_COMMIT, record.Marker)
+	}
+	require.Equal(t, first+2, plain)
+}
+
+func TestTxnTimeout(t *testing.T) {
+	logs := setupNodes(t, 2)
+	ctx := context.Background()
+
+	txnID, err := logs[0].BeginTxn(ctx)
+	require.NoError(t, err)
+	first, err := logs[0].AppendTxn(ctx, txnID, &api.Record{Value: []byte("abandoned")})
+	require.NoError(t, err)
+	plain, err := logs[0].Append(&api.Record{Value: []byte("plain")})
+	require.NoError(t, err)
+
+	// 리더가 끝내지 않은 트랜잭션을 중단하면 모든 노드에서 그 뒤를 읽을 수 있다.
+	for _, l := range logs {
+		require.Eventually(t, func() bool {
+			record, err := l.ReadCommitted(first)
+			return err == nil && record.Offset == plain
+		}, 5*time.Second, 50*time.Millisecond)
+	}
+	_, err = logs[0].AppendTxn(ctx, txnID, &api.Record{Value: []byte("late")})
+	require.IsType(t, api.ErrTxnNotOpen{}, err)
+	record, err := logs[0].Read(plain + 1)
+	require.NoError(t, err)
+	require.Equal(t, api.Record_ABORT, record.Marker)
+}
+
+func TestMetricsCountRecordsOnce(t *testing.T) {
+	logs := setupNodes(t, 1)
+	require.NoError(t, view.Register(log.Views...))
+	t.Cleanup(func() { view.Unregister(log.Views...) })
+
+	// raft 로그 저장소에 쓰고 읽는 것은 세지 않고, FSM 이 추가한 레코드만 센다.
+	off, err := logs[0].Append(&api.Record{Value: []byte("counted")})
+	require.NoError(t, err)
+	_, err = logs[0].Read(off)
+	require.NoError(t, err)
+
+	for _, name := range []string{"proglog/log/appends", "proglog/log/reads"} {
+		rows, err := view.RetrieveData(name)
+		require.NoError(t, err)
+		require.Len(t, rows, 1)
+		require.Equal(t, int64(1), rows[0].Data.(*view.CountData).Value, name)
+	}
+}
+
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*trace.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(sd *trace.SpanData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, sd)
+}
+
+// name 인 span 을 span 을 기록한 노드별로 리턴한다.
+func (r *spanRecorder) byNode(name string) map[string]*trace.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	spans := make(map[string]*trace.SpanData)
+	for _, sd := range r.spans {
+		if sd.Name == name {
+			spans[sd.Attributes["proglog.node"].(string)] = sd
+		}
+	}
+	return spans
+}
+
+func TestTracePropagation(t *testing.T) {
+	logs := setupNodes(t, 2)
+
+	recorder := &spanRecorder{}
+	trace.RegisterExporter(recorder)
+	t.Cleanup(func() { trace.UnregisterExporter(recorder) })
+
+	ctx, root := trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.AlwaysSample()))
+	_, err := logs[0].AppendContext(ctx, &api.Record{Value: []byte("traced")}, "", 0)
+	require.NoError(t, err)
+	root.End()
+
+	// 팔로워는 비동기로 적용하므로 두 노드의 FSM span 이 모두 끝날 때까지 기다린다.
+	require.Eventually(t, func() bool {
+		return len(recorder.byNode("proglog.fsm.Apply")) == 2
+	}, 3*time.Second, 50*time.Millisecond)
+
+	applies := recorder.byNode("proglog.DistributedLog.apply")
+	require.Len(t, applies, 1)
+	apply := applies["0"]
+	require.Equal(t, root.SpanContext().TraceID, apply.TraceID)
+	require.Equal(t, root.SpanContext().SpanID, apply.ParentSpanID)
+
+	for _, sd := range recorder.byNode("proglog.fsm.Apply") {
+		require.Equal(t, apply.TraceID, sd.TraceID)
+		require.Equal(t, apply.SpanID, sd.ParentSpanID)
+		require.True(t, sd.HasRemoteParent)
+	}
+
+	// 기록하지 않는 요청은 Extensions 를 남기지 않으므로 FSM span 도 없다.
+	before := len(recorder.byNode("proglog.fsm.Apply"))
+	ctx, root = trace.StartSpan(context.Background(), "test", trace.WithSampler(trace.NeverSample()))
+	_, err = logs[0].AppendContext(ctx, &api.Record{Value: []byte("untraced")}, "", 0)
+	require.NoError(t, err)
+	root.End()
+	time.Sleep(100 * time.Millisecond)
+	require.Len(t, recorder.byNode("proglog.fsm.Apply"), before)
+}
+
+func setupNodes(t *testing.T, nodeCount int) []*log.DistributedLog {
+	t.Helper()
+
+	var logs []*log.DistributedLog
+	ports := dynaport.Get(nodeCount)
+
+	for i := 0; i < nodeCount; i++ {
+		dataDir, err := os.MkdirTemp("", "distributed-log-test")
+		require.NoError(t, err)
+
+		t.Cleanup(func() {
+			_ = os.RemoveAll(dataDir)
+		})
+
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", ports[i]))
+		require.NoError(t, err)
+
+		config := log.Config{}
+		config.Raft.StreamLayer = log.NewStreamLayer(ln, nil, nil)
+		config.Raft.LocalID = raft.ServerID(fmt.Sprintf("%d", i))
+		config.Raft.HeartbeatTimeout = 50 * time.Millisecond
+		config.Raft.ElectionTimeout = 50 * time.Millisecond
+		config.Raft.LeaderLeaseTimeout = 50 * time.Millisecond
+		config.Raft.CommitTimeout = 5 * time.Millisecond
+		config.Txn.Timeout = time.Second
+
+		if i == 0 {
+			config.Raft.Bootstrap = true
+		}
+
+		l, err := log.NewDistributedLog(dataDir, config)
+		require.NoError(t, err)
+
+		if i != 0 {
+			err = logs[0].Join(fmt.Sprintf("%d", i), ln.Addr().String())
+			require.NoError(t, err)
+		} else {
+			err = l.WaitForLeader(3 * time.Second)
+			require.NoError(t, err)
+		}
+		logs = append(logs, l)
+	}
+	return logs
+}
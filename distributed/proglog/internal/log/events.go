@@ -0,0 +1,70 @@
+package log
+
+import (
+	"sync"
+
+	api "github.com/jhkim988/proglog/api/v1"
+)
+
+// 구독자 한 명이 쌓아둘 수 있는 이벤트 수
+const eventBufferSize = 64
+
+/*
+프로세스 내부 이벤트 버스
+raft observer 가 보내는 이벤트를 여러 구독자(WatchCluster 스트림)에게 나눠준다.
+느린 구독자 때문에 raft 가 멈추면 안 되므로, 버퍼가 가득 찬 구독자에게는 이벤트를 버린다.
+*/
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan *api.ClusterEvent]struct{}
+	closed bool
+}
+
+// 이벤트를 받을 채널과 구독을 취소하는 함수를 리턴한다.
+func (b *eventBus) subscribe() (<-chan *api.ClusterEvent, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *api.ClusterEvent, eventBufferSize)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if b.subs == nil {
+		b.subs = make(map[chan *api.ClusterEvent]struct{})
+	}
+	b.subs[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBus) publish(event *api.ClusterEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// 모든 구독 채널을 닫는다.
+func (b *eventBus) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
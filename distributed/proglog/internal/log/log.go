@@ -11,6 +11,7 @@ import (
 	"sync"
 
 	api "github.com/jhkim988/proglog/api/v1"
+	"google.golang.org/protobuf/proto"
 )
 
 type Log struct {
@@ -20,6 +21,10 @@ type Log struct {
 
 	activeSegment *segment
 	segments []*segment
+
+	changed chan struct{} // 다음 Append 때 닫힌다.
+	// raft 로그 저장소는 레코드 지표에 세지 않는다. (FSM 이 같은 레코드를 다시 추가한다.)
+	skipMetrics bool
 }
 
 func NewLog(dir string, c Config) (*Log, error) {
@@ -32,6 +37,7 @@ func NewLog(dir string, c Config) (*Log, error) {
 	l := &Log{
 		Dir: dir,
 		Config: c,
+		changed: make(chan struct{}),
 	}
 	return l, l.setup()
 }
@@ -87,6 +93,10 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
+	if !l.skipMetrics {
+		recordBytes(appendBytes, proto.Size(record))
+	}
+	l.notify()
 	
 	if l.activeSegment.IsMaxed() {
 		err = l.newSegment(off + 1)
@@ -95,6 +105,20 @@ func (l *Log) Append(record *api.Record) (uint64, error) {
 	return off, err
 }
 
+// 레코드가 추가되거나 로그가 초기화되면 닫히는 채널을 리턴한다.
+// 읽기 전에 채널을 받아두고, 읽을 레코드가 없으면 채널이 닫힐 때까지 기다리면 추가된 레코드를 놓치지 않는다.
+func (l *Log) Changed() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.changed
+}
+
+// 기다리는 쪽을 모두 깨운다. l.mu 를 잡고 호출한다.
+func (l *Log) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
 func (l *Log) Read(off uint64) (*api.Record, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -111,7 +135,14 @@ func (l *Log) Read(off uint64) (*api.Record, error) {
 		return nil, api.ErrOffsetOutOfRange{Offset: off}
 	}
 
-	return s.Read(off)
+	record, err := s.Read(off)
+	if err != nil {
+		return nil, err
+	}
+	if !l.skipMetrics {
+		recordBytes(readBytes, proto.Size(record))
+	}
+	return record, nil
 }
 
 func (l * Log) Close() error {
@@ -139,6 +170,14 @@ func (l *Log) Reset() error {
 	if err := l.Remove(); err != nil {
 		return err
 	}
+	// Remove 가 디렉터리까지 지우므로 다시 만들고, 닫은 세그먼트를 버린다.
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.segments = nil
+	l.notify()
+	l.mu.Unlock()
 	return l.setup()
 }
 
@@ -161,6 +200,18 @@ func (l *Log) HighestOffset() (uint64, error) {
 	return off-1, nil
 }
 
+// 세그먼트 수와 store, index 에 쓴 바이트 수
+func (l *Log) Usage() Usage {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	usage := Usage{Segments: len(l.segments)}
+	for _, s := range l.segments {
+		usage.Bytes += s.store.size + s.index.size
+	}
+	return usage
+}
+
 // 특정 시점보다 오래된 세그먼트를 지운다.
 func (l* Log) Truncate(lowest uint64) error {
 	l.mu.Lock()
@@ -182,13 +233,14 @@ func (l* Log) Truncate(lowest uint64) error {
 	return nil
 }
 
+// store 를 임베드하지 않는다. *os.File 의 WriteTo 가 드러나면 io.Copy 가 Read 대신 파일의 현재 위치부터 읽는다.
 type originReader struct {
-	*store
+	store *store
 	off int64
 }
 
 func (o *originReader) Read(p []byte) (int, error){
-	n, err := o.ReadAt(p, o.off)
+	n, err := o.store.ReadAt(p, o.off)
 	o.off += int64(n) // 읽은 위치를 기록해두고, 다음 Read 호출에 이어서 읽을 수 있도록 한다.
 	return n, err
 }
@@ -17,6 +17,8 @@ func TestLog(t *testing.T) {
 		"init with existing segments":       testInitExisting,
 		"reader":                            testReader,
 		"truncate":                          testTruncate,
+		"changed closes on append":          testChanged,
+		"usage counts segments and bytes":   testUsage,
 	} {
 		t.Run(senario, func(t *testing.T) {
 			dir, err := ioutil.TempDir("", "store-test")
@@ -45,6 +47,39 @@ func testAppendRead(t *testing.T, log *Log) {
 	require.Equal(t, append.Value, read.Value)
 }
 
+func testUsage(t *testing.T, log *Log) {
+	require.Equal(t, Usage{Segments: 1}, log.Usage())
+
+	var want uint64
+	for i := 0; i < 3; i++ {
+		off, err := log.Append(&api.Record{ Value: []byte("hello world")})
+		require.NoError(t, err)
+		read, err := log.Read(off)
+		require.NoError(t, err)
+		want += lenWidth + uint64(proto.Size(read)) + entWidth
+	}
+	// MaxStoreBytes 가 32 이므로 두 번째 레코드 뒤에 새 세그먼트를 만든다.
+	require.Equal(t, Usage{Segments: 2, Bytes: want}, log.Usage())
+}
+
+func testChanged(t *testing.T, log *Log) {
+	changed := log.Changed()
+	select {
+	case <-changed:
+		t.Fatal("changed before append")
+	default:
+	}
+
+	_, err := log.Append(&api.Record{Value: []byte("hello world")})
+	require.NoError(t, err)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("not changed after append")
+	}
+	require.NotEqual(t, changed, log.Changed())
+}
+
 func testOutOfRangeErr(t *testing.T, log *Log) {
 	read, err := log.Read(1)
 	require.Nil(t, read)
@@ -0,0 +1,91 @@
+package log
+
+import (
+	"context"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	appendBytes = stats.Int64(
+		"proglog/log/append_bytes",
+		"Size of a record appended to the log",
+		stats.UnitBytes,
+	)
+	readBytes = stats.Int64(
+		"proglog/log/read_bytes",
+		"Size of a record read from the log",
+		stats.UnitBytes,
+	)
+	raftCommitLatency = stats.Float64(
+		"proglog/raft/commit_latency",
+		"Time from raft.Apply until the entry is committed and applied on the leader",
+		stats.UnitMilliseconds,
+	)
+	fsmApplyLatency = stats.Float64(
+		"proglog/raft/fsm_apply_latency",
+		"Time the FSM takes to apply one raft log entry",
+		stats.UnitMilliseconds,
+	)
+
+	bytesDistribution   = view.Distribution(64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304)
+	latencyDistribution = view.Distribution(0.1, 0.25, 0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000)
+
+	// 레코드를 추가하고 읽은 횟수와 크기, raft 커밋과 FSM 적용 지연 시간
+	// 프로세스의 모든 Log 와 DistributedLog 를 합쳐서 센다.
+	Views = []*view.View{
+		{
+			Name:        "proglog/log/appends",
+			Measure:     appendBytes,
+			Description: "Number of records appended to the log",
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "proglog/log/append_bytes",
+			Measure:     appendBytes,
+			Description: "Distribution of appended record sizes",
+			Aggregation: bytesDistribution,
+		},
+		{
+			Name:        "proglog/log/reads",
+			Measure:     readBytes,
+			Description: "Number of records read from the log",
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "proglog/log/read_bytes",
+			Measure:     readBytes,
+			Description: "Distribution of read record sizes",
+			Aggregation: bytesDistribution,
+		},
+		{
+			Name:        "proglog/raft/commit_latency",
+			Measure:     raftCommitLatency,
+			Description: "Distribution of raft commit latency on the leader",
+			Aggregation: latencyDistribution,
+		},
+		{
+			Name:        "proglog/raft/fsm_apply_latency",
+			Measure:     fsmApplyLatency,
+			Description: "Distribution of FSM apply latency",
+			Aggregation: latencyDistribution,
+		},
+	}
+)
+
+func recordBytes(measure *stats.Int64Measure, n int) {
+	stats.Record(context.Background(), measure.M(int64(n)))
+}
+
+func recordLatency(measure *stats.Float64Measure, start time.Time) {
+	stats.Record(context.Background(), measure.M(float64(time.Since(start))/float64(time.Millisecond)))
+}
+
+// 저장 공간 사용량
+type Usage struct {
+	Segments int
+	// store 와 index 에 쓴 바이트 수, index 파일은 열려 있는 동안 최대 크기로 늘려두므로 파일 크기와 다르다.
+	Bytes uint64
+}
@@ -0,0 +1,96 @@
+package log
+
+import (
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+)
+
+const (
+	// 프로듀서마다 중복을 확인할 수 있는 최근 요청 수
+	producerWindow = 64
+	// 마지막 요청 후 이 시간이 지난 프로듀서는 잊는다.
+	defaultProducerIdleTimeout = 24 * time.Hour
+	// 잊은 프로듀서를 테이블에서 지우는 간격
+	producerPruneInterval = time.Minute
+)
+
+/*
+멱등 프로듀서 테이블
+프로듀서마다 마지막으로 추가한 sequence 와 최근 producerWindow 개의 오프셋을 기억한다.
+클라이언트가 타임아웃 후 같은 요청을 다시 보내면, 레코드를 또 추가하지 않고 처음 추가한 오프셋을 돌려준다.
+FSM 에서만 사용하므로 락이 없다. 스냅샷에 함께 저장한다.
+
+클라이언트마다, 실패한 배치마다 새 프로듀서 ID 를 쓰므로 오래 요청이 없는 프로듀서는 잊는다.
+시각은 리더가 요청을 raft 로그에 추가한 시각이므로 모든 노드가 같은 요청에서 같은 프로듀서를 잊는다.
+cutoff 보다 먼저 마지막 요청을 보낸 프로듀서는 테이블에서 지우기 전에도 처음 보는 프로듀서로 다룬다.
+시각을 모르면 (cutoff 나 LastAppend 가 zero) 잊지 않는다.
+*/
+type producerTable map[string]*producerState
+
+type producerState struct {
+	LastSequence uint64   `json:"last_sequence"`
+	Offsets      []uint64 `json:"offsets"` // 마지막 sequence 까지 최근에 추가한 순서대로
+	// 리더가 마지막 요청을 raft 로그에 추가한 시각
+	LastAppend time.Time `json:"last_append"`
+}
+
+func (p *producerState) idle(cutoff time.Time) bool {
+	return !p.LastAppend.IsZero() && p.LastAppend.Before(cutoff)
+}
+
+// 이미 추가한 요청이면 그 오프셋과 true 를 리턴한다.
+// 처음 보는 프로듀서는 어느 sequence 로든 시작할 수 있다.
+func (t producerTable) check(producerID string, sequence uint64, cutoff time.Time) (uint64, bool, error) {
+	p, ok := t[producerID]
+	if !ok || p.idle(cutoff) || sequence == p.LastSequence+1 {
+		return 0, false, nil
+	}
+	if sequence <= p.LastSequence {
+		back := p.LastSequence - sequence
+		if back < uint64(len(p.Offsets)) {
+			return p.Offsets[len(p.Offsets)-1-int(back)], true, nil
+		}
+	}
+	return 0, false, api.ErrOutOfOrderSequence{
+		ProducerId: producerID,
+		Expected:   p.LastSequence + 1,
+		Sequence:   sequence,
+	}
+}
+
+func (t producerTable) record(producerID string, sequence, offset uint64, appended, cutoff time.Time) {
+	p, ok := t[producerID]
+	if !ok || p.idle(cutoff) {
+		p = &producerState{}
+		t[producerID] = p
+	}
+	p.LastSequence = sequence
+	p.LastAppend = appended
+	p.Offsets = append(p.Offsets, offset)
+	if len(p.Offsets) > producerWindow {
+		p.Offsets = append([]uint64(nil), p.Offsets[len(p.Offsets)-producerWindow:]...)
+	}
+}
+
+// cutoff 보다 먼저 마지막 요청을 보낸 프로듀서를 지운다.
+func (t producerTable) prune(cutoff time.Time) {
+	for id, p := range t {
+		if p.idle(cutoff) {
+			delete(t, id)
+		}
+	}
+}
+
+// 스냅샷은 다른 고루틴에서 저장하므로 복사본을 넘긴다.
+func (t producerTable) clone() producerTable {
+	c := make(producerTable, len(t))
+	for id, p := range t {
+		c[id] = &producerState{
+			LastSequence: p.LastSequence,
+			Offsets:      append([]uint64(nil), p.Offsets...),
+			LastAppend:   p.LastAppend,
+		}
+	}
+	return c
+}
@@ -0,0 +1,170 @@
+package log
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestProducerTable(t *testing.T) {
+	table := producerTable{}
+
+	// 처음 보는 프로듀서는 어느 sequence 로든 시작할 수 있다.
+	_, dup, err := table.check("p", 5, time.Time{})
+	require.NoError(t, err)
+	require.False(t, dup)
+	for seq := uint64(5); seq < 5+producerWindow+10; seq++ {
+		table.record("p", seq, seq*10, time.Time{}, time.Time{})
+	}
+	last := uint64(5 + producerWindow + 9)
+
+	offset, dup, err := table.check("p", last, time.Time{})
+	require.NoError(t, err)
+	require.True(t, dup)
+	require.Equal(t, last*10, offset)
+
+	offset, dup, err = table.check("p", last-producerWindow+1, time.Time{})
+	require.NoError(t, err)
+	require.True(t, dup)
+	require.Equal(t, (last-producerWindow+1)*10, offset)
+
+	_, dup, err = table.check("p", last+1, time.Time{})
+	require.NoError(t, err)
+	require.False(t, dup)
+
+	// 건너뛴 sequence 와 기억하지 못하는 오래된 sequence 는 거절한다.
+	for _, seq := range []uint64{last + 2, last - producerWindow} {
+		_, _, err = table.check("p", seq, time.Time{})
+		require.Equal(t, api.ErrOutOfOrderSequence{
+			ProducerId: "p",
+			Expected:   last + 1,
+			Sequence:   seq,
+		}, err)
+	}
+}
+
+func TestProducerTableForgetsIdleProducers(t *testing.T) {
+	table := producerTable{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	table.record("old", 1, 0, start, time.Time{})
+	table.record("new", 1, 1, start.Add(time.Hour), time.Time{})
+
+	// cutoff 보다 먼저 요청한 프로듀서는 지우기 전에도 처음 보는 프로듀서로 다룬다.
+	cutoff := start.Add(30 * time.Minute)
+	_, dup, err := table.check("old", 1, cutoff)
+	require.NoError(t, err)
+	require.False(t, dup)
+	_, dup, err = table.check("new", 1, cutoff)
+	require.NoError(t, err)
+	require.True(t, dup)
+
+	table.prune(cutoff)
+	require.NotContains(t, table, "old")
+	require.Contains(t, table, "new")
+
+	// 시각을 모르는 프로듀서는 잊지 않는다.
+	table.record("unknown", 1, 2, time.Time{}, time.Time{})
+	table.prune(start.Add(48 * time.Hour))
+	require.Contains(t, table, "unknown")
+}
+
+func TestFSMForgetsIdleProducers(t *testing.T) {
+	f := setupFSM(t)
+	f.producerIdle = time.Hour
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for seq := uint64(1); seq <= 3; seq++ {
+		f.applyAppend(produceRequest(t, "p", seq), start)
+	}
+
+	// 다른 프로듀서의 요청을 적용하면서 오래 요청이 없는 프로듀서를 지운다.
+	f.applyAppend(produceRequest(t, "q", 1), start.Add(2*time.Hour))
+	require.NotContains(t, f.producers, "p")
+
+	// 잊은 프로듀서는 어느 sequence 로든 다시 시작할 수 있다.
+	res := f.applyAppend(produceRequest(t, "p", 10), start.Add(2*time.Hour))
+	require.Equal(t, uint64(4), res.(*api.ProduceResponse).Offset)
+
+	// 스냅샷에도 지운 프로듀서는 남지 않는다.
+	f.applyAppend(produceRequest(t, "q", 2), start.Add(4*time.Hour))
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	require.Len(t, snap.(*snapshot).header.Producers, 1)
+}
+
+func TestSnapshotRestoresProducers(t *testing.T) {
+	f := setupFSM(t)
+	for seq := uint64(1); seq <= 3; seq++ {
+		res := f.applyAppend(produceRequest(t, "p", seq), time.Time{})
+		require.Equal(t, seq-1, res.(*api.ProduceResponse).Offset)
+	}
+
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+
+	restored := setupFSM(t)
+	require.NoError(t, restored.Restore(io.NopCloser(&sink.buf)))
+
+	// 복원한 FSM 도 중복 요청을 걸러낸다.
+	res := restored.applyAppend(produceRequest(t, "p", 2), time.Time{})
+	require.Equal(t, uint64(1), res.(*api.ProduceResponse).Offset)
+	res = restored.applyAppend(produceRequest(t, "p", 4), time.Time{})
+	require.Equal(t, uint64(3), res.(*api.ProduceResponse).Offset)
+}
+
+func TestRestoreLegacySnapshot(t *testing.T) {
+	f := setupFSM(t)
+	for seq := uint64(1); seq <= 2; seq++ {
+		f.applyAppend(produceRequest(t, "p", seq), time.Time{})
+	}
+
+	// 헤더가 없는 이전 형식은 로그 레코드만 담고 있다.
+	var buf bytes.Buffer
+	_, err := io.Copy(&buf, f.log.Reader())
+	require.NoError(t, err)
+
+	restored := setupFSM(t)
+	require.NoError(t, restored.Restore(io.NopCloser(&buf)))
+	record, err := restored.log.Read(1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), record.Value)
+	require.Empty(t, restored.producers)
+}
+
+func setupFSM(t *testing.T) *fsm {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "fsm-test")
+	require.NoError(t, err)
+	log, err := NewLog(dir, Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { log.Remove() })
+	return &fsm{log: log, producers: producerTable{}, txns: newTxnTable()}
+}
+
+func produceRequest(t *testing.T, producerID string, sequence uint64) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&api.ProduceRequest{
+		Record:     &api.Record{Value: []byte("hello")},
+		ProducerId: producerID,
+		Sequence:   sequence,
+	})
+	require.NoError(t, err)
+	return b
+}
+
+// Mock
+type snapshotSink struct {
+	buf bytes.Buffer
+}
+
+func (s *snapshotSink) Write(p []byte) (int, error) { return s.buf.Write(p) }
+func (s *snapshotSink) Close() error                { return nil }
+func (s *snapshotSink) ID() string                  { return "test" }
+func (s *snapshotSink) Cancel() error               { return nil }
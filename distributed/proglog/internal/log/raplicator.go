@@ -71,7 +71,9 @@ func (r *Replicator) replicate(addr string, leave chan struct{}) {
 				r.logError(err, "failed to receive", addr)
 				return
 			}
-			records <- recv.Record
+			for _, record := range recv.Records {
+				records <- record
+			}
 		}
 	}()
 
@@ -0,0 +1,73 @@
+package log
+
+import (
+	"sync/atomic"
+
+	"github.com/hashicorp/raft"
+)
+
+/*
+리더에게 받은 커밋 인덱스를 기억하는 raft transport
+raft 는 리더의 커밋 인덱스를 이 노드가 저장한 로그까지만 반영하므로, 리더보다 한참 뒤처진 팔로워도 커밋 인덱스로는 뒤처진 것을 알 수 없다.
+AppendEntries 요청(하트비트 포함)을 raft 에 넘기기 전에 리더의 커밋 인덱스를 기록한다.
+*/
+type commitTrackingTransport struct {
+	*raft.NetworkTransport
+	consumer     chan raft.RPC
+	leaderCommit atomic.Uint64
+}
+
+// shutdown 이 닫히면 요청을 넘기지 않는다.
+func newCommitTrackingTransport(transport *raft.NetworkTransport, shutdown <-chan struct{}) *commitTrackingTransport {
+	t := &commitTrackingTransport{
+		NetworkTransport: transport,
+		consumer:         make(chan raft.RPC),
+	}
+	go t.relay(shutdown)
+	return t
+}
+
+func (t *commitTrackingTransport) Consumer() <-chan raft.RPC {
+	return t.consumer
+}
+
+func (t *commitTrackingTransport) SetHeartbeatHandler(cb func(rpc raft.RPC)) {
+	if cb == nil {
+		t.NetworkTransport.SetHeartbeatHandler(nil)
+		return
+	}
+	t.NetworkTransport.SetHeartbeatHandler(func(rpc raft.RPC) {
+		t.observe(rpc)
+		cb(rpc)
+	})
+}
+
+func (t *commitTrackingTransport) relay(shutdown <-chan struct{}) {
+	for {
+		select {
+		case rpc := <-t.NetworkTransport.Consumer():
+			t.observe(rpc)
+			select {
+			case t.consumer <- rpc:
+			case <-shutdown:
+				return
+			}
+		case <-shutdown:
+			return
+		}
+	}
+}
+
+// 커밋 인덱스는 줄어들지 않으므로 가장 큰 값을 남긴다.
+func (t *commitTrackingTransport) observe(rpc raft.RPC) {
+	req, ok := rpc.Command.(*raft.AppendEntriesRequest)
+	if !ok {
+		return
+	}
+	for {
+		commit := t.leaderCommit.Load()
+		if req.LeaderCommitIndex <= commit || t.leaderCommit.CompareAndSwap(commit, req.LeaderCommitIndex) {
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package log
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+)
+
+/*
+트랜잭션 테이블
+트랜잭션의 레코드는 AppendTxn 때 바로 로그에 추가하고, 끝낼 때 커밋/중단 표시 레코드를 추가한다.
+read_committed 로 읽는 컨슈머를 위해 열려 있는 트랜잭션과 중단된 트랜잭션을 기억한다.
+커밋한 트랜잭션은 열려 있지도 중단되지도 않은 트랜잭션이므로 따로 기억하지 않는다.
+중단된 트랜잭션은 중단 표시 레코드의 오프셋과 함께 기억하고, 그 레코드까지 로그에서 지워지면 잊는다.
+FSM 이 쓰고 컨슈머 요청이 읽으므로 락으로 보호한다. 스냅샷에 함께 저장한다.
+*/
+type txnTable struct {
+	mu      sync.RWMutex
+	open    map[uint64]*openTxn
+	aborted map[uint64]uint64 // 트랜잭션 ID -> 중단 표시 레코드의 오프셋
+}
+
+type openTxn struct {
+	HasRecords  bool   `json:"has_records"`
+	FirstOffset uint64 `json:"first_offset"`
+	// 리더가 BeginTxn 요청을 raft 로그에 추가한 시각, 리더가 타임아웃을 잴 때 쓴다.
+	Started time.Time `json:"started"`
+}
+
+// 스냅샷 헤더에 저장하는 형식
+type txnState struct {
+	Open    map[uint64]*openTxn `json:"open"`
+	Aborted map[uint64]uint64   `json:"aborted"`
+}
+
+func newTxnTable() *txnTable {
+	return &txnTable{
+		open:    make(map[uint64]*openTxn),
+		aborted: make(map[uint64]uint64),
+	}
+}
+
+func (t *txnTable) begin(txnID uint64, started time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.open[txnID] = &openTxn{Started: started}
+}
+
+func (t *txnTable) checkOpen(txnID uint64) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if _, ok := t.open[txnID]; !ok {
+		return api.ErrTxnNotOpen{TxnId: txnID}
+	}
+	return nil
+}
+
+func (t *txnTable) append(txnID, offset uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if txn, ok := t.open[txnID]; ok && !txn.HasRecords {
+		txn.HasRecords = true
+		txn.FirstOffset = offset
+	}
+}
+
+// marker 는 트랜잭션을 끝내는 표시 레코드의 오프셋
+func (t *txnTable) end(txnID uint64, commit bool, marker uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.open, txnID)
+	if !commit {
+		t.aborted[txnID] = marker
+	}
+}
+
+// 시작한 지 timeout 이 지난 열린 트랜잭션의 ID 를 리턴한다.
+func (t *txnTable) expired(timeout time.Duration, now time.Time) []uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var ids []uint64
+	for id, txn := range t.open {
+		if now.Sub(txn.Started) >= timeout {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+/*
+중단 표시 레코드가 lowest 보다 앞에 있는 트랜잭션을 잊는다.
+트랜잭션의 레코드는 모두 표시 레코드보다 앞에 있으므로 더는 로그에 남아 있지 않다.
+*/
+func (t *txnTable) prune(lowest uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, marker := range t.aborted {
+		if marker < lowest {
+			delete(t.aborted, id)
+		}
+	}
+}
+
+/*
+read_committed 로 읽을 수 있는지 확인한다.
+열려 있는 트랜잭션의 첫 레코드부터는 읽을 수 없다. (barrier 가 true)
+트랜잭션 표시 레코드와 중단된 트랜잭션의 레코드는 건너뛴다. (visible 이 false)
+*/
+func (t *txnTable) visible(record *api.Record) (visible bool, barrier bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if record.Offset >= t.stableOffset() {
+		return false, true
+	}
+	if record.Marker != api.Record_NONE {
+		return false, false
+	}
+	if _, aborted := t.aborted[record.TxnId]; record.TxnId != 0 && aborted {
+		return false, false
+	}
+	return true, false
+}
+
+// 열려 있는 트랜잭션의 첫 레코드 중 가장 작은 오프셋, t.mu 를 잡고 호출한다.
+func (t *txnTable) stableOffset() uint64 {
+	var stable uint64 = math.MaxUint64
+	for _, txn := range t.open {
+		if txn.HasRecords && txn.FirstOffset < stable {
+			stable = txn.FirstOffset
+		}
+	}
+	return stable
+}
+
+func (t *txnTable) state() txnState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	state := txnState{
+		Open:    make(map[uint64]*openTxn, len(t.open)),
+		Aborted: make(map[uint64]uint64, len(t.aborted)),
+	}
+	for id, txn := range t.open {
+		copied := *txn
+		state.Open[id] = &copied
+	}
+	for id, marker := range t.aborted {
+		state.Aborted[id] = marker
+	}
+	return state
+}
+
+func (t *txnTable) restore(state txnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.open = make(map[uint64]*openTxn, len(state.Open))
+	for id, txn := range state.Open {
+		t.open[id] = txn
+	}
+	t.aborted = make(map[uint64]uint64, len(state.Aborted))
+	for id, marker := range state.Aborted {
+		t.aborted[id] = marker
+	}
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSnapshotRestoresTxns(t *testing.T) {
+	f := setupFSM(t)
+	f.applyBeginTxn(1, time.Time{})
+	f.applyBeginTxn(2, time.Time{})
+	f.applyAppendTxn(appendTxnRequest(t, 1, "open"))
+	f.applyAppendTxn(appendTxnRequest(t, 2, "aborted"))
+	_, err := f.endTxn(2, false)
+	require.NoError(t, err)
+
+	snap, err := f.Snapshot()
+	require.NoError(t, err)
+	sink := &snapshotSink{}
+	require.NoError(t, snap.Persist(sink))
+
+	restored := setupFSM(t)
+	require.NoError(t, restored.Restore(io.NopCloser(&sink.buf)))
+
+	// 열려 있는 트랜잭션의 첫 레코드부터는 읽을 수 없다.
+	for offset := uint64(0); offset < 3; offset++ {
+		record, err := restored.log.Read(offset)
+		require.NoError(t, err)
+		_, barrier := restored.txns.visible(record)
+		require.True(t, barrier)
+	}
+
+	// 커밋하면 중단한 트랜잭션의 레코드와 표시 레코드만 건너뛴다.
+	_, err = restored.endTxn(1, true)
+	require.NoError(t, err)
+	for offset, want := range []bool{true, false, false, false} {
+		record, err := restored.log.Read(uint64(offset))
+		require.NoError(t, err)
+		visible, barrier := restored.txns.visible(record)
+		require.Equal(t, want, visible, "offset %d", offset)
+		require.False(t, barrier)
+	}
+	require.Equal(t, api.ErrTxnNotOpen{TxnId: 2}, restored.txns.checkOpen(2))
+}
+
+func TestLogStoreKeepsAppendedAt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "log-store-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	c := Config{}
+	c.Segment.InitialOffset = 1
+	store, err := newLogStore(dir, c)
+	require.NoError(t, err)
+	defer store.Close()
+
+	// 팔로워나 재시작한 노드도 리더가 로그에 추가한 시각으로 트랜잭션 시작 시각을 잰다.
+	appended := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.StoreLogs([]*raft.Log{
+		{Index: 1, Term: 1, Type: raft.LogCommand, Data: []byte{byte(BeginTxnRequestType)}, AppendedAt: appended},
+	}))
+	var record raft.Log
+	require.NoError(t, store.GetLog(1, &record))
+	require.True(t, appended.Equal(record.AppendedAt))
+
+	f := setupFSM(t)
+	f.Apply(&record)
+	require.True(t, appended.Equal(f.txns.state().Open[1].Started))
+}
+
+func TestTxnTable(t *testing.T) {
+	txns := newTxnTable()
+	start := time.Now()
+	txns.begin(1, start)
+	txns.begin(2, start.Add(time.Second))
+	txns.begin(3, start)
+	txns.end(3, false, 5)
+
+	// 시작한 지 timeout 이 지난 열린 트랜잭션만 리턴한다.
+	require.Equal(t, []uint64{1}, txns.expired(time.Second, start.Add(time.Second)))
+	require.ElementsMatch(t, []uint64{1, 2}, txns.expired(time.Second, start.Add(2*time.Second)))
+
+	// 중단 표시 레코드가 로그에서 지워져야 잊는다.
+	txns.prune(5)
+	require.Contains(t, txns.state().Aborted, uint64(3))
+	txns.prune(6)
+	require.Empty(t, txns.state().Aborted)
+}
+
+func appendTxnRequest(t *testing.T, txnID uint64, value string) []byte {
+	t.Helper()
+	b, err := proto.Marshal(&api.AppendTxnRequest{
+		TxnId:  txnID,
+		Record: &api.Record{Value: []byte(value)},
+	})
+	require.NoError(t, err)
+	return b
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/hashicorp/go-hclog"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+/*
+raft 처럼 hclog.Logger 를 받는 라이브러리의 로그를 zap 로거로 보낸다.
+hclog 의 key-value 인자는 zap 필드가 되고, Named 는 zap 로거 이름에 붙으므로 Config.Levels 가 적용된다.
+수준은 Config 로만 정하므로 SetLevel 은 무시한다.
+*/
+func HCLog(logger *zap.Logger) hclog.Logger {
+	// 호출 위치는 항상 이 파일이므로 남기지 않는다.
+	logger = logger.WithOptions(zap.WithCaller(false))
+	return &hclogAdapter{root: logger, logger: logger}
+}
+
+type hclogAdapter struct {
+	// ResetNamed 는 HCLog 에 넘긴 로거 아래에 이름을 다시 붙인다.
+	root   *zap.Logger
+	logger *zap.Logger
+	name   string
+	args   []interface{}
+}
+
+var _ hclog.Logger = (*hclogAdapter)(nil)
+
+func (a *hclogAdapter) Log(level hclog.Level, msg string, args ...interface{}) {
+	lvl, ok := zapLevel(level)
+	if !ok {
+		return
+	}
+	if ce := a.logger.Check(lvl, msg); ce != nil {
+		ce.Write(fields(args)...)
+	}
+}
+
+func (a *hclogAdapter) Trace(msg string, args ...interface{}) { a.Log(hclog.Trace, msg, args...) }
+func (a *hclogAdapter) Debug(msg string, args ...interface{}) { a.Log(hclog.Debug, msg, args...) }
+func (a *hclogAdapter) Info(msg string, args ...interface{})  { a.Log(hclog.Info, msg, args...) }
+func (a *hclogAdapter) Warn(msg string, args ...interface{})  { a.Log(hclog.Warn, msg, args...) }
+func (a *hclogAdapter) Error(msg string, args ...interface{}) { a.Log(hclog.Error, msg, args...) }
+
+func (a *hclogAdapter) IsTrace() bool { return a.enabled(zapcore.DebugLevel) }
+func (a *hclogAdapter) IsDebug() bool { return a.enabled(zapcore.DebugLevel) }
+func (a *hclogAdapter) IsInfo() bool  { return a.enabled(zapcore.InfoLevel) }
+func (a *hclogAdapter) IsWarn() bool  { return a.enabled(zapcore.WarnLevel) }
+func (a *hclogAdapter) IsError() bool { return a.enabled(zapcore.ErrorLevel) }
+
+// Core().Enabled 는 모든 이름 중 가장 낮은 수준으로 답하므로 이 로거 이름으로 Check 한다.
+func (a *hclogAdapter) enabled(level zapcore.Level) bool {
+	return a.logger.Check(level, "") != nil
+}
+
+func (a *hclogAdapter) ImpliedArgs() []interface{} {
+	return a.args
+}
+
+func (a *hclogAdapter) With(args ...interface{}) hclog.Logger {
+	return &hclogAdapter{
+		root:   a.root,
+		logger: a.logger.With(fields(args)...),
+		name:   a.name,
+		args:   append(append([]interface{}{}, a.args...), args...),
+	}
+}
+
+func (a *hclogAdapter) Name() string {
+	return a.name
+}
+
+func (a *hclogAdapter) Named(name string) hclog.Logger {
+	full := name
+	if a.name != "" {
+		full = a.name + "." + name
+	}
+	return &hclogAdapter{root: a.root, logger: a.logger.Named(name), name: full, args: a.args}
+}
+
+func (a *hclogAdapter) ResetNamed(name string) hclog.Logger {
+	return &hclogAdapter{
+		root:   a.root,
+		logger: a.root.Named(name).With(fields(a.args)...),
+		name:   name,
+		args:   a.args,
+	}
+}
+
+func (a *hclogAdapter) SetLevel(hclog.Level) {}
+
+func (a *hclogAdapter) GetLevel() hclog.Level {
+	switch {
+	case a.IsDebug():
+		return hclog.Debug
+	case a.IsInfo():
+		return hclog.Info
+	case a.IsWarn():
+		return hclog.Warn
+	case a.IsError():
+		return hclog.Error
+	}
+	return hclog.Off
+}
+
+func (a *hclogAdapter) StandardLogger(opts *hclog.StandardLoggerOptions) *log.Logger {
+	return log.New(a.StandardWriter(opts), "", 0)
+}
+
+func (a *hclogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	w := &stdWriter{logger: a.logger, level: zapcore.InfoLevel, infer: true}
+	if opts != nil {
+		w.infer = opts.InferLevels
+		if lvl, ok := zapLevel(opts.ForceLevel); ok && opts.ForceLevel != hclog.NoLevel {
+			w.level = lvl
+			w.infer = false
+		}
+	}
+	return w
+}
+
+func zapLevel(level hclog.Level) (zapcore.Level, bool) {
+	switch level {
+	case hclog.Trace, hclog.Debug:
+		return zapcore.DebugLevel, true
+	case hclog.NoLevel, hclog.Info:
+		return zapcore.InfoLevel, true
+	case hclog.Warn:
+		return zapcore.WarnLevel, true
+	case hclog.Error:
+		return zapcore.ErrorLevel, true
+	}
+	return 0, false
+}
+
+// hclog 인자는 key, value 가 번갈아 온다. 짝이 없는 마지막 값은 hclog 처럼 EXTRA_VALUE_AT_END 로 남긴다.
+func fields(args []interface{}) []zap.Field {
+	fs := make([]zap.Field, 0, (len(args)+1)/2)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fs = append(fs, zap.Any(hclog.MissingKey, args[i]))
+			break
+		}
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+		fs = append(fs, zap.Any(key, args[i+1]))
+	}
+	return fs
+}
@@ -0,0 +1,191 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+
+	defaultMaxSizeMB = 100
+)
+
+/*
+에이전트의 로거 설정
+모든 컴포넌트는 zap.L().Named(컴포넌트) 로 로거를 만들므로 Levels 의 키는 그 이름이다.
+(agent, server, http, auth, quota, audit, membership, resolver, replicator, metrics, tracing, tls, raft)
+*/
+type Config struct {
+	// debug, info, warn, error 중 하나, 기본값 info
+	Level string
+	// 로거 이름별 수준, 예: {"raft": "warn", "server": "debug"}
+	// 이름의 앞부분이 같은 하위 로거에도 적용된다. (raft 는 raft.snapshot 에도, membership 은 membership.serf 에도)
+	Levels map[string]string
+	// "console" 또는 "json", 기본값 console
+	Format string
+	// 로그를 쓸 곳, "stdout", "stderr" 또는 파일 경로, 기본값 stderr
+	Outputs []string
+	// 파일이 이 크기(MB)를 넘으면 새 파일로 바꾼다. 기본값 100
+	MaxSizeMB int
+	// 남겨둘 이전 파일 수, 0 이면 모두 남긴다.
+	MaxBackups int
+	// 이전 파일을 남겨둘 날 수, 0 이면 날짜로 지우지 않는다.
+	MaxAgeDays int
+	// 이전 파일을 gzip 으로 압축한다.
+	Compress bool
+}
+
+/*
+설정대로 로거를 만든다.
+리턴한 함수는 버퍼를 비우고 연 파일을 닫는다.
+*/
+func New(c Config) (*zap.Logger, func() error, error) {
+	level, err := parseLevel(c.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	levels := make(map[string]zapcore.Level, len(c.Levels))
+	min := level
+	for name, l := range c.Levels {
+		lvl, err := parseLevel(l)
+		if err != nil {
+			return nil, nil, fmt.Errorf("logging: level of %s: %w", name, err)
+		}
+		levels[name] = lvl
+		if lvl < min {
+			min = lvl
+		}
+	}
+
+	var encoder zapcore.Encoder
+	switch c.Format {
+	case "", FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	case FormatJSON:
+		config := zap.NewProductionEncoderConfig()
+		config.EncodeTime = zapcore.ISO8601TimeEncoder
+		encoder = zapcore.NewJSONEncoder(config)
+	default:
+		return nil, nil, fmt.Errorf("logging: unknown format %q", c.Format)
+	}
+
+	outputs := c.Outputs
+	if len(outputs) == 0 {
+		outputs = []string{OutputStderr}
+	}
+	maxSize := c.MaxSizeMB
+	if maxSize == 0 {
+		maxSize = defaultMaxSizeMB
+	}
+	var (
+		syncers []zapcore.WriteSyncer
+		files   []*lumberjack.Logger
+	)
+	for _, output := range outputs {
+		switch output {
+		case OutputStdout:
+			syncers = append(syncers, zapcore.Lock(os.Stdout))
+		case OutputStderr:
+			syncers = append(syncers, zapcore.Lock(os.Stderr))
+		default:
+			// lumberjack 은 처음 쓸 때 파일을 열므로 여기서 열 수 있는지 확인한다.
+			if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+				return nil, nil, err
+			}
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return nil, nil, err
+			}
+			f.Close()
+			file := &lumberjack.Logger{
+				Filename:   output,
+				MaxSize:    maxSize,
+				MaxBackups: c.MaxBackups,
+				MaxAge:     c.MaxAgeDays,
+				Compress:   c.Compress,
+			}
+			files = append(files, file)
+			syncers = append(syncers, zapcore.AddSync(file))
+		}
+	}
+
+	core := &levelCore{
+		Core:   zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(syncers...), min),
+		level:  level,
+		levels: levels,
+	}
+	logger := zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+	return logger, func() error {
+		// stderr 같은 터미널은 Sync 를 지원하지 않으므로 에러를 무시한다.
+		_ = logger.Sync()
+		var err error
+		for _, file := range files {
+			if e := file.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+		return err
+	}, nil
+}
+
+func parseLevel(level string) (zapcore.Level, error) {
+	switch strings.ToLower(level) {
+	case "":
+		return zapcore.InfoLevel, nil
+	case "trace":
+		// hclog 의 trace 는 zap 에 없으므로 debug 로 본다.
+		return zapcore.DebugLevel, nil
+	}
+	return zapcore.ParseLevel(level)
+}
+
+/*
+로거 이름으로 수준을 고른다.
+감싼 Core 는 가장 낮은 수준까지 켜두고, Check 에서 엔트리의 로거 이름에 맞는 수준보다 낮으면 버린다.
+*/
+type levelCore struct {
+	zapcore.Core
+	level  zapcore.Level
+	levels map[string]zapcore.Level
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{
+		Core:   c.Core.With(fields),
+		level:  c.level,
+		levels: c.levels,
+	}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if entry.Level < c.levelOf(entry.LoggerName) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// "raft.snapshot" 이면 "raft.snapshot", "raft" 순서로 찾는다.
+func (c *levelCore) levelOf(name string) zapcore.Level {
+	for name != "" {
+		if level, ok := c.levels[name]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return c.level
+}
@@ -0,0 +1,149 @@
+package logging_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/jhkim988/proglog/internal/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogging(t *testing.T) {
+	for scenario, fn := range map[string]func(t *testing.T, file string){
+		"component levels":    testComponentLevels,
+		"hclog bridge":        testHCLog,
+		"standard log bridge": testStdLogger,
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			fn(t, filepath.Join(t.TempDir(), "logs", "proglog.log"))
+		})
+	}
+}
+
+func testComponentLevels(t *testing.T, file string) {
+	logger, closeLogger, err := logging.New(logging.Config{
+		Level:   "info",
+		Levels:  map[string]string{"raft": "warn", "server": "debug"},
+		Format:  logging.FormatJSON,
+		Outputs: []string{file},
+	})
+	require.NoError(t, err)
+
+	logger.Debug("root debug")
+	logger.Info("root info")
+	logger.Named("server").Debug("server debug")
+	logger.Named("raft").Info("raft info")
+	logger.Named("raft").Named("snapshot").Info("snapshot info")
+	logger.Named("raft").Named("snapshot").Warn("snapshot warn")
+	logger.Named("rafter").Info("rafter info")
+	require.NoError(t, closeLogger())
+
+	require.Equal(t, []string{"root info", "server debug", "snapshot warn", "rafter info"}, messages(t, file))
+}
+
+func testHCLog(t *testing.T, file string) {
+	logger, closeLogger, err := logging.New(logging.Config{
+		Levels:  map[string]string{"raft.transport": "error"},
+		Format:  logging.FormatJSON,
+		Outputs: []string{file},
+	})
+	require.NoError(t, err)
+
+	raft := logging.HCLog(logger.Named("raft"))
+	require.False(t, raft.IsDebug())
+	require.True(t, raft.IsInfo())
+	require.Equal(t, hclog.Info, raft.GetLevel())
+
+	raft.Info("entering leader state", "leader", "node-0", "term", 2)
+	raft.Debug("skipped")
+	transport := raft.Named("transport")
+	require.Equal(t, "transport", transport.Name())
+	require.False(t, transport.IsWarn())
+	transport.Warn("skipped")
+	raft.With("peer", "node-1").Warn("failed to contact", "odd")
+	require.NoError(t, closeLogger())
+
+	entries := readEntries(t, file)
+	require.Len(t, entries, 2)
+	require.Equal(t, "raft", entries[0]["logger"])
+	require.Equal(t, "info", entries[0]["level"])
+	require.Equal(t, "node-0", entries[0]["leader"])
+	require.Equal(t, float64(2), entries[0]["term"])
+	require.Equal(t, "warn", entries[1]["level"])
+	require.Equal(t, "node-1", entries[1]["peer"])
+	require.Equal(t, "odd", entries[1][hclog.MissingKey])
+}
+
+func testStdLogger(t *testing.T, file string) {
+	logger, closeLogger, err := logging.New(logging.Config{
+		Level:   "debug",
+		Levels:  map[string]string{"membership.memberlist": "info"},
+		Format:  logging.FormatJSON,
+		Outputs: []string{file},
+	})
+	require.NoError(t, err)
+
+	serf := logging.StdLogger(logger.Named("membership").Named("serf"))
+	serf.Printf("[INFO] serf: EventMemberJoin: %s", "node-1")
+	serf.Printf("[ERR] serf: failed to join")
+	serf.Printf("no level")
+	memberlist := logging.StdLogger(logger.Named("membership").Named("memberlist"))
+	memberlist.Printf("[DEBUG] memberlist: stream connection")
+	memberlist.Printf("[WARN] memberlist: refuting a suspect message")
+	require.NoError(t, closeLogger())
+
+	entries := readEntries(t, file)
+	require.Len(t, entries, 4)
+	for i, want := range []struct{ level, msg string }{
+		{"info", "serf: EventMemberJoin: node-1"},
+		{"error", "serf: failed to join"},
+		{"info", "no level"},
+		{"warn", "memberlist: refuting a suspect message"},
+	} {
+		require.Equal(t, want.level, entries[i]["level"])
+		require.Equal(t, want.msg, entries[i]["msg"])
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for scenario, c := range map[string]logging.Config{
+		"unknown level":           {Level: "loud"},
+		"unknown component level": {Levels: map[string]string{"raft": "loud"}},
+		"unknown format":          {Format: "xml"},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, _, err := logging.New(c)
+			require.Error(t, err)
+		})
+	}
+}
+
+func readEntries(t *testing.T, file string) []map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func messages(t *testing.T, file string) []string {
+	t.Helper()
+	var msgs []string
+	for _, entry := range readEntries(t, file) {
+		msgs = append(msgs, entry["msg"].(string))
+	}
+	return msgs
+}
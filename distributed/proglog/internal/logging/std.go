@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"log"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+/*
+serf, memberlist 처럼 표준 *log.Logger 를 받는 라이브러리의 로그를 zap 로거로 보낸다.
+"[WARN] memberlist: ..." 처럼 줄 앞의 수준을 읽어서 그 수준으로 남기고, 없으면 info 로 남긴다.
+*/
+func StdLogger(logger *zap.Logger) *log.Logger {
+	return log.New(&stdWriter{
+		logger: logger.WithOptions(zap.WithCaller(false)),
+		level:  zapcore.InfoLevel,
+		infer:  true,
+	}, "", 0)
+}
+
+type stdWriter struct {
+	logger *zap.Logger
+	level  zapcore.Level
+	infer  bool
+}
+
+// log.Logger 는 한 줄마다 Write 를 한 번 호출한다.
+func (w *stdWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(p))
+	level := w.level
+	if w.infer {
+		level, msg = inferLevel(msg, level)
+	}
+	if ce := w.logger.Check(level, msg); ce != nil {
+		ce.Write()
+	}
+	return len(p), nil
+}
+
+var prefixes = []struct {
+	prefix string
+	level  zapcore.Level
+}{
+	{"[TRACE]", zapcore.DebugLevel},
+	{"[DEBUG]", zapcore.DebugLevel},
+	{"[INFO]", zapcore.InfoLevel},
+	{"[WARN]", zapcore.WarnLevel},
+	{"[ERR]", zapcore.ErrorLevel},
+	{"[ERROR]", zapcore.ErrorLevel},
+}
+
+func inferLevel(msg string, level zapcore.Level) (zapcore.Level, string) {
+	for _, p := range prefixes {
+		if strings.HasPrefix(msg, p.prefix) {
+			return p.level, strings.TrimSpace(msg[len(p.prefix):])
+		}
+	}
+	return level, msg
+}
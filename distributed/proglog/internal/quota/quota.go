@@ -0,0 +1,341 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// 초당 허용량, 0 이면 제한하지 않는다.
+type Limits struct {
+	RequestsPerSecond     float64 `json:"requests_per_second"`
+	ProduceBytesPerSecond float64 `json:"produce_bytes_per_second"`
+	ConsumeBytesPerSecond float64 `json:"consume_bytes_per_second"`
+}
+
+/*
+subject 별 할당량, Subjects 에 없는 subject 는 Default 를 쓴다.
+
+	{
+	  "default": {"requests_per_second": 100, "produce_bytes_per_second": 1048576},
+	  "subjects": {"root": {}}
+	}
+*/
+type Config struct {
+	Default  Limits            `json:"default"`
+	Subjects map[string]Limits `json:"subjects"`
+}
+
+func (c Config) limits(subject string) Limits {
+	if limits, ok := c.Subjects[subject]; ok {
+		return limits
+	}
+	return c.Default
+}
+
+type buckets struct {
+	requests *rate.Limiter
+	produce  *rate.Limiter
+	consume  *rate.Limiter
+}
+
+/*
+subject 마다 요청 수, produce 바이트, consume 바이트의 토큰 버킷을 둔다.
+버킷 크기는 1초 분량이므로 잠깐 몰리는 요청은 받고, 계속 넘치면 ResourceExhausted 로 거절한다.
+버킷보다 큰 produce 는 영원히 받을 수 없으므로 InvalidArgument 로 거절한다.
+consume 은 읽기 전에는 크기를 모르므로 읽은 뒤에 모두 차감하고, 빚이 남아 있는 동안 다음 요청을 거절한다.
+*/
+type Limiter struct {
+	path    string
+	mu      sync.Mutex
+	config  Config
+	buckets map[string]*buckets
+}
+
+func New(config Config) *Limiter {
+	return &Limiter{
+		config:  config,
+		buckets: make(map[string]*buckets),
+	}
+}
+
+// JSON 할당량 파일을 읽는다. Reload 와 Watch 는 이 파일을 다시 읽는다.
+func Load(path string) (*Limiter, error) {
+	config, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	l := New(config)
+	l.path = path
+	return l, nil
+}
+
+func readConfig(path string) (Config, error) {
+	var config Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("quota: read config: %w", err)
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return config, fmt.Errorf("quota: parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+/*
+할당량을 바꾼다. 버킷을 새로 만들면 모두의 할당량이 다시 차므로, 남은 토큰과 빚은 그대로 두고 비율과 크기만 바꾼다.
+get 이 리턴한 buckets 는 락 없이 읽으므로 고치지 않고 바꿔 넣는다.
+*/
+func (l *Limiter) Update(config Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	for subject, b := range l.buckets {
+		limits := config.limits(subject)
+		l.buckets[subject] = &buckets{
+			requests: updateBucket(b.requests, limits.RequestsPerSecond),
+			produce:  updateBucket(b.produce, limits.ProduceBytesPerSecond),
+			consume:  updateBucket(b.consume, limits.ConsumeBytesPerSecond),
+		}
+	}
+}
+
+// 파일을 다시 읽는다. 읽지 못하면 이전 할당량을 그대로 쓴다.
+func (l *Limiter) Reload() error {
+	if l.path == "" {
+		return nil
+	}
+	config, err := readConfig(l.path)
+	if err != nil {
+		return err
+	}
+	l.Update(config)
+	return nil
+}
+
+/*
+interval 마다 할당량 파일의 수정 시각을 확인하고, 바뀌었으면 다시 읽는다.
+리턴한 함수로 멈춘다.
+*/
+func (l *Limiter) Watch(interval time.Duration) (stop func()) {
+	logger := zap.L().Named("quota")
+	done := make(chan struct{})
+	last := l.modTime()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			modTime := l.modTime()
+			if modTime.Equal(last) {
+				continue
+			}
+			if err := l.Reload(); err != nil {
+				logger.Error("failed to reload quotas", zap.Error(err))
+				continue
+			}
+			last = modTime
+			logger.Info("reloaded quotas", zap.String("path", l.path))
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+func (l *Limiter) modTime() time.Time {
+	if l.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(l.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (l *Limiter) get(subject string) *buckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[subject]
+	if !ok {
+		limits := l.config.limits(subject)
+		b = &buckets{
+			requests: newBucket(limits.RequestsPerSecond),
+			produce:  newBucket(limits.ProduceBytesPerSecond),
+			consume:  newBucket(limits.ConsumeBytesPerSecond),
+		}
+		l.buckets[subject] = b
+	}
+	return b
+}
+
+func newBucket(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), burst(perSecond))
+}
+
+func updateBucket(bucket *rate.Limiter, perSecond float64) *rate.Limiter {
+	if perSecond <= 0 || bucket == nil {
+		return newBucket(perSecond)
+	}
+	bucket.SetLimit(rate.Limit(perSecond))
+	bucket.SetBurst(burst(perSecond))
+	return bucket
+}
+
+// 1초 분량
+func burst(perSecond float64) int {
+	return int(math.Max(math.Ceil(perSecond), 1))
+}
+
+// 요청 하나를 받을 수 있는지 확인한다.
+func (l *Limiter) Request(subject string) error {
+	return take(l.get(subject).requests, 1, "request", subject)
+}
+
+// bytes 크기의 레코드를 쓸 수 있는지 확인하고 차감한다.
+func (l *Limiter) Produce(subject string, bytes int) error {
+	return take(l.get(subject).produce, bytes, "produce", subject)
+}
+
+// 이전에 읽은 만큼의 빚이 남아 있으면 거절한다.
+func (l *Limiter) Consume(subject string) error {
+	bucket := l.get(subject).consume
+	if bucket == nil {
+		return nil
+	}
+	if tokens := bucket.Tokens(); tokens < 0 {
+		return exhausted("consume", subject, time.Duration(-tokens/float64(bucket.Limit())*float64(time.Second)))
+	}
+	return nil
+}
+
+// 읽은 바이트를 차감한다. 버킷보다 크면 넘는 만큼 빚으로 남는다.
+func (l *Limiter) Consumed(subject string, bytes int) {
+	bucket := l.get(subject).consume
+	if bucket == nil {
+		return
+	}
+	charge(bucket, time.Now(), bytes)
+}
+
+// 스트림은 거절하지 않고 할당량이 찰 때까지 기다린다. 버킷보다 큰 레코드는 거절한다.
+func (l *Limiter) WaitProduce(ctx context.Context, subject string, bytes int) error {
+	bucket := l.get(subject).produce
+	if bucket == nil {
+		return nil
+	}
+	if err := tooLarge(bucket, bytes, "produce", subject); err != nil {
+		return err
+	}
+	return bucket.WaitN(ctx, bytes)
+}
+
+// 이미 읽은 레코드이므로 버킷보다 커도 모두 차감하고 빚을 갚을 때까지 기다린다.
+func (l *Limiter) WaitConsume(ctx context.Context, subject string, bytes int) error {
+	bucket := l.get(subject).consume
+	if bucket == nil {
+		return nil
+	}
+	delay, cancel := charge(bucket, time.Now(), bytes)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		cancel()
+		return ctx.Err()
+	}
+}
+
+func take(bucket *rate.Limiter, n int, what, subject string) error {
+	if bucket == nil {
+		return nil
+	}
+	if err := tooLarge(bucket, n, what, subject); err != nil {
+		return err
+	}
+	now := time.Now()
+	r := bucket.ReserveN(now, n)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return exhausted(what, subject, delay)
+	}
+	return nil
+}
+
+/*
+n 을 버킷 크기씩 나눠서 모두 차감하고, 마지막 토큰을 받을 때까지의 시간을 리턴한다.
+rate.Limiter 는 버킷보다 큰 요청을 한 번에 예약하지 못하지만, 나눠서 예약하면 토큰이 음수가 되어 빚으로 남는다.
+리턴한 함수는 예약을 모두 취소한다.
+*/
+func charge(bucket *rate.Limiter, now time.Time, n int) (time.Duration, func()) {
+	var (
+		delay        time.Duration
+		reservations []*rate.Reservation
+	)
+	for n > 0 {
+		chunk := n
+		if chunk > bucket.Burst() {
+			chunk = bucket.Burst()
+		}
+		r := bucket.ReserveN(now, chunk)
+		reservations = append(reservations, r)
+		delay = r.DelayFrom(now)
+		n -= chunk
+	}
+	return delay, func() {
+		// 나중에 예약한 것부터 취소해야 토큰을 모두 돌려받는다.
+		for i := len(reservations) - 1; i >= 0; i-- {
+			reservations[i].Cancel()
+		}
+	}
+}
+
+// 버킷보다 큰 요청은 기다려도 받을 수 없으므로 다시 시도하지 않도록 InvalidArgument 로 거절한다.
+func tooLarge(bucket *rate.Limiter, n int, what, subject string) error {
+	if n > bucket.Burst() {
+		return status.Errorf(codes.InvalidArgument, "%s of %d exceeds the %q quota of %d per second", what, n, subject, bucket.Burst())
+	}
+	return nil
+}
+
+// ResourceExhausted 에 다시 시도할 수 있는 시간을 RetryInfo 로 담는다.
+func exhausted(what, subject string, delay time.Duration) error {
+	st := status.Newf(codes.ResourceExhausted, "%s quota exceeded for %q, retry after %s", what, subject, delay.Round(time.Millisecond))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(delay)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// err 에 담긴 RetryInfo 의 대기 시간, 없으면 false
+func RetryDelay(err error) (time.Duration, bool) {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok {
+			return info.RetryDelay.AsDuration(), true
+		}
+	}
+	return 0, false
+}
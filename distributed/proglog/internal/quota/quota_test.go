@@ -0,0 +1,112 @@
+package quota
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New(Config{
+		Default: Limits{RequestsPerSecond: 2, ProduceBytesPerSecond: 10, ConsumeBytesPerSecond: 10},
+		Subjects: map[string]Limits{
+			"root": {},
+		},
+	})
+
+	// 버킷 크기만큼은 바로 받는다.
+	require.NoError(t, l.Request("alice"))
+	require.NoError(t, l.Request("alice"))
+	err := l.Request("alice")
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok := RetryDelay(err)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= 500*time.Millisecond, delay)
+
+	// subject 마다 버킷이 따로 있고, 할당량이 없는 subject 는 제한하지 않는다.
+	require.NoError(t, l.Request("bob"))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Request("root"))
+	}
+
+	// 버킷보다 큰 레코드는 기다려도 받을 수 없으므로 거절하고, 차감하지 않는다.
+	require.Equal(t, codes.InvalidArgument, status.Code(l.Produce("alice", 100)))
+	require.NoError(t, l.Produce("alice", 10))
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.Produce("alice", 1)))
+
+	// consume 은 읽은 뒤에 차감하고, 빚이 남아 있으면 거절한다.
+	require.NoError(t, l.Consume("alice"))
+	l.Consumed("alice", 10)
+	l.Consumed("alice", 5)
+	err = l.Consume("alice")
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok = RetryDelay(err)
+	require.True(t, ok)
+	require.True(t, delay > 0 && delay <= 500*time.Millisecond, delay)
+
+	// 버킷보다 크게 읽으면 넘는 만큼 빚으로 남는다.
+	require.NoError(t, l.Consume("carol"))
+	l.Consumed("carol", 25)
+	delay, ok = RetryDelay(l.Consume("carol"))
+	require.True(t, ok)
+	require.True(t, delay > time.Second && delay <= 1500*time.Millisecond, delay)
+
+	// 스트림은 할당량이 찰 때까지 기다린다.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	require.NoError(t, l.WaitProduce(ctx, "bob", 10))
+	require.NoError(t, l.WaitProduce(ctx, "bob", 2))
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+	require.Equal(t, codes.InvalidArgument, status.Code(l.WaitProduce(ctx, "bob", 11)))
+
+	// 버킷보다 크게 읽은 스트림은 빚을 갚을 때까지 기다린다.
+	start = time.Now()
+	require.NoError(t, l.WaitConsume(ctx, "dave", 15))
+	require.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// 할당량을 바꿔도 남은 토큰과 빚은 그대로다.
+	require.NoError(t, l.Request("erin"))
+	require.NoError(t, l.Request("erin"))
+	l.Consumed("erin", 20)
+	l.Update(Config{Default: Limits{RequestsPerSecond: 1, ConsumeBytesPerSecond: 10}})
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.Request("erin")))
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.Consume("erin")))
+	require.NoError(t, l.Request("frank"))
+
+	// 할당량을 없애면 제한하지 않는다.
+	l.Update(Config{})
+	require.NoError(t, l.Request("alice"))
+	require.NoError(t, l.Consume("alice"))
+}
+
+func TestLimiterWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"default": {"requests_per_second": 1}}`), 0600))
+
+	l, err := Load(path)
+	require.NoError(t, err)
+	stop := l.Watch(10 * time.Millisecond)
+	defer stop()
+
+	require.NoError(t, l.Request("alice"))
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.Request("alice")))
+
+	// 읽을 수 없는 파일로 바뀌면 이전 할당량을 유지한다.
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, codes.ResourceExhausted, status.Code(l.Request("alice")))
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"subjects": {"alice": {"requests_per_second": 100}}}`), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	require.Eventually(t, func() bool {
+		return l.Request("alice") == nil
+	}, time.Second, 10*time.Millisecond)
+}
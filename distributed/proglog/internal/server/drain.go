@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+/*
+노드를 내리기 전에 새 요청을 받지 않고, 처리 중인 요청이 끝나기를 기다린다.
+거절할 때는 Unavailable 을 리턴하므로 클라이언트는 다른 서버로 재시도할 수 있다.
+쓰기 요청과 스트리밍 RPC 만 막고, GetServers 나 Admin 요청은 계속 받는다. (다른 노드가 이 노드를 제거할 수 있도록)
+*/
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	done     chan struct{}
+}
+
+func NewDrainer() *Drainer {
+	return &Drainer{
+		done: make(chan struct{}),
+	}
+}
+
+// 새 요청을 막고, 진행 중인 요청이 끝나거나 timeout 이 지날 때까지 기다린다.
+func (d *Drainer) Drain(timeout time.Duration) error {
+	d.mu.Lock()
+	if !d.draining {
+		d.draining = true
+		close(d.done)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out waiting for in-flight calls after %s", timeout)
+	}
+}
+
+// drain 을 시작하면 닫힌다. 끝나지 않는 스트림은 이 채널을 보고 스스로 끝내야 한다.
+func (d *Drainer) Draining() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.done
+}
+
+// 요청을 받을 수 있으면 진행 중인 요청 수를 늘리고 true 를 리턴한다.
+func (d *Drainer) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.inflight.Add(1)
+	return true
+}
+
+func (d *Drainer) errDraining() error {
+	return status.Error(codes.Unavailable, "server is draining")
+}
+
+// drain 중에 거절하는 쓰기 요청
+var drainedMethods = map[string]bool{
+	api.Log_Produce_FullMethodName:   true,
+	api.Log_BeginTxn_FullMethodName:  true,
+	api.Log_AppendTxn_FullMethodName: true,
+	api.Log_CommitTxn_FullMethodName: true,
+	api.Log_AbortTxn_FullMethodName:  true,
+}
+
+func (d *Drainer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !drainedMethods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		if !d.enter() {
+			return nil, d.errDraining()
+		}
+		defer d.inflight.Done()
+		return handler(ctx, req)
+	}
+}
+
+func (d *Drainer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		/* 클러스터 이벤트 구독은 drain 을 시작하면 바로 끝나고, 상태 확인은 drain 중에도 받으므로 기다리지 않는다. */
+		if info.FullMethod == api.Log_WatchCluster_FullMethodName || info.FullMethod == healthpb.Health_Watch_FullMethodName {
+			return handler(srv, stream)
+		}
+		if !d.enter() {
+			return d.errDraining()
+		}
+		defer d.inflight.Done()
+		return handler(srv, stream)
+	}
+}
+
+/*
+HTTP API 에도 같은 규칙을 적용한다.
+쓰기 요청(POST)과 스트림(GET /v1/records, /v1/stream)만 막고 기다리며, 한 번 읽는 요청은 계속 받는다.
+*/
+func (d *Drainer) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream := r.URL.Path == "/v1/records" || r.URL.Path == "/v1/stream"
+		if r.Method != http.MethodPost && !(r.Method == http.MethodGet && stream) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !d.enter() {
+			writeHTTPError(w, d.errDraining())
+			return
+		}
+		defer d.inflight.Done()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+/*
+오케스트레이터가 노드 상태를 확인한다. Agent 가 구현한다.
+Live 가 실패하면 프로세스를 다시 시작해야 하고, Ready 가 실패하면 요청을 보내지 말아야 한다.
+*/
+type HealthChecker interface {
+	Live() error
+	Ready() error
+}
+
+const healthWatchInterval = time.Second
+
+var errDrainingHealth = errors.New("server is draining")
+
+/*
+표준 gRPC health 서비스
+서비스 이름이 "" 이거나 Log 서비스면 준비 상태를, Admin 서비스면 살아 있는지를 알려준다.
+인증서 없이 확인할 수 있도록 인증하지 않고, drain 중에도 받는다.
+*/
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	checker HealthChecker
+	drainer *Drainer
+}
+
+var _ healthpb.HealthServer = (*healthServer)(nil)
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s, err := h.status(req.Service)
+	if err != nil {
+		return nil, err
+	}
+	return &healthpb.HealthCheckResponse{Status: s}, nil
+}
+
+// 상태가 바뀔 때마다 보낸다. drain 을 시작하면 끝낸다.
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		s, err := h.status(req.Service)
+		if err != nil {
+			// 모르는 서비스는 Check 와 달리 SERVICE_UNKNOWN 을 보내고 계속 기다린다.
+			s = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if s != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: s}); err != nil {
+				return err
+			}
+			last = s
+		}
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-h.drainer.Draining():
+			// GracefulStop 이 기다리지 않도록 NOT_SERVING 을 알리고 끝낸다. 클라이언트는 다른 서버를 확인한다.
+			if last != healthpb.HealthCheckResponse_NOT_SERVING {
+				if err := stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}); err != nil {
+					return err
+				}
+			}
+			return h.drainer.errDraining()
+		case <-ticker.C:
+		}
+	}
+}
+
+// grpc_auth 가 인증 대신 호출한다.
+func (h *healthServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *healthServer) status(service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	var err error
+	switch service {
+	case "", api.Log_ServiceDesc.ServiceName:
+		err = readiness(h.checker, h.drainer)
+	case api.Admin_ServiceDesc.ServiceName:
+		err = h.checker.Live()
+	default:
+		return 0, status.Errorf(codes.NotFound, "unknown service %q", service)
+	}
+	if err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING, nil
+	}
+	return healthpb.HealthCheckResponse_SERVING, nil
+}
+
+// drain 을 시작한 노드에는 새 요청을 보내지 않아야 한다.
+func readiness(checker HealthChecker, drainer *Drainer) error {
+	select {
+	case <-drainer.Draining():
+		return errDrainingHealth
+	default:
+	}
+	return checker.Ready()
+}
+
+/*
+HTTP 상태 확인, 인증하지 않는다.
+
+	GET /healthz → 200 {"status": "ok"} 또는 503 {"status": "unavailable", "error": "..."}
+	GET /readyz  → 같은 형식
+*/
+func healthHandler(config *Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch r.URL.Path {
+		case "/healthz":
+			err = config.Health.Live()
+		case "/readyz":
+			err = readiness(config.Health, config.Drainer)
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		res := struct {
+			Status string `json:"status"`
+			Error  string `json:"error,omitempty"`
+		}{Status: "ok"}
+		code := http.StatusOK
+		if err != nil {
+			res.Status, res.Error = "unavailable", err.Error()
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
+
+func registerHealthServer(gsrv *grpc.Server, config *Config) {
+	healthpb.RegisterHealthServer(gsrv, &healthServer{
+		checker: config.Health,
+		drainer: config.Drainer,
+	})
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/auth"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/jhkim988/proglog/internal/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type fakeHealth struct {
+	mu    sync.Mutex
+	ready error
+}
+
+func (h *fakeHealth) Live() error { return nil }
+
+func (h *fakeHealth) Ready() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+func (h *fakeHealth) setReady(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = err
+}
+
+// 인증서 없이 연결해도 상태는 확인할 수 있다.
+func TestHealth(t *testing.T) {
+	dir, err := os.MkdirTemp("", "health-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	defer clog.Remove()
+
+	health := &fakeHealth{}
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Drainer:    NewDrainer(),
+		Health:     health,
+	}
+	gsrv, err := NewGRPCServer(cfg)
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go gsrv.Serve(l)
+	defer gsrv.Stop()
+	httpSrv := httptest.NewServer(NewHTTPServer(cfg).Handler)
+	defer httpSrv.Close()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer cc.Close()
+	client := healthpb.NewHealthClient(cc)
+	ctx := context.Background()
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		res, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return res.Status
+	}
+	httpStatus := func(path string) int {
+		res, err := http.Get(httpSrv.URL + path)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		var body struct{ Status string }
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+		return res.StatusCode
+	}
+
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(api.Log_ServiceDesc.ServiceName))
+	require.Equal(t, http.StatusOK, httpStatus("/healthz"))
+	require.Equal(t, http.StatusOK, httpStatus("/readyz"))
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// 다른 요청은 여전히 인가를 거친다.
+	_, err = api.NewLogClient(cc).Consume(ctx, &api.ConsumeRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	watch, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err := watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.Status)
+
+	health.setReady(errors.New("no known raft leader"))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(api.Admin_ServiceDesc.ServiceName))
+	require.Equal(t, http.StatusServiceUnavailable, httpStatus("/readyz"))
+	require.Equal(t, http.StatusOK, httpStatus("/healthz"))
+	res, err = watch.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.Status)
+
+	// drain 을 시작하면 준비되지 않은 것으로 알리고 Watch 를 끝낸다.
+	require.NoError(t, cfg.Drainer.Drain(time.Second))
+	health.setReady(nil)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, http.StatusServiceUnavailable, httpStatus("/readyz"))
+	_, err = watch.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
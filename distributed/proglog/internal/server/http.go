@@ -1,100 +1,432 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/auth"
+	"github.com/jhkim988/proglog/internal/quota"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
-func NewHTTPServer(addr string) *http.Server {
-	httpsrv := newHTTPServer()
-	r := mux.NewRouter()
-	r.HandleFunc("/", httpsrv.handleProduce).Methods("POST")
-	r.HandleFunc("/", httpsrv.handleConsume).Methods("GET")
-	return &http.Server {
-		Addr: addr,
-		Handler: r,
-	} 
-}
+/*
+gRPC Log 서비스와 같은 CommitLog, Authorizer, GetServerer 를 쓰는 REST/JSON API
+메시지는 protojson 으로 인코딩하므로 필드 이름은 gRPC 메시지와 같다. (uint64 는 문자열, bytes 는 base64)
 
-type httpServer struct {
-	Log *Log
-}
+	POST /v1/records           {"record": {"value": "aGVsbG8="}} → 201 {"offset": "0"}
+	GET  /v1/records/{offset}  → {"record": {...}}, 쿼리: topic, read_committed, max_wait (예: 5s)
+	GET  /v1/records?offset=0  → server-sent events, 레코드마다 이벤트 하나를 보내고 id 는 오프셋이다.
+	                             쿼리: topic, read_committed, max_records, max_bytes, heartbeat_interval
+	GET  /v1/servers           → {"servers": [...]}
+	GET  /v1/offsets           → {"lowestOffset": "0", "nextOffset": "3"}, 쿼리: topic
+	GET  /v1/stream?offset=0   → WebSocket, websocket.go 참고
+	GET  /healthz, /readyz     → 노드 상태, 인증하지 않는다. health.go 참고
 
-func newHTTPServer() *httpServer {
-	return &httpServer {
-		Log: NewLog(),
+에러는 gRPC 상태 코드에 맞는 HTTP 상태 코드와 {"code": "NOT_FOUND", "message": "..."} 로 응답한다.
+팔로워가 쓰기 요청을 받으면 503 과 {"code": "Unavailable", ..., "leader": "<리더 주소>"} 로 응답한다.
+인증은 클라이언트 인증서나 "Authorization: Bearer <토큰>" 헤더로 한다.
+브라우저의 WebSocket 은 헤더를 정할 수 없으므로 /v1/stream 에서만 access_token 쿼리도 받는다.
+쿼리는 접근 로그나 Referer 로 새기 쉬우므로 다른 경로에서는 받지 않는다.
+*/
+func NewHTTPServer(config *Config) *http.Server {
+	srv := &httpServer{
+		grpc:   &grpcServer{Config: config},
+		logger: zap.L().Named("http"),
 	}
-}
-
-type ProduceRequest struct {
-	Record Record `json:"record"`
-}
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/records", srv.handleProduce).Methods(http.MethodPost)
+	r.HandleFunc("/v1/records/{offset:[0-9]+}", srv.handleConsume).Methods(http.MethodGet)
+	r.HandleFunc("/v1/records", srv.handleConsumeStream).Methods(http.MethodGet)
+	r.HandleFunc("/v1/servers", srv.handleGetServers).Methods(http.MethodGet)
+	r.HandleFunc("/v1/offsets", srv.handleGetOffsets).Methods(http.MethodGet)
+	r.HandleFunc(webSocketPath, srv.handleWebSocket).Methods(http.MethodGet)
 
-type ProduceResponse struct {
-	Offset uint64 `json:"offset"`
+	var handler http.Handler = r
+	if config.Drainer != nil {
+		handler = config.Drainer.Handler(handler)
+	}
+	handler = authenticateHTTP(config.authenticator(), handler)
+	if config.Health != nil {
+		handler = healthHandler(config, handler)
+	}
+	// 스트림이 오래 열려 있으므로 요청 전체가 아니라 헤더를 받는 시간만 제한한다. (Slowloris)
+	return &http.Server{
+		Handler:           handler,
+		ConnContext:       connContext,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
 }
 
-type ConsumeRequest struct {
-	Offset uint64 `json:"offset"`
-}
+const (
+	maxHTTPBodyBytes            = 4 << 20
+	defaultSSEHeartbeatInterval = 15 * time.Second
+	httpReadHeaderTimeout       = 10 * time.Second
+	webSocketPath               = "/v1/stream"
+)
 
-type ConsumeResponse struct {
-	Record Record `json:"record"`
+type httpServer struct {
+	grpc   *grpcServer
+	logger *zap.Logger
 }
 
 func (s *httpServer) handleProduce(w http.ResponseWriter, r *http.Request) {
-	/* 1. 요청을 구조체로 역직렬화 한다. */
-	var req ProduceRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPBodyBytes))
+	if err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	req := &api.ProduceRequest{}
+	if err := protojson.Unmarshal(body, req); err != nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	if req.Record == nil {
+		writeHTTPError(w, status.Error(codes.InvalidArgument, "record is required"))
+		return
+	}
 
+	if err := admitQuota(r.Context(), s.grpc.Quotas, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := s.grpc.Produce(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeHTTPError(w, err)
 		return
 	}
+	w.Header().Set("Location", fmt.Sprintf("/v1/records/%d", res.Offset))
+	writeJSON(w, http.StatusCreated, res)
+}
 
-	/* 2. 로그에 추가한다. */
-	off, err := s.Log.Append(req.Record)
+func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseUint(mux.Vars(r)["offset"], 10, 64)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeHTTPError(w, status.Error(codes.InvalidArgument, err.Error()))
+		return
+	}
+	req := &api.ConsumeRequest{Offset: offset}
+	if err := parseConsumeQuery(r, req); err != nil {
+		writeHTTPError(w, err)
 		return
 	}
 
-	/* 3. Offset 을 구조체에 담아 직렬화하여 응답한다. */
-	res := ProduceResponse{Offset: off}
-	err = json.NewEncoder(w).Encode(res)
+	if err := admitQuota(r.Context(), s.grpc.Quotas, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	res, err := s.grpc.Consume(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeHTTPError(w, err)
 		return
 	}
+	chargeQuota(r.Context(), s.grpc.Quotas, res)
+	writeJSON(w, http.StatusOK, res)
 }
 
-func (s *httpServer) handleConsume(w http.ResponseWriter, r *http.Request) {
-	/* 1. 요청을 역직렬화 한다.*/
-	var req ConsumeRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+/*
+ConsumeStream 을 server-sent events 로 보낸다.
+다시 연결한 EventSource 가 보내는 Last-Event-ID 가 있으면 그 다음 오프셋부터 보낸다.
+*/
+func (s *httpServer) handleConsumeStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeHTTPError(w, status.Error(codes.Unimplemented, "streaming is not supported"))
+		return
+	}
+	req := &api.ConsumeRequest{
+		HeartbeatInterval: durationpb.New(defaultSSEHeartbeatInterval),
+	}
+	if err := parseConsumeQuery(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		last, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			writeHTTPError(w, status.Error(codes.InvalidArgument, "invalid Last-Event-ID"))
+			return
+		}
+		req.Offset = last + 1
+	}
+	if err := admitQuota(r.Context(), s.grpc.Quotas, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	stream := &sseStream{ctx: r.Context(), w: w, flusher: flusher}
+	err := s.grpc.ConsumeStream(req, withConsumeQuota(s.grpc.Quotas, stream))
+	if err == nil {
+		return
+	}
+	if !stream.started {
+		writeHTTPError(w, err)
+		return
+	}
+	// 이미 응답을 시작했으면 에러를 이벤트로 보낸다.
+	data, _ := json.Marshal(httpError(err))
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	flusher.Flush()
+	s.logger.Debug("consume stream ended", zap.Error(err))
+}
+
+func (s *httpServer) handleGetServers(w http.ResponseWriter, r *http.Request) {
+	if s.grpc.GetServerer == nil {
+		writeHTTPError(w, status.Error(codes.Unimplemented, "server discovery is not supported by this server"))
+		return
+	}
+	res, err := s.grpc.GetServers(r.Context(), &api.GetServersRequest{})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		writeHTTPError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, res)
+}
 
-	/* 2. Offset 에 있는 로그를 읽는다. */
-	record, err := s.Log.Read(req.Offset)
-	if err == ErrOffsetNotFound {
-		http.Error(w, err.Error(), http.StatusNotFound)
+func (s *httpServer) handleGetOffsets(w http.ResponseWriter, r *http.Request) {
+	req := &api.GetOffsetsRequest{Topic: r.URL.Query().Get("topic")}
+	if err := admitQuota(r.Context(), s.grpc.Quotas, req); err != nil {
+		writeHTTPError(w, err)
 		return
 	}
+	res, err := s.grpc.GetOffsets(r.Context(), req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeHTTPError(w, err)
 		return
 	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// 쿼리 파라미터를 ConsumeRequest 필드로 옮긴다. offset 은 경로에 없을 때만 쿼리에서 읽는다.
+func parseConsumeQuery(r *http.Request, req *api.ConsumeRequest) error {
+	query := r.URL.Query()
+	var err error
+	parseUint := func(name string, bits int) uint64 {
+		v := query.Get(name)
+		if v == "" || err != nil {
+			return 0
+		}
+		n, perr := strconv.ParseUint(v, 10, bits)
+		if perr != nil {
+			err = status.Errorf(codes.InvalidArgument, "invalid %s: %q", name, v)
+		}
+		return n
+	}
+	parseDuration := func(name string, def *durationpb.Duration) *durationpb.Duration {
+		v := query.Get(name)
+		if v == "" || err != nil {
+			return def
+		}
+		d, perr := time.ParseDuration(v)
+		if perr != nil {
+			err = status.Errorf(codes.InvalidArgument, "invalid %s: %q", name, v)
+		}
+		return durationpb.New(d)
+	}
+
+	if _, ok := mux.Vars(r)["offset"]; !ok {
+		req.Offset = parseUint("offset", 64)
+	}
+	if v := query.Get("read_committed"); v != "" {
+		committed, perr := strconv.ParseBool(v)
+		if perr != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid read_committed: %q", v)
+		}
+		req.ReadCommitted = committed
+	}
+	req.Topic = query.Get("topic")
+	req.MaxRecords = uint32(parseUint("max_records", 32))
+	req.MaxBytes = uint32(parseUint("max_bytes", 32))
+	req.MaxWait = parseDuration("max_wait", req.MaxWait)
+	req.HeartbeatInterval = parseDuration("heartbeat_interval", req.HeartbeatInterval)
+	return err
+}
 
-	/* 3. Record 를 직렬화하여 응답한다. */
-	res := ConsumeResponse{Record: record}
-	err = json.NewEncoder(w).Encode(res)
+/*
+ConsumeStream 의 응답을 server-sent events 로 쓴다.
+grpcServer.ConsumeStream 은 Context 와 Send 만 쓰므로 나머지 grpc.ServerStream 메서드는 구현하지 않는다.
+*/
+type sseStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	w       http.ResponseWriter
+	flusher http.Flusher
+	started bool
+}
+
+func (s *sseStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sseStream) Send(res *api.ConsumeResponse) error {
+	if !s.started {
+		s.started = true
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+		s.w.WriteHeader(http.StatusOK)
+	}
+	if len(res.Records) == 0 {
+		// 주석 줄은 EventSource 가 무시한다.
+		if _, err := io.WriteString(s.w, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+	}
+	for _, record := range res.Records {
+		data, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(s.w, "id: %d\nevent: record\ndata: %s\n\n", record.Offset, data); err != nil {
+			return err
+		}
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, msg proto.Message) {
+	data, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(msg)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeHTTPError(w, err)
 		return
 	}
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+type httpErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// 리더만 처리할 수 있는 요청을 팔로워가 받았으면 리더의 주소, 클라이언트는 그 노드로 다시 요청한다.
+	Leader string `json:"leader,omitempty"`
+}
+
+func httpError(err error) httpErrorBody {
+	st := status.Convert(err)
+	code := st.Code().String()
+	if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+		code = codes.NotFound.String()
+	}
+	var notLeader api.ErrNotLeader
+	if errors.As(err, &notLeader) {
+		return httpErrorBody{Code: codes.Unavailable.String(), Message: st.Message(), Leader: notLeader.Leader}
+	}
+	return httpErrorBody{Code: code, Message: st.Message()}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	data, _ := json.Marshal(httpError(err))
+	if delay, ok := quota.RetryDelay(err); ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusCode(err))
+	w.Write(data)
+}
+
+// gRPC 상태 코드를 HTTP 상태 코드로 바꾼다. (grpc-gateway 와 같은 대응)
+func httpStatusCode(err error) int {
+	// ErrOffsetOutOfRange 의 gRPC 상태 코드는 표준 코드가 아니므로 따로 확인한다.
+	if errors.As(err, &api.ErrOffsetOutOfRange{}) {
+		return http.StatusNotFound
+	}
+	// ErrNotLeader 는 FailedPrecondition 이지만 요청이 잘못된 것이 아니므로 리더에 다시 보낼 수 있게 한다.
+	if errors.As(err, &api.ErrNotLeader{}) {
+		return http.StatusServiceUnavailable
+	}
+	switch status.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+type connStateContextKey struct{}
+
+// cmux 뒤에서 TLS 를 끝낸 연결은 r.TLS 가 비어 있으므로 연결의 TLS 상태를 context 에 넣어둔다.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if tc := tlsConn(c); tc != nil {
+		if state := tc.ConnectionState(); state.HandshakeComplete {
+			return context.WithValue(ctx, connStateContextKey{}, &state)
+		}
+	}
+	return ctx
+}
+
+// gRPC 와 같은 Authenticator 로 TLS 상태와 bearer 토큰을 확인한다.
+func authenticateHTTP(authenticator auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := auth.Credentials{TLS: r.TLS, Token: bearerToken(r)}
+		if creds.TLS == nil {
+			creds.TLS, _ = r.Context().Value(connStateContextKey{}).(*tls.ConnectionState)
+		}
+		id, err := authenticator.Authenticate(creds)
+		if err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		ctx := auth.NewContext(r.Context(), id)
+		ctx = context.WithValue(ctx, httpRequestContextKey{}, httpRequestInfo{
+			method:     r.Method + " " + r.URL.Path,
+			remoteAddr: r.RemoteAddr,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type httpRequestContextKey struct{}
+
+// HTTP 핸들러는 grpcServer 메서드를 직접 부르므로 감사 로그에 남길 요청 정보를 context 에 넣어둔다.
+type httpRequestInfo struct {
+	method     string
+	remoteAddr string
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+		return h[len(prefix):]
+	}
+	if r.URL.Path == webSocketPath {
+		return r.URL.Query().Get("access_token")
+	}
+	return ""
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/auth"
+	"github.com/jhkim988/proglog/internal/config"
+	"github.com/jhkim988/proglog/internal/log"
+	"github.com/jhkim988/proglog/internal/quota"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestHTTPServer(t *testing.T) {
+	srv, rootClient, nobodyClient := setupHTTPTest(t, nil)
+
+	// 레코드를 추가하면 201 과 오프셋을 받는다.
+	res, err := rootClient.Post(srv.URL+"/v1/records", "application/json",
+		strings.NewReader(`{"record": {"value": "aGVsbG8="}}`))
+	require.NoError(t, err)
+	produce := &api.ProduceResponse{}
+	require.Equal(t, http.StatusCreated, readHTTP(t, res, produce))
+	require.Equal(t, uint64(0), produce.Offset)
+	require.Equal(t, "/v1/records/0", res.Header.Get("Location"))
+
+	res, err = rootClient.Get(srv.URL + "/v1/records/0")
+	require.NoError(t, err)
+	consume := &api.ConsumeResponse{}
+	require.Equal(t, http.StatusOK, readHTTP(t, res, consume))
+	require.Equal(t, []byte("hello"), consume.Record.Value)
+
+	// 에러는 gRPC 상태 코드에 맞는 HTTP 상태 코드로 응답한다.
+	for _, tc := range []struct {
+		client *http.Client
+		method string
+		path   string
+		body   string
+		code   int
+		status string
+	}{
+		{rootClient, http.MethodGet, "/v1/records/1", "", http.StatusNotFound, "NotFound"},
+		{rootClient, http.MethodGet, "/v1/records/0?max_wait=oops", "", http.StatusBadRequest, "InvalidArgument"},
+		{rootClient, http.MethodPost, "/v1/records", `{"record": `, http.StatusBadRequest, "InvalidArgument"},
+		{rootClient, http.MethodPost, "/v1/records", `{}`, http.StatusBadRequest, "InvalidArgument"},
+		{rootClient, http.MethodGet, "/v1/servers", "", http.StatusNotImplemented, "Unimplemented"},
+		{nobodyClient, http.MethodGet, "/v1/records/0", "", http.StatusForbidden, "PermissionDenied"},
+	} {
+		t.Run(fmt.Sprintf("%s %s", tc.method, tc.path), func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			res, err := tc.client.Do(req)
+			require.NoError(t, err)
+			defer res.Body.Close()
+			require.Equal(t, tc.code, res.StatusCode)
+			var body httpErrorBody
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			require.Equal(t, tc.status, body.Code)
+		})
+	}
+}
+
+func TestHTTPServerSentEvents(t *testing.T) {
+	srv, rootClient, _ := setupHTTPTest(t, nil)
+	for _, value := range []string{"Zmlyc3Q=", "c2Vjb25k", "dGhpcmQ="} {
+		res, err := rootClient.Post(srv.URL+"/v1/records", "application/json",
+			strings.NewReader(fmt.Sprintf(`{"record": {"value": %q}}`, value)))
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/v1/records?offset=1", nil)
+	require.NoError(t, err)
+	res, err := rootClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	// 이벤트 id 는 레코드의 오프셋이다.
+	scanner := bufio.NewScanner(res.Body)
+	var ids []string
+	var values []string
+	for len(values) < 2 && scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			record := &api.Record{}
+			require.NoError(t, protojson.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), record))
+			values = append(values, string(record.Value))
+		}
+	}
+	require.Equal(t, []string{"1", "2"}, ids)
+	require.Equal(t, []string{"second", "third"}, values)
+}
+
+func TestHTTPQuota(t *testing.T) {
+	srv, rootClient, _ := setupHTTPTest(t, func(c *Config) {
+		c.Quotas = quota.New(quota.Config{Default: quota.Limits{RequestsPerSecond: 1}})
+	})
+	res, err := rootClient.Get(srv.URL + "/v1/records/0")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// 할당량을 넘으면 429 와 Retry-After 로 응답한다.
+	res, err = rootClient.Get(srv.URL + "/v1/records/0")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	require.Equal(t, "1", res.Header.Get("Retry-After"))
+}
+
+func TestHTTPProduceOnFollower(t *testing.T) {
+	srv, rootClient, _ := setupHTTPTest(t, func(c *Config) {
+		c.CommitLog = followerLog{c.CommitLog}
+	})
+
+	// 팔로워는 다시 보낼 수 있는 503 과 리더의 주소로 응답한다.
+	res, err := rootClient.Post(srv.URL+"/v1/records", "application/json",
+		strings.NewReader(`{"record": {"value": "aGVsbG8="}}`))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	var body httpErrorBody
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.Equal(t, "Unavailable", body.Code)
+	require.Equal(t, "127.0.0.1:8400", body.Leader)
+}
+
+// 리더가 아닌 노드의 DistributedLog 처럼 추가 요청을 거절한다.
+type followerLog struct {
+	CommitLog
+}
+
+func (l followerLog) Append(*api.Record) (uint64, error) {
+	return 0, api.ErrNotLeader{Leader: "127.0.0.1:8400"}
+}
+
+func setupHTTPTest(t *testing.T, fn func(*Config)) (srv *httptest.Server, rootClient, nobodyClient *http.Client) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "http-test")
+	require.NoError(t, err)
+	clog, err := log.NewLog(dir, log.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() { clog.Remove() })
+
+	serverTLSConfig, err := config.SetupTLSConfig(config.TLSConfig{
+		CertFile:      config.ServerCertFile,
+		KeyFile:       config.ServerKeyFile,
+		CAFile:        config.CAFile,
+		ServerAddress: "127.0.0.1",
+		Server:        true,
+	})
+	require.NoError(t, err)
+
+	cfg := &Config{
+		CommitLog:  clog,
+		Authorizer: auth.New(config.ACLModelFile, config.ACLPolicyFile),
+	}
+	if fn != nil {
+		fn(cfg)
+	}
+	srv = httptest.NewUnstartedServer(NewHTTPServer(cfg).Handler)
+	srv.TLS = serverTLSConfig
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+
+	newClient := func(crtPath, keyPath string) *http.Client {
+		tlsConfig, err := config.SetupTLSConfig(config.TLSConfig{
+			CertFile:      crtPath,
+			KeyFile:       keyPath,
+			CAFile:        config.CAFile,
+			ServerAddress: "127.0.0.1",
+		})
+		require.NoError(t, err)
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+	return srv, newClient(config.RootClientCertFile, config.RootClientKeyFile),
+		newClient(config.NobodyClientCertFile, config.NobodyClientKeyFile)
+}
+
+// 응답 본문을 msg 로 읽고 상태 코드를 리턴한다.
+func readHTTP(t *testing.T, res *http.Response, msg proto.Message) int {
+	t.Helper()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, protojson.Unmarshal(body, msg), string(body))
+	return res.StatusCode
+}
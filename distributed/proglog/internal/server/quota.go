@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	api "github.com/jhkim988/proglog/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+인증한 subject 별 할당량, quota.Limiter 가 구현한다.
+단건 요청은 할당량을 넘으면 ResourceExhausted 로 거절하고, 스트림은 할당량이 찰 때까지 기다린다.
+*/
+type Quotas interface {
+	Request(subject string) error
+	Produce(subject string, bytes int) error
+	// 이전에 읽은 만큼의 할당량이 아직 남아 있지 않으면 거절한다.
+	Consume(subject string) error
+	Consumed(subject string, bytes int)
+	WaitProduce(ctx context.Context, subject string, bytes int) error
+	WaitConsume(ctx context.Context, subject string, bytes int) error
+}
+
+// Admin 서비스는 제한하지 않는다. 할당량을 다 쓴 관리자도 클러스터를 고칠 수 있어야 한다.
+func limitedMethod(fullMethod string) bool {
+	return strings.HasPrefix(fullMethod, "/"+api.Log_ServiceDesc.ServiceName+"/")
+}
+
+// 요청을 처리하기 전에 요청 수와 produce 바이트, consume 빚을 확인한다.
+func admitQuota(ctx context.Context, q Quotas, req interface{}) error {
+	if q == nil {
+		return nil
+	}
+	sub := subject(ctx)
+	if err := q.Request(sub); err != nil {
+		return err
+	}
+	switch req := req.(type) {
+	case *api.ProduceRequest:
+		return q.Produce(sub, proto.Size(req.Record))
+	case *api.AppendTxnRequest:
+		return q.Produce(sub, proto.Size(req.Record))
+	case *api.ConsumeRequest:
+		return q.Consume(sub)
+	}
+	return nil
+}
+
+// 읽은 레코드 크기를 consume 할당량에서 차감한다.
+func chargeQuota(ctx context.Context, q Quotas, res interface{}) {
+	if q == nil {
+		return
+	}
+	if res, ok := res.(*api.ConsumeResponse); ok {
+		q.Consumed(subject(ctx), proto.Size(res))
+	}
+}
+
+func quotaUnaryInterceptor(q Quotas) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !limitedMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if err := admitQuota(ctx, q, req); err != nil {
+			return nil, err
+		}
+		res, err := handler(ctx, req)
+		if err == nil {
+			chargeQuota(ctx, q, res)
+		}
+		return res, err
+	}
+}
+
+func quotaStreamInterceptor(q Quotas) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !limitedMethod(info.FullMethod) {
+			return handler(srv, stream)
+		}
+		ctx := stream.Context()
+		if err := q.Request(subject(ctx)); err != nil {
+			return err
+		}
+		return handler(srv, &quotaServerStream{ServerStream: stream, quotas: q, subject: subject(ctx)})
+	}
+}
+
+// 받은 레코드와 보낼 레코드의 크기만큼 할당량을 기다린다.
+type quotaServerStream struct {
+	grpc.ServerStream
+	quotas  Quotas
+	subject string
+}
+
+func (s *quotaServerStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if req, ok := m.(*api.ProduceRequest); ok {
+		return s.quotas.WaitProduce(s.Context(), s.subject, proto.Size(req.Record))
+	}
+	return nil
+}
+
+func (s *quotaServerStream) SendMsg(m interface{}) error {
+	if res, ok := m.(*api.ConsumeResponse); ok {
+		if err := s.quotas.WaitConsume(s.Context(), s.subject, proto.Size(res)); err != nil {
+			return err
+		}
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+// HTTP 스트림은 인터셉터를 거치지 않으므로 ConsumeStream 에 넘기는 스트림을 감싼다.
+type quotaConsumeStream struct {
+	api.Log_ConsumeStreamServer
+	quotas  Quotas
+	subject string
+}
+
+func withConsumeQuota(q Quotas, stream api.Log_ConsumeStreamServer) api.Log_ConsumeStreamServer {
+	if q == nil {
+		return stream
+	}
+	return &quotaConsumeStream{Log_ConsumeStreamServer: stream, quotas: q, subject: subject(stream.Context())}
+}
+
+func (s *quotaConsumeStream) Send(res *api.ConsumeResponse) error {
+	if err := s.quotas.WaitConsume(s.Context(), s.subject, proto.Size(res)); err != nil {
+		return err
+	}
+	return s.Log_ConsumeStreamServer.Send(res)
+}
@@ -9,9 +9,10 @@ import (
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	grpc_ctxtags "github.com/grpc-ecosystem/go-grpc-middleware/tags"
 	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/audit"
+	"github.com/jhkim988/proglog/internal/auth"
 	"go.opencensus.io/plugin/ocgrpc"
 	"go.opencensus.io/stats/view"
-	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"google.golang.org/grpc"
@@ -19,37 +20,128 @@ import (
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 type Config struct {
-	CommitLog   CommitLog
-	Authorizer  Authorizer
-	GetServerer GetServerer
+	CommitLog      CommitLog
+	Authorizer     Authorizer
+	GetServerer    GetServerer
+	Administrator  Administrator
+	ClusterWatcher ClusterWatcher
+	Transactor     Transactor
+	Drainer        *Drainer
+	// 요청의 subject 를 확인한다. nil 이면 클라이언트 인증서로만 인증하고 토큰은 받지 않는다.
+	Authenticator auth.Authenticator
+	// 있으면 produce/consume/admin 인가 결정을 모두 기록한다.
+	Auditor Auditor
+	// 있으면 Log 서비스 요청을 subject 별 할당량으로 제한한다.
+	Quotas Quotas
+	// 있으면 gRPC health 서비스와 HTTP /healthz, /readyz 로 노드 상태를 알려준다.
+	Health HealthChecker
+	// ConsumeStream 응답 하나에 담는 최대 바이트, 스트림마다 이만큼까지 레코드를 모아둔다. 기본값 1MiB
+	MaxConsumeBytes int
 }
 
 type Authorizer interface {
 	Authorize(subject, object, action string) error
 }
 
+// 인가 결정을 기록한다. audit.Logger 가 구현한다.
+type Auditor interface {
+	Audit(audit.Event)
+}
+
 type CommitLog interface {
 	Append(*api.Record) (uint64, error)
 	Read(uint64) (*api.Record, error)
 }
 
+// CommitLog 이 구현하면 producer_id 와 sequence 로 다시 보낸 요청을 걸러낸다.
+type IdempotentCommitLog interface {
+	AppendIdempotent(record *api.Record, producerID string, sequence uint64) (uint64, error)
+}
+
+// CommitLog 이 구현하면 요청의 trace 를 이어서 레코드를 추가한다. producerID 가 비어 있으면 다시 보낸 요청을 걸러내지 않는다.
+type ContextCommitLog interface {
+	AppendContext(ctx context.Context, record *api.Record, producerID string, sequence uint64) (uint64, error)
+}
+
+// CommitLog 이 구현하면 새 레코드가 추가될 때까지 기다린다. 구현하지 않으면 pollInterval 마다 다시 읽는다.
+type Notifier interface {
+	Changed() <-chan struct{}
+}
+
+// CommitLog 이 구현하면 GetOffsets 로 로그의 오프셋 범위를 알려준다.
+type OffsetReporter interface {
+	LowestOffset() (uint64, error)
+	HighestOffset() (uint64, error)
+}
+
+// Authorizer 가 구현하면 ReloadACL 로 정책을 다시 읽는다.
+type PolicyReloader interface {
+	Reload() error
+}
+
+// CommitLog 이 구현하면 read_committed 요청에 사용한다.
+// 구현하지 않는 로그에는 트랜잭션이 없으므로 Read 로 읽어도 같다.
+type CommittedReader interface {
+	ReadCommitted(uint64) (*api.Record, error)
+}
+
+// 트랜잭션 API, DistributedLog 가 구현한다.
+type Transactor interface {
+	BeginTxn(ctx context.Context) (uint64, error)
+	AppendTxn(ctx context.Context, txnID uint64, record *api.Record) (uint64, error)
+	CommitTxn(ctx context.Context, txnID uint64) (uint64, error)
+	AbortTxn(ctx context.Context, txnID uint64) (uint64, error)
+}
+
 type GetServerer interface {
 	GetServers() ([]*api.Server, error)
 }
 
+// 클러스터 이벤트를 구독한다. 리턴한 함수로 구독을 취소한다.
+type ClusterWatcher interface {
+	WatchCluster() (<-chan *api.ClusterEvent, func())
+}
+
+// 클러스터 설정을 바꾸는 관리용 API, DistributedLog 가 구현한다.
+type Administrator interface {
+	AddServer(id, addr string, voter bool) error
+	RemoveServer(id string) error
+	TransferLeadership(id, addr string) error
+	Stats() map[string]string
+	Snapshot() error
+}
+
 var _ api.LogServer = (*grpcServer)(nil)
+var _ api.AdminServer = (*grpcServer)(nil)
 
 const (
 	objectWildcard = "*"
 	produceAction  = "produce"
 	consumeAction  = "consume"
+	adminAction    = "admin"
+)
+
+// 토픽이 없는 레코드는 모든 토픽에 권한이 있어야 다룰 수 있다.
+func topicObject(topic string) string {
+	if topic == "" {
+		return objectWildcard
+	}
+	return topic
+}
+
+const (
+	pollInterval             = 100 * time.Millisecond
+	defaultMaxConsumeBytes   = 1 << 20
+	defaultMaxConsumeRecords = 500
 )
 
 type grpcServer struct {
 	api.UnimplementedLogServer
+	api.UnimplementedAdminServer
 	*Config
 }
 
@@ -61,27 +153,76 @@ func newgrpcServer(config *Config) (srv *grpcServer, err error) {
 }
 
 func (s *grpcServer) Produce(ctx context.Context, req *api.ProduceRequest) (*api.ProduceResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, produceAction); err != nil {
+	if err := s.authorize(ctx, topicObject(req.Record.GetTopic()), produceAction); err != nil {
 		return nil, err
 	}
 
-	offset, err := s.CommitLog.Append(req.Record)
+	var offset uint64
+	var err error
+	if clog, ok := s.CommitLog.(ContextCommitLog); ok {
+		offset, err = clog.AppendContext(ctx, req.Record, req.ProducerId, req.Sequence)
+	} else if clog, ok := s.CommitLog.(IdempotentCommitLog); ok && req.ProducerId != "" {
+		offset, err = clog.AppendIdempotent(req.Record, req.ProducerId, req.Sequence)
+	} else {
+		offset, err = s.CommitLog.Append(req.Record)
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &api.ProduceResponse{Offset: offset}, nil
 }
 
+// max_wait 가 있으면 레코드가 추가될 때까지 기다린다. (long polling)
+// topic 이 있으면 그 토픽의 레코드만 읽을 수 있다.
 func (s *grpcServer) Consume(ctx context.Context, req *api.ConsumeRequest) (*api.ConsumeResponse, error) {
-	if err := s.Authorizer.Authorize(subject(ctx), objectWildcard, consumeAction); err != nil {
+	if err := s.authorize(ctx, topicObject(req.Topic), consumeAction); err != nil {
 		return nil, err
 	}
 
-	record, err := s.CommitLog.Read(req.Offset)
-	if err != nil {
-		return nil, err
+	var deadline <-chan time.Time
+	if d := req.MaxWait.AsDuration(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		changed := s.changed()
+		record, err := s.read(req)
+		if _, ok := err.(api.ErrOffsetOutOfRange); !ok || deadline == nil {
+			if err != nil {
+				return nil, err
+			}
+			if req.Topic != "" && record.Topic != req.Topic {
+				return nil, status.Errorf(codes.NotFound, "record %d is not in topic %q", record.Offset, req.Topic)
+			}
+			return &api.ConsumeResponse{Record: record}, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-deadline:
+			return nil, err
+		case <-changed:
+		}
 	}
-	return &api.ConsumeResponse{Record: record}, nil
+}
+
+func (s *grpcServer) read(req *api.ConsumeRequest) (*api.Record, error) {
+	if clog, ok := s.CommitLog.(CommittedReader); ok && req.ReadCommitted {
+		return clog.ReadCommitted(req.Offset)
+	}
+	return s.CommitLog.Read(req.Offset)
+}
+
+// 로그가 바뀌면 닫히는 채널, 읽기 전에 받아둔다.
+func (s *grpcServer) changed() <-chan struct{} {
+	if n, ok := s.CommitLog.(Notifier); ok {
+		return n.Changed()
+	}
+	ch := make(chan struct{})
+	time.AfterFunc(pollInterval, func() { close(ch) })
+	return ch
 }
 
 func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
@@ -101,28 +242,193 @@ func (s *grpcServer) ProduceStream(stream api.Log_ProduceStreamServer) error {
 	}
 }
 
+/*
+읽을 수 있는 레코드를 max_records 개, max_bytes 까지 모아 응답 하나로 보낸다.
+읽을 레코드가 없으면 로그에 레코드가 추가될 때까지 기다린다.
+min_records 개가 모이지 않았으면 더 기다리고, 첫 레코드를 받은 뒤 max_wait 가 지나면 모은 레코드를 보낸다.
+heartbeat_interval 이 있으면 기다리는 동안 레코드가 없는 응답을 보낸다.
+
+클라이언트가 느리면 gRPC 흐름 제어로 Send 가 막히고 그동안 로그를 더 읽지 않으므로,
+스트림 하나가 잡고 있는 메모리는 응답 하나 크기로 제한된다.
+*/
 func (s *grpcServer) ConsumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	if err := s.authorize(stream.Context(), topicObject(req.Topic), consumeAction); err != nil {
+		return err
+	}
+	return s.consumeStream(req, stream)
+}
+
+// 권한을 이미 확인한 스트림, WebSocket 은 연결을 올리기 전에 확인하므로 감사 로그를 두 번 남기지 않는다.
+func (s *grpcServer) consumeStream(req *api.ConsumeRequest, stream api.Log_ConsumeStreamServer) error {
+	ctx := stream.Context()
+
+	var heartbeat <-chan time.Time
+	if d := req.HeartbeatInterval.AsDuration(); d > 0 {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	maxBytes := s.maxConsumeBytes()
+	if req.MaxBytes > 0 && int(req.MaxBytes) < maxBytes {
+		maxBytes = int(req.MaxBytes)
+	}
+	maxRecords := defaultMaxConsumeRecords
+	if req.MaxRecords > 0 {
+		maxRecords = int(req.MaxRecords)
+	}
+	minRecords := int(req.MinRecords)
+	if minRecords < 1 {
+		minRecords = 1
+	}
+	if minRecords > maxRecords {
+		minRecords = maxRecords
+	}
+	maxWait := req.MaxWait.AsDuration()
+
+	var pending []*api.Record
+	var pendingBytes int
+	var flushTimer <-chan time.Time
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := stream.Send(&api.ConsumeResponse{Records: pending}); err != nil {
+			return err
+		}
+		pending = nil
+		pendingBytes = 0
+		flushTimer = nil
+		return nil
+	}
+
 	for {
+		changed := s.changed()
+		record, err := s.read(req)
+		switch err.(type) {
+		case nil:
+			if req.Topic != "" && record.Topic != req.Topic {
+				req.Offset = record.Offset + 1
+				continue
+			}
+			size := proto.Size(record)
+			if len(pending) > 0 && pendingBytes+size > maxBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if len(pending) == 0 && maxWait > 0 {
+				flushTimer = time.After(maxWait)
+			}
+			pending = append(pending, record)
+			pendingBytes += size
+			// read_committed 면 건너뛴 레코드가 있을 수 있으므로 받은 레코드 다음부터 읽는다.
+			req.Offset = record.Offset + 1
+			if len(pending) >= maxRecords || pendingBytes >= maxBytes {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		case api.ErrOffsetOutOfRange:
+			if len(pending) >= minRecords {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		default:
+			return err
+		}
+
 		select {
-		case <-stream.Context().Done():
+		case <-ctx.Done():
 			return nil
-		default:
-			res, err := s.Consume(stream.Context(), req)
-			switch err.(type) {
-			case nil:
-			case api.ErrOffsetOutOfRange:
-				continue
-			default:
+		case <-s.Drainer.Draining():
+			// 모은 레코드는 보내고 끝낸다. 클라이언트는 다음 오프셋부터 다른 노드에 다시 연결한다.
+			if err := flush(); err != nil {
+				return err
+			}
+			return status.Error(codes.Unavailable, "server is draining")
+		case <-changed:
+		case <-flushTimer:
+			if err := flush(); err != nil {
 				return err
 			}
-			if err = stream.Send(res); err != nil {
+		case <-heartbeat:
+			if len(pending) > 0 {
+				continue
+			}
+			if err := stream.Send(&api.ConsumeResponse{}); err != nil {
 				return err
 			}
-			req.Offset++
 		}
 	}
 }
 
+func (s *grpcServer) maxConsumeBytes() int {
+	if s.MaxConsumeBytes > 0 {
+		return s.MaxConsumeBytes
+	}
+	return defaultMaxConsumeBytes
+}
+
+/*
+트랜잭션 요청은 produce 권한을 확인한다.
+트랜잭션은 토픽에 묶이지 않으므로 시작하고 끝내려면 모든 토픽에 권한이 있어야 하고, 레코드는 그 토픽의 권한을 확인한다.
+*/
+func (s *grpcServer) BeginTxn(ctx context.Context, req *api.BeginTxnRequest) (*api.BeginTxnResponse, error) {
+	if err := s.authorizeTxn(ctx, objectWildcard); err != nil {
+		return nil, err
+	}
+	txnID, err := s.Transactor.BeginTxn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &api.BeginTxnResponse{TxnId: txnID}, nil
+}
+
+func (s *grpcServer) AppendTxn(ctx context.Context, req *api.AppendTxnRequest) (*api.AppendTxnResponse, error) {
+	if err := s.authorizeTxn(ctx, topicObject(req.Record.GetTopic())); err != nil {
+		return nil, err
+	}
+	offset, err := s.Transactor.AppendTxn(ctx, req.TxnId, req.Record)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AppendTxnResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) CommitTxn(ctx context.Context, req *api.CommitTxnRequest) (*api.CommitTxnResponse, error) {
+	if err := s.authorizeTxn(ctx, objectWildcard); err != nil {
+		return nil, err
+	}
+	offset, err := s.Transactor.CommitTxn(ctx, req.TxnId)
+	if err != nil {
+		return nil, err
+	}
+	return &api.CommitTxnResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) AbortTxn(ctx context.Context, req *api.AbortTxnRequest) (*api.AbortTxnResponse, error) {
+	if err := s.authorizeTxn(ctx, objectWildcard); err != nil {
+		return nil, err
+	}
+	offset, err := s.Transactor.AbortTxn(ctx, req.TxnId)
+	if err != nil {
+		return nil, err
+	}
+	return &api.AbortTxnResponse{Offset: offset}, nil
+}
+
+func (s *grpcServer) authorizeTxn(ctx context.Context, object string) error {
+	if err := s.authorize(ctx, object, produceAction); err != nil {
+		return err
+	}
+	if s.Transactor == nil {
+		return status.Error(codes.Unimplemented, "transactions are not supported by this server")
+	}
+	return nil
+}
+
 func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
 	servers, err := s.GetServerer.GetServers()
 	if err != nil {
@@ -131,6 +437,128 @@ func (s *grpcServer) GetServers(ctx context.Context, req *api.GetServersRequest)
 	return &api.GetServersResponse{Servers: servers}, nil
 }
 
+func (s *grpcServer) GetOffsets(ctx context.Context, req *api.GetOffsetsRequest) (*api.GetOffsetsResponse, error) {
+	if err := s.authorize(ctx, topicObject(req.Topic), consumeAction); err != nil {
+		return nil, err
+	}
+	offsets, ok := s.CommitLog.(OffsetReporter)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "offsets are not supported by this server")
+	}
+	lowest, err := offsets.LowestOffset()
+	if err != nil {
+		return nil, err
+	}
+	highest, err := offsets.HighestOffset()
+	if err != nil {
+		return nil, err
+	}
+	/* HighestOffset 은 비어 있는 로그에도 0 을 리턴하므로 읽어서 확인한다. */
+	next := highest + 1
+	if highest == 0 {
+		if _, err := s.CommitLog.Read(0); err != nil {
+			next = lowest
+		}
+	}
+	return &api.GetOffsetsResponse{LowestOffset: lowest, NextOffset: next}, nil
+}
+
+func (s *grpcServer) WatchCluster(req *api.WatchClusterRequest, stream api.Log_WatchClusterServer) error {
+	if s.ClusterWatcher == nil {
+		return status.Error(codes.Unimplemented, "cluster events are not supported by this server")
+	}
+
+	events, cancel := s.ClusterWatcher.WatchCluster()
+	defer cancel()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.Drainer.Draining():
+			return status.Error(codes.Unavailable, "server is draining")
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+/* Admin 서비스, 모든 메서드는 admin 권한을 확인한다. */
+func (s *grpcServer) AddServer(ctx context.Context, req *api.AddServerRequest) (*api.AddServerResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Administrator.AddServer(req.Id, req.RpcAddr, !req.NonVoter); err != nil {
+		return nil, err
+	}
+	return &api.AddServerResponse{}, nil
+}
+
+func (s *grpcServer) RemoveServer(ctx context.Context, req *api.RemoveServerRequest) (*api.RemoveServerResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Administrator.RemoveServer(req.Id); err != nil {
+		return nil, err
+	}
+	return &api.RemoveServerResponse{}, nil
+}
+
+func (s *grpcServer) TransferLeadership(ctx context.Context, req *api.TransferLeadershipRequest) (*api.TransferLeadershipResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Administrator.TransferLeadership(req.Id, req.RpcAddr); err != nil {
+		return nil, err
+	}
+	return &api.TransferLeadershipResponse{}, nil
+}
+
+func (s *grpcServer) GetRaftStats(ctx context.Context, req *api.GetRaftStatsRequest) (*api.GetRaftStatsResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return &api.GetRaftStatsResponse{Stats: s.Administrator.Stats()}, nil
+}
+
+func (s *grpcServer) TriggerSnapshot(ctx context.Context, req *api.TriggerSnapshotRequest) (*api.TriggerSnapshotResponse, error) {
+	if err := s.authorizeAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.Administrator.Snapshot(); err != nil {
+		return nil, err
+	}
+	return &api.TriggerSnapshotResponse{}, nil
+}
+
+func (s *grpcServer) ReloadACL(ctx context.Context, req *api.ReloadACLRequest) (*api.ReloadACLResponse, error) {
+	if err := s.authorize(ctx, objectWildcard, adminAction); err != nil {
+		return nil, err
+	}
+	reloader, ok := s.Authorizer.(PolicyReloader)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "acl reload is not supported by this server")
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &api.ReloadACLResponse{}, nil
+}
+
+func (s *grpcServer) authorizeAdmin(ctx context.Context) error {
+	if err := s.authorize(ctx, objectWildcard, adminAction); err != nil {
+		return err
+	}
+	if s.Administrator == nil {
+		return status.Error(codes.Unimplemented, "cluster administration is not supported by this server")
+	}
+	return nil
+}
+
 func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, error) {
 	logger := zap.L().Named("server")
 	zapOpts := []grpc_zap.Option{
@@ -144,26 +572,37 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		),
 	}
 
-	trace.ApplyConfig(trace.Config{
-		/* 모든 요청을 트레이싱 한다. */
-		DefaultSampler: trace.AlwaysSample(),
-	})
+	/* 샘플링과 exporter 는 프로세스 전체 설정이므로 tracing.Setup 으로 정한다. */
 	err := view.Register(ocgrpc.DefaultServerViews...)
 	if err != nil {
 		return nil, err
 	}
 
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		grpc_ctxtags.StreamServerInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zapOpts...),
+	}
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		grpc_ctxtags.UnaryServerInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
+	}
+	/* drain 중이면 인증하기 전에 거절한다. */
+	if config.Drainer != nil {
+		streamInterceptors = append(streamInterceptors, config.Drainer.StreamServerInterceptor())
+		unaryInterceptors = append(unaryInterceptors, config.Drainer.UnaryServerInterceptor())
+	}
+	authenticate := authenticateGRPC(config.authenticator())
+	streamInterceptors = append(streamInterceptors, grpc_auth.StreamServerInterceptor(authenticate))
+	unaryInterceptors = append(unaryInterceptors, grpc_auth.UnaryServerInterceptor(authenticate))
+	/* 할당량은 인증한 subject 별로 센다. */
+	if config.Quotas != nil {
+		streamInterceptors = append(streamInterceptors, quotaStreamInterceptor(config.Quotas))
+		unaryInterceptors = append(unaryInterceptors, quotaUnaryInterceptor(config.Quotas))
+	}
+
 	opts = append(opts,
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_zap.StreamServerInterceptor(logger, zapOpts...),
-			grpc_auth.StreamServerInterceptor(authenticate)),
-		),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
-			grpc_auth.UnaryServerInterceptor(authenticate),
-		)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(streamInterceptors...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unaryInterceptors...)),
 		grpc.StatsHandler(&ocgrpc.ServerHandler{}),
 	)
 
@@ -173,27 +612,80 @@ func NewGRPCServer(config *Config, opts ...grpc.ServerOption) (*grpc.Server, err
 		return nil, err
 	}
 	api.RegisterLogServer(gsrv, srv)
+	api.RegisterAdminServer(gsrv, srv)
+	if config.Health != nil {
+		registerHealthServer(gsrv, config)
+	}
 	return gsrv, nil
 }
 
-type subjectContextKey struct{}
+func (c *Config) authenticator() auth.Authenticator {
+	if c.Authenticator != nil {
+		return c.Authenticator
+	}
+	return auth.Chain(auth.MTLS())
+}
 
-func authenticate(ctx context.Context) (context.Context, error) {
-	peer, ok := peer.FromContext(ctx)
-	if !ok {
-		return ctx, status.New(codes.Unknown, "couldn't find peer info").Err()
+/* 연결의 TLS 상태와 authorization 메타데이터의 bearer 토큰으로 인증한다. */
+func authenticateGRPC(authenticator auth.Authenticator) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		peer, ok := peer.FromContext(ctx)
+		if !ok {
+			return ctx, status.New(codes.Unknown, "couldn't find peer info").Err()
+		}
+
+		var creds auth.Credentials
+		if tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo); ok {
+			creds.TLS = &tlsInfo.State
+		}
+		if token, err := grpc_auth.AuthFromMD(ctx, "bearer"); err == nil {
+			creds.Token = token
+		}
+		id, err := authenticator.Authenticate(creds)
+		if err != nil {
+			return ctx, err
+		}
+		return auth.NewContext(ctx, id), nil
 	}
+}
 
-	if peer.AuthInfo == nil {
-		return context.WithValue(ctx, subjectContextKey{}, ""), nil
+/* Authorizer 로 권한을 확인하고, Auditor 가 있으면 결정을 요청한 주소, 메서드와 함께 기록한다. */
+func (s *grpcServer) authorize(ctx context.Context, object, action string) error {
+	sub := subject(ctx)
+	err := s.Authorizer.Authorize(sub, object, action)
+	if s.Auditor == nil {
+		return err
+	}
+	event := audit.Event{
+		Subject: sub,
+		Action:  action,
+		Object:  object,
+		Allowed: err == nil,
+	}
+	if err != nil {
+		event.Reason = status.Convert(err).Message()
 	}
+	event.Method, event.Peer = requestInfo(ctx)
+	s.Auditor.Audit(event)
+	return err
+}
 
-	tlsInfo := peer.AuthInfo.(credentials.TLSInfo)
-	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
-	ctx = context.WithValue(ctx, subjectContextKey{}, subject)
-	return ctx, nil
+// gRPC 요청이면 메서드 이름과 peer 주소를, HTTP 요청이면 "메서드 경로" 와 원격 주소를 리턴한다.
+func requestInfo(ctx context.Context) (method, addr string) {
+	if info, ok := ctx.Value(httpRequestContextKey{}).(httpRequestInfo); ok {
+		return info.method, info.remoteAddr
+	}
+	method, _ = grpc.Method(ctx)
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	return method, addr
 }
 
 func subject(ctx context.Context) string {
-	return ctx.Value(subjectContextKey{}).(string)
+	id, ok := auth.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return id.Subject
 }
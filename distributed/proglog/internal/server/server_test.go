@@ -2,23 +2,36 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
 	"flag"
+	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/audit"
 	"github.com/jhkim988/proglog/internal/auth"
 	"github.com/jhkim988/proglog/internal/config"
 	"github.com/jhkim988/proglog/internal/log"
+	"github.com/jhkim988/proglog/internal/quota"
 	"github.com/stretchr/testify/require"
 	"go.opencensus.io/examples/exporter"
+	"go.opencensus.io/trace"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
 )
 
 var debug = flag.Bool("debug", false, "Enable observability for debugging.")
@@ -40,6 +53,10 @@ func TestServer(t *testing.T) {
 		"produce/consume a message to/from the log succeeds": testProduceConsume,
 		"produce/consume stream succeeds":                    testProduceConsumeStream,
 		"consume past log boundary fails":                    testConsumePastBoundary,
+		"consume with max_wait waits for a record":           testConsumeLongPoll,
+		"consume stream sends heartbeats":                    testConsumeStreamHeartbeat,
+		"consume stream batches records":                     testConsumeStreamBatches,
+		"get offsets reports the log range":                  testGetOffsets,
 	} {
 		t.Run(senario, func(t *testing.T) {
 			_, rootClient, _, config, teardown := setupTest(t, nil)
@@ -55,6 +72,57 @@ func TestServer(t *testing.T) {
 		defer teardown()
 		testUnauthorized(t, client, config)
 	})
+
+	t.Run("transactions without a transactor are unimplemented", func(t *testing.T) {
+		_, rootClient, _, config, teardown := setupTest(t, nil)
+		defer teardown()
+		testTxnUnimplemented(t, rootClient, config)
+	})
+
+	t.Run("cert and token clients share a server", func(t *testing.T) {
+		authenticator, tokens := setupAuthenticator(t)
+		client, rootClient, nobodyClient, config, teardown := setupTest(t, func(c *Config) {
+			c.Authenticator = authenticator
+		})
+		defer teardown()
+		testMixedAuthentication(t, client, rootClient, nobodyClient, tokens, config)
+	})
+
+	t.Run("topic permissions", func(t *testing.T) {
+		_, rootClient, nobodyClient, config, teardown := setupTest(t, func(c *Config) {
+			c.Authenticator = auth.Chain(auth.NewAPIKeysFromMap(map[string]string{"alice-key": "alice"}), auth.MTLS())
+		})
+		defer teardown()
+		testTopicPermissions(t, rootClient, nobodyClient, config)
+	})
+
+	t.Run("authorization decisions are audited", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		_, rootClient, nobodyClient, config, teardown := setupTest(t, func(c *Config) {
+			c.Auditor = auditor
+		})
+		defer teardown()
+		testAudit(t, rootClient, nobodyClient, auditor, config)
+	})
+
+	t.Run("quotas limit each subject", func(t *testing.T) {
+		_, rootClient, nobodyClient, config, teardown := setupTest(t, func(c *Config) {
+			c.Quotas = quota.New(quota.Config{
+				Default:  quota.Limits{RequestsPerSecond: 1},
+				Subjects: map[string]quota.Limits{"root": {RequestsPerSecond: 2, ProduceBytesPerSecond: 64}},
+			})
+		})
+		defer teardown()
+		testQuotas(t, rootClient, nobodyClient, config)
+	})
+
+	t.Run("draining rejects new produce and stream calls", func(t *testing.T) {
+		_, rootClient, _, config, teardown := setupTest(t, func(c *Config) {
+			c.Drainer = NewDrainer()
+		})
+		defer teardown()
+		testDrain(t, rootClient, config)
+	})
 }
 
 func setupTest(t *testing.T, fn func(*Config)) (
@@ -84,6 +152,8 @@ func setupTest(t *testing.T, fn func(*Config)) (
 		require.NoError(t, err)
 		err = telemetryExporter.Start()
 		require.NoError(t, err)
+		/* 모든 요청을 트레이싱 한다. */
+		trace.ApplyConfig(trace.Config{DefaultSampler: trace.AlwaysSample()})
 	}
 	// server 설정
 	l, err := net.Listen("tcp", ":0")
@@ -196,6 +266,25 @@ func testProduceConsume(t *testing.T, client api.LogClient, config *Config) {
 	require.Equal(t, produce.Offset, consume.Record.Offset)
 }
 
+func testGetOffsets(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// 빈 로그는 lowest 와 next 가 같다.
+	offsets, err := client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowestOffset)
+	require.Equal(t, uint64(0), offsets.NextOffset)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+	offsets, err = client.GetOffsets(ctx, &api.GetOffsetsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), offsets.LowestOffset)
+	require.Equal(t, uint64(3), offsets.NextOffset)
+}
+
 func testConsumePastBoundary(t *testing.T, client api.LogClient, config *Config) {
 	ctx := context.Background()
 
@@ -218,6 +307,93 @@ func testConsumePastBoundary(t *testing.T, client api.LogClient, config *Config)
 	}
 }
 
+func testConsumeLongPoll(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+
+	// 기다리는 동안 레코드가 추가되면 바로 응답한다.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	}()
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{
+		Offset:  0,
+		MaxWait: durationpb.New(5 * time.Second),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), consume.Record.Value)
+
+	// max_wait 가 지나도록 레코드가 없으면 범위를 벗어났다는 에러를 준다.
+	start := time.Now()
+	_, err = client.Consume(ctx, &api.ConsumeRequest{
+		Offset:  1,
+		MaxWait: durationpb.New(100 * time.Millisecond),
+	})
+	require.Equal(t, status.Code(api.ErrOffsetOutOfRange{}.GRPCStatus().Err()), status.Code(err))
+	require.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+}
+
+func testConsumeStreamHeartbeat(t *testing.T, client api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{
+		Offset:            0,
+		MinRecords:        2,
+		MaxWait:           durationpb.New(time.Minute),
+		HeartbeatInterval: durationpb.New(20 * time.Millisecond),
+	})
+	require.NoError(t, err)
+
+	// 레코드가 없는 동안은 heartbeat 를 받는다.
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Empty(t, res.Records)
+
+	// min_records 개가 모이면 한 번에 보낸다.
+	for _, value := range []string{"first", "second"} {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte(value)}})
+		require.NoError(t, err)
+	}
+	for len(res.Records) == 0 {
+		res, err = stream.Recv()
+		require.NoError(t, err)
+	}
+	require.Len(t, res.Records, 2)
+	require.Equal(t, []byte("first"), res.Records[0].Value)
+	require.Equal(t, []byte("second"), res.Records[1].Value)
+}
+
+func testConsumeStreamBatches(t *testing.T, client api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello world")}})
+		require.NoError(t, err)
+	}
+
+	batchSizes := func(req *api.ConsumeRequest, n int) []int {
+		stream, err := client.ConsumeStream(ctx, req)
+		require.NoError(t, err)
+		var sizes []int
+		for received := 0; received < n; {
+			res, err := stream.Recv()
+			require.NoError(t, err)
+			sizes = append(sizes, len(res.Records))
+			received += len(res.Records)
+		}
+		return sizes
+	}
+
+	// 응답 하나에 max_records 개까지 담는다.
+	require.Equal(t, []int{2, 2, 1}, batchSizes(&api.ConsumeRequest{MaxRecords: 2}, 5))
+
+	// max_bytes 를 넘기면 나눠서 보낸다. 레코드 하나가 더 크면 하나씩 보낸다.
+	size := proto.Size(&api.Record{Value: []byte("hello world"), Offset: 4})
+	require.Equal(t, []int{3, 2}, batchSizes(&api.ConsumeRequest{MaxBytes: uint32(3 * size)}, 5))
+	require.Equal(t, []int{1, 1}, batchSizes(&api.ConsumeRequest{Offset: 3, MaxBytes: 1}, 2))
+}
+
 func testProduceConsumeStream(t *testing.T, client api.LogClient, config *Config) {
 	ctx := context.Background()
 	records := []*api.Record{
@@ -253,14 +429,94 @@ func testProduceConsumeStream(t *testing.T, client api.LogClient, config *Config
 		stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: 0})
 		require.NoError(t, err)
 
-		for i, record := range records {
+		var got []*api.Record
+		for len(got) < len(records) {
 			res, err := stream.Recv()
 			require.NoError(t, err)
-			require.Equal(t, res.Record, &api.Record{Value: record.Value, Offset: uint64(i)})
+			got = append(got, res.Records...)
+		}
+		for i, record := range records {
+			require.Equal(t, got[i], &api.Record{Value: record.Value, Offset: uint64(i)})
 		}
 	}
 }
 
+func testTxnUnimplemented(t *testing.T, client api.LogClient, config *Config) {
+	ctx := context.Background()
+	_, err := client.BeginTxn(ctx, &api.BeginTxnRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// 트랜잭션이 없는 로그는 read_committed 도 그대로 읽는다.
+	produce, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+	require.NoError(t, err)
+	consume, err := client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset, ReadCommitted: true})
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), consume.Record.Value)
+}
+
+func testQuotas(t *testing.T, rootClient, nobodyClient api.LogClient, config *Config) {
+	ctx := context.Background()
+	produce := func(value []byte) error {
+		_, err := rootClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: value}})
+		return err
+	}
+	requireExhausted := func(err error) {
+		t.Helper()
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+		delay, ok := quota.RetryDelay(err)
+		require.True(t, ok)
+		require.Greater(t, delay, time.Duration(0))
+	}
+
+	// 버킷보다 큰 레코드는 기다려도 받을 수 없으므로 다시 시도하지 않도록 거절한다.
+	require.Equal(t, codes.InvalidArgument, status.Code(produce(make([]byte, 100))))
+	// 요청 수는 거절한 요청까지 센다.
+	require.NoError(t, produce(make([]byte, 60)))
+	requireExhausted(produce([]byte("hello")))
+
+	// subject 마다 따로 센다.
+	_, err := nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	requireExhausted(err)
+}
+
+func testDrain(t *testing.T, client api.LogClient, config *Config) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	produce, err := client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.NoError(t, err)
+
+	/* 따라잡은 스트림은 drain 을 시작하면 재시도 가능한 상태로 끝나므로 timeout 까지 기다리지 않는다. */
+	stream, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	err = config.Drainer.Drain(time.Second)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	/* drain 을 시작한 뒤의 요청은 재시도 가능한 상태로 거절한다. */
+	_, err = client.Produce(ctx, &api.ProduceRequest{
+		Record: &api.Record{Value: []byte("hello world")},
+	})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	stream2, err := client.ConsumeStream(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+	_, err = stream2.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	/* 읽기 요청은 계속 받는다. */
+	_, err = client.Consume(ctx, &api.ConsumeRequest{Offset: produce.Offset})
+	require.NoError(t, err)
+}
+
 func testUnauthorized(t *testing.T, client api.LogClient, cnfig *Config) {
 	ctx := context.Background()
 	produce, err := client.Produce(ctx, &api.ProduceRequest{
@@ -289,3 +545,222 @@ func testUnauthorized(t *testing.T, client api.LogClient, cnfig *Config) {
 		t.Fatalf("got code : %d, want: %d", gotCode, wantCode)
 	}
 }
+
+type recordingAuditor struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (a *recordingAuditor) Audit(event audit.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+}
+
+func testAudit(t *testing.T, rootClient, nobodyClient api.LogClient, auditor *recordingAuditor, config *Config) {
+	ctx := context.Background()
+	_, err := rootClient.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello"), Topic: "orders"}})
+	require.NoError(t, err)
+	_, err = nobodyClient.Consume(ctx, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	require.Len(t, auditor.events, 2)
+
+	allowed := auditor.events[0]
+	require.True(t, allowed.Allowed)
+	require.Equal(t, "root", allowed.Subject)
+	require.Equal(t, "orders", allowed.Object)
+	require.Equal(t, "produce", allowed.Action)
+	require.Equal(t, api.Log_Produce_FullMethodName, allowed.Method)
+	require.NotEmpty(t, allowed.Peer)
+
+	denied := auditor.events[1]
+	require.False(t, denied.Allowed)
+	require.Equal(t, "nobody", denied.Subject)
+	require.Equal(t, "*", denied.Object)
+	require.Equal(t, "consume", denied.Action)
+	require.Equal(t, api.Log_Consume_FullMethodName, denied.Method)
+	require.NotEmpty(t, denied.Reason)
+}
+
+func testMixedAuthentication(t *testing.T, client, rootClient, nobodyClient api.LogClient, tokens map[string]string, config *Config) {
+	produce := func(client api.LogClient, token string) error {
+		ctx := context.Background()
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte("hello")}})
+		return err
+	}
+
+	// 인증서의 CN 으로 인증한다.
+	require.NoError(t, produce(rootClient, ""))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(nobodyClient, "")))
+
+	// 토큰이 있으면 인증서보다 토큰의 subject 를 쓴다.
+	require.NoError(t, produce(nobodyClient, tokens["api_key"]))
+	require.NoError(t, produce(client, tokens["jwt"]))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(rootClient, tokens["nobody_jwt"])))
+
+	// 모르는 토큰은 인증서가 있어도 거절한다.
+	require.Equal(t, codes.Unauthenticated, status.Code(produce(rootClient, "wrong")))
+	require.Equal(t, codes.Unauthenticated, status.Code(produce(rootClient, tokens["expired_jwt"])))
+}
+
+// API 키, JWT, 인증서 순서로 인증한다. 테스트에 쓸 토큰을 함께 리턴한다.
+func setupAuthenticator(t *testing.T) (auth.Authenticator, map[string]string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "OKP", "crv": "Ed25519", "kid": "test", "x": %q}]}`,
+		base64.RawURLEncoding.EncodeToString(pub))
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(jwks), 0600))
+	jwtAuthenticator, err := auth.NewJWT(auth.JWTConfig{JWKSFiles: []string{path}})
+	require.NoError(t, err)
+
+	sign := func(subject string, exp time.Duration) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"sub": subject,
+			"exp": time.Now().Add(exp).Unix(),
+		})
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(priv)
+		require.NoError(t, err)
+		return signed
+	}
+	tokens := map[string]string{
+		"api_key":     "root-api-key",
+		"jwt":         sign("root", time.Hour),
+		"nobody_jwt":  sign("nobody", time.Hour),
+		"expired_jwt": sign("root", -time.Hour),
+	}
+	return auth.Chain(
+		auth.NewAPIKeysFromMap(map[string]string{tokens["api_key"]: "root"}),
+		jwtAuthenticator,
+		auth.MTLS(),
+	), tokens
+}
+
+func testTopicPermissions(t *testing.T, rootClient, aliceClient api.LogClient, config *Config) {
+	ctx := context.Background()
+	// alice 는 team-a 역할로 team-a/* 토픽에만 권한이 있다. (test/policy.csv)
+	alice := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer alice-key")
+	produce := func(ctx context.Context, client api.LogClient, topic, value string) error {
+		_, err := client.Produce(ctx, &api.ProduceRequest{Record: &api.Record{Value: []byte(value), Topic: topic}})
+		return err
+	}
+
+	require.NoError(t, produce(alice, aliceClient, "team-a/orders", "first"))
+	require.NoError(t, produce(ctx, rootClient, "team-b/orders", "other"))
+	require.NoError(t, produce(ctx, rootClient, "", "untopiced"))
+	require.NoError(t, produce(alice, aliceClient, "team-a/orders", "second"))
+
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(alice, aliceClient, "team-b/orders", "x")))
+	require.Equal(t, codes.PermissionDenied, status.Code(produce(alice, aliceClient, "", "x")))
+
+	// 토픽을 정하지 않고 읽으려면 모든 토픽에 권한이 있어야 한다.
+	_, err := aliceClient.Consume(alice, &api.ConsumeRequest{Offset: 0})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	res, err := aliceClient.Consume(alice, &api.ConsumeRequest{Offset: 0, Topic: "team-a/orders"})
+	require.NoError(t, err)
+	require.Equal(t, []byte("first"), res.Record.Value)
+	_, err = aliceClient.Consume(alice, &api.ConsumeRequest{Offset: 1, Topic: "team-a/orders"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// 스트림은 다른 토픽의 레코드를 건너뛴다.
+	streamCtx, cancel := context.WithCancel(alice)
+	defer cancel()
+	stream, err := aliceClient.ConsumeStream(streamCtx, &api.ConsumeRequest{Offset: 0, Topic: "team-a/orders"})
+	require.NoError(t, err)
+	var values []string
+	for len(values) < 2 {
+		res, err := stream.Recv()
+		require.NoError(t, err)
+		for _, record := range res.Records {
+			values = append(values, string(record.Value))
+		}
+	}
+	require.Equal(t, []string{"first", "second"}, values)
+}
+
+func TestAdmin(t *testing.T) {
+	admin := &administrator{}
+	srv, err := newgrpcServer(&Config{
+		Authorizer:    auth.New(config.ACLModelFile, config.ACLPolicyFile),
+		Administrator: admin,
+	})
+	require.NoError(t, err)
+
+	/* 인증 인터셉터를 거치지 않고 subject 를 직접 넣어 호출한다. */
+	root := auth.NewContext(context.Background(), &auth.Identity{Subject: "root"})
+	nobody := auth.NewContext(context.Background(), &auth.Identity{Subject: "nobody"})
+
+	_, err = srv.AddServer(root, &api.AddServerRequest{Id: "1", RpcAddr: "localhost:9002"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, admin.servers)
+
+	_, err = srv.TransferLeadership(root, &api.TransferLeadershipRequest{Id: "1", RpcAddr: "localhost:9002"})
+	require.NoError(t, err)
+	require.Equal(t, "1", admin.leader)
+
+	stats, err := srv.GetRaftStats(root, &api.GetRaftStatsRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "Follower", stats.Stats["state"])
+
+	_, err = srv.TriggerSnapshot(root, &api.TriggerSnapshotRequest{})
+	require.NoError(t, err)
+	require.Equal(t, 1, admin.snapshots)
+
+	_, err = srv.RemoveServer(root, &api.RemoveServerRequest{Id: "1"})
+	require.NoError(t, err)
+	require.Empty(t, admin.servers)
+
+	_, err = srv.ReloadACL(root, &api.ReloadACLRequest{})
+	require.NoError(t, err)
+
+	/* admin 권한이 없으면 거절한다. */
+	_, err = srv.ReloadACL(nobody, &api.ReloadACLRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = srv.RemoveServer(nobody, &api.RemoveServerRequest{Id: "1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = srv.GetRaftStats(nobody, &api.GetRaftStatsRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// Mock
+type administrator struct {
+	servers   []string
+	leader    string
+	snapshots int
+}
+
+func (a *administrator) AddServer(id, addr string, voter bool) error {
+	a.servers = append(a.servers, id)
+	return nil
+}
+
+func (a *administrator) RemoveServer(id string) error {
+	for i, srv := range a.servers {
+		if srv == id {
+			a.servers = append(a.servers[:i], a.servers[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (a *administrator) TransferLeadership(id, addr string) error {
+	a.leader = id
+	return nil
+}
+
+func (a *administrator) Stats() map[string]string {
+	return map[string]string{"state": "Follower"}
+}
+
+func (a *administrator) Snapshot() error {
+	a.snapshots++
+	return nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
+)
+
+/*
+gRPC 와 HTTP 를 같은 포트에서 TLS 로 받으려면 TLS 를 먼저 끝낸 뒤에야 둘을 구분할 수 있다.
+tls.NewListener 로 TLS 를 끝낸 연결을 gRPC 서버에 넘길 때 이 인증 정보를 쓰면,
+TLS 를 다시 하지 않고 연결의 TLS 상태를 peer 정보로 넘기므로 authenticate 가 그대로 동작한다.
+*/
+func TLSTerminatedCredentials() credentials.TransportCredentials {
+	return tlsTerminatedCreds{}
+}
+
+type tlsTerminatedCreds struct{}
+
+func (tlsTerminatedCreds) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("server: tls terminated credentials are server only")
+}
+
+func (tlsTerminatedCreds) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tc := tlsConn(conn)
+	if tc == nil {
+		return nil, nil, errors.New("server: connection is not tls")
+	}
+	if err := tc.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	return conn, credentials.TLSInfo{
+		State:          tc.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (tlsTerminatedCreds) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2"}
+}
+
+func (c tlsTerminatedCreds) Clone() credentials.TransportCredentials { return c }
+
+func (tlsTerminatedCreds) OverrideServerName(string) error { return nil }
+
+// cmux 가 감싼 연결에서 *tls.Conn 을 꺼낸다.
+func tlsConn(c net.Conn) *tls.Conn {
+	for {
+		switch conn := c.(type) {
+		case *tls.Conn:
+			return conn
+		case *cmux.MuxConn:
+			c = conn.Conn
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	api "github.com/jhkim988/proglog/api/v1"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+/*
+WebSocket 으로 로그를 구독한다. 브라우저 대시보드에서 로그를 실시간으로 볼 때 쓴다.
+
+	GET /v1/stream?offset=0&window=1000  (topic, read_committed, max_records, heartbeat_interval 쿼리도 받는다.)
+
+서버는 메시지마다 텍스트 프레임 하나를 보낸다.
+
+	{"type": "record", "record": {"value": "aGVsbG8=", "offset": "0", ...}}
+	{"type": "heartbeat"}
+	{"type": "error", "code": "PermissionDenied", "message": "..."}
+
+클라이언트는 처리한 레코드의 오프셋을 보낸다. commit 도 ack 와 같다. (서버는 컨슈머 오프셋을 저장하지 않는다.)
+
+	{"type": "ack", "offset": 10}
+
+ack 하지 않은 레코드가 window 개가 되면 ack 를 받을 때까지 더 보내지 않는다.
+권한은 gRPC 와 같이 consume 으로 확인하고, 연결을 올리기 전에 확인하므로 HTTP 상태 코드로 거절한다.
+*/
+func (s *httpServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	req := &api.ConsumeRequest{
+		HeartbeatInterval: durationpb.New(defaultSSEHeartbeatInterval),
+	}
+	if err := parseConsumeQuery(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	window := uint64(defaultWebSocketWindow)
+	if v := r.URL.Query().Get("window"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil || n == 0 {
+			writeHTTPError(w, status.Errorf(codes.InvalidArgument, "invalid window: %q", v))
+			return
+		}
+		window = n
+	}
+	if err := s.grpc.authorize(r.Context(), topicObject(req.Topic), consumeAction); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if err := admitQuota(r.Context(), s.grpc.Quotas, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 가 에러 응답을 보냈다.
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxWebSocketMessageBytes)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream := &wsStream{
+		ctx:      ctx,
+		conn:     conn,
+		window:   window,
+		acked:    req.Offset,
+		acks:     make(chan struct{}, 1),
+		draining: s.grpc.Drainer.Draining(),
+	}
+	go func() {
+		// 클라이언트가 연결을 끊으면 읽기가 실패하므로 스트림도 끝낸다.
+		stream.readAcks()
+		cancel()
+	}()
+
+	err = s.grpc.consumeStream(req, withConsumeQuota(s.grpc.Quotas, stream))
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		body := httpError(err)
+		stream.write(wsServerMessage{Type: "error", Code: body.Code, Message: body.Message})
+		s.logger.Debug("websocket stream ended", zap.Error(err))
+	}
+	conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(webSocketWriteTimeout))
+}
+
+const (
+	defaultWebSocketWindow   = 1000
+	maxWebSocketMessageBytes = 4096
+	webSocketWriteTimeout    = 10 * time.Second
+)
+
+type wsServerMessage struct {
+	Type    string          `json:"type"`
+	Record  json.RawMessage `json:"record,omitempty"`
+	Code    string          `json:"code,omitempty"`
+	Message string          `json:"message,omitempty"`
+}
+
+type wsClientMessage struct {
+	Type   string `json:"type"`
+	Offset uint64 `json:"offset"`
+}
+
+/*
+ConsumeStream 의 응답을 WebSocket 프레임으로 쓴다.
+Send 는 ConsumeStream 고루틴에서만 부르고, readAcks 는 쓰지 않으므로 쓰기는 한 고루틴에서만 한다.
+*/
+type wsStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	conn   *websocket.Conn
+	window uint64
+	acks   chan struct{}
+	// 닫히면 window 가 찰 때 ack 를 기다리지 않고 끝낸다.
+	draining <-chan struct{}
+
+	mu    sync.Mutex
+	acked uint64 // ack 한 마지막 오프셋 + 1
+}
+
+func (s *wsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *wsStream) Send(res *api.ConsumeResponse) error {
+	if len(res.Records) == 0 {
+		return s.write(wsServerMessage{Type: "heartbeat"})
+	}
+	for _, record := range res.Records {
+		if err := s.waitWindow(record.Offset); err != nil {
+			return err
+		}
+		data, err := protojson.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := s.write(wsServerMessage{Type: "record", Record: data}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offset 이 window 안에 들어올 때까지 기다린다.
+func (s *wsStream) waitWindow(offset uint64) error {
+	for {
+		s.mu.Lock()
+		acked := s.acked
+		s.mu.Unlock()
+		if offset < acked+s.window {
+			return nil
+		}
+		select {
+		case <-s.acks:
+		case <-s.draining:
+			return status.Error(codes.Unavailable, "server is draining")
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+}
+
+func (s *wsStream) readAcks() {
+	for {
+		var msg wsClientMessage
+		if err := s.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Type != "ack" && msg.Type != "commit" {
+			continue
+		}
+		s.mu.Lock()
+		if msg.Offset+1 > s.acked {
+			s.acked = msg.Offset + 1
+		}
+		s.mu.Unlock()
+		select {
+		case s.acks <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *wsStream) write(msg wsServerMessage) error {
+	s.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	return s.conn.WriteJSON(msg)
+}
+
+/*
+브라우저는 다른 사이트의 페이지에서도 클라이언트 인증서를 붙여 연결하므로, 인증서로 인증하면 같은 출처만 허용한다.
+토큰은 페이지가 직접 붙여야 하므로 출처를 확인하지 않는다.
+*/
+func checkOrigin(r *http.Request) bool {
+	if bearerToken(r) != "" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
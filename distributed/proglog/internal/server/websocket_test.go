@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	api "github.com/jhkim988/proglog/api/v1"
+	"github.com/jhkim988/proglog/internal/auth"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestWebSocketStream(t *testing.T) {
+	auditor := &recordingAuditor{}
+	srv, rootClient, nobodyClient := setupHTTPTest(t, func(c *Config) {
+		c.Auditor = auditor
+	})
+	for _, value := range []string{"Zmlyc3Q=", "c2Vjb25k", "dGhpcmQ="} {
+		res, err := rootClient.Post(srv.URL+"/v1/records", "application/json",
+			strings.NewReader(fmt.Sprintf(`{"record": {"value": %q}}`, value)))
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	// 권한이 없으면 연결을 올리지 않는다.
+	_, res, err := dialWebSocket(srv.URL+"/v1/stream", nobodyClient, nil)
+	require.Equal(t, websocket.ErrBadHandshake, err)
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	conn, _, err := dialWebSocket(srv.URL+"/v1/stream?offset=0&window=2", rootClient, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	records := make(chan *api.Record)
+	go func() {
+		defer close(records)
+		for {
+			var msg wsServerMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type != "record" {
+				continue
+			}
+			record := &api.Record{}
+			if err := protojson.Unmarshal(msg.Record, record); err != nil {
+				return
+			}
+			records <- record
+		}
+	}()
+
+	for _, want := range []string{"first", "second"} {
+		record := <-records
+		require.Equal(t, want, string(record.Value))
+	}
+
+	// ack 하지 않은 레코드가 window 개면 더 보내지 않는다.
+	select {
+	case record := <-records:
+		t.Fatalf("received record %d before ack", record.Offset)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, conn.WriteJSON(wsClientMessage{Type: "ack", Offset: 0}))
+	record := <-records
+	require.Equal(t, "third", string(record.Value))
+	require.Equal(t, uint64(2), record.Offset)
+
+	// 연결을 올리기 전에 한 번만 확인하고 남긴다.
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+	var consumes []bool
+	for _, event := range auditor.events {
+		if event.Action == consumeAction {
+			consumes = append(consumes, event.Allowed)
+		}
+	}
+	require.Equal(t, []bool{false, true}, consumes)
+}
+
+func TestWebSocketBearerToken(t *testing.T) {
+	srv, _, nobodyClient := setupHTTPTest(t, func(c *Config) {
+		c.Authenticator = auth.Chain(auth.NewAPIKeysFromMap(map[string]string{"secret": "root"}), auth.MTLS())
+	})
+
+	// 토큰의 subject 로 권한을 확인한다.
+	conn, _, err := dialWebSocket(srv.URL+"/v1/stream", nobodyClient, http.Header{
+		"Authorization": []string{"Bearer secret"},
+	})
+	require.NoError(t, err)
+	conn.Close()
+
+	conn, _, err = dialWebSocket(srv.URL+"/v1/stream?access_token=secret", nobodyClient, nil)
+	require.NoError(t, err)
+	conn.Close()
+
+	// 다른 경로에서는 access_token 쿼리를 받지 않으므로 인증서의 subject 로 확인한다.
+	res, err := nobodyClient.Get(srv.URL + "/v1/offsets?access_token=secret")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	_, res, err = dialWebSocket(srv.URL+"/v1/stream", nobodyClient, http.Header{
+		"Authorization": []string{"Bearer wrong"},
+	})
+	require.Error(t, err)
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestWebSocketDrain(t *testing.T) {
+	drainer := NewDrainer()
+	srv, rootClient, _ := setupHTTPTest(t, func(c *Config) {
+		c.Drainer = drainer
+	})
+	for _, value := range []string{"Zmlyc3Q=", "c2Vjb25k"} {
+		res, err := rootClient.Post(srv.URL+"/v1/records", "application/json",
+			strings.NewReader(fmt.Sprintf(`{"record": {"value": %q}}`, value)))
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	conn, _, err := dialWebSocket(srv.URL+"/v1/stream?offset=0&window=1", rootClient, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var msg wsServerMessage
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, "record", msg.Type)
+
+	// ack 를 기다리는 스트림도 drain 을 시작하면 끝나므로 timeout 까지 기다리지 않는다.
+	require.NoError(t, drainer.Drain(time.Second))
+	require.NoError(t, conn.ReadJSON(&msg))
+	require.Equal(t, "error", msg.Type)
+	require.Equal(t, "Unavailable", msg.Code)
+}
+
+func dialWebSocket(url string, client *http.Client, header http.Header) (*websocket.Conn, *http.Response, error) {
+	dialer := websocket.Dialer{
+		TLSClientConfig: client.Transport.(*http.Transport).TLSClientConfig,
+	}
+	return dialer.Dial("wss"+strings.TrimPrefix(url, "https"), header)
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+)
+
+// span 하나를 JSON 한 줄로 쓴다. 테스트나 로컬에서 확인할 때 쓴다.
+type JSONExporter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer // newExporter 가 연 파일
+}
+
+var _ trace.Exporter = (*JSONExporter)(nil)
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{w: w}
+}
+
+type jsonSpan struct {
+	TraceID         string                 `json:"trace_id"`
+	SpanID          string                 `json:"span_id"`
+	ParentSpanID    string                 `json:"parent_span_id,omitempty"`
+	HasRemoteParent bool                   `json:"has_remote_parent,omitempty"`
+	Name            string                 `json:"name"`
+	Kind            string                 `json:"kind,omitempty"`
+	StartTime       time.Time              `json:"start_time"`
+	EndTime         time.Time              `json:"end_time"`
+	DurationMS      float64                `json:"duration_ms"`
+	Attributes      map[string]interface{} `json:"attributes,omitempty"`
+	Annotations     []jsonAnnotation       `json:"annotations,omitempty"`
+	StatusCode      int32                  `json:"status_code,omitempty"`
+	StatusMessage   string                 `json:"status_message,omitempty"`
+}
+
+type jsonAnnotation struct {
+	Time       time.Time              `json:"time"`
+	Message    string                 `json:"message"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+func (e *JSONExporter) ExportSpan(sd *trace.SpanData) {
+	span := jsonSpan{
+		TraceID:         sd.TraceID.String(),
+		SpanID:          sd.SpanID.String(),
+		HasRemoteParent: sd.HasRemoteParent,
+		Name:            sd.Name,
+		Kind:            spanKind(sd.SpanKind),
+		StartTime:       sd.StartTime,
+		EndTime:         sd.EndTime,
+		DurationMS:      float64(sd.EndTime.Sub(sd.StartTime)) / float64(time.Millisecond),
+		Attributes:      sd.Attributes,
+		StatusCode:      sd.Code,
+		StatusMessage:   sd.Message,
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanID = sd.ParentSpanID.String()
+	}
+	for _, a := range sd.Annotations {
+		span.Annotations = append(span.Annotations, jsonAnnotation{
+			Time:       a.Time,
+			Message:    a.Message,
+			Attributes: a.Attributes,
+		})
+	}
+	b, err := json.Marshal(span)
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, _ = e.w.Write(append(b, '\n'))
+}
+
+// Config.File 에 쓰는 exporter 면 파일을 닫는다. NewJSONExporter 에 넘긴 Writer 는 닫지 않는다.
+func (e *JSONExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+func spanKind(kind int) string {
+	switch kind {
+	case trace.SpanKindServer:
+		return "server"
+	case trace.SpanKindClient:
+		return "client"
+	}
+	return ""
+}
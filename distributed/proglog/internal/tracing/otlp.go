@@ -0,0 +1,259 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opencensus.io/trace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultOTLPBatchSize     = 256
+	defaultOTLPBufferSize    = 2048
+	defaultOTLPFlushInterval = time.Second
+	otlpExportTimeout        = 10 * time.Second
+)
+
+type OTLPConfig struct {
+	// OTLP/gRPC 수신 주소 (예: localhost:4317)
+	Endpoint string
+	Insecure bool
+	// 리소스의 service.name
+	ServiceName string
+	// 한 번에 보내는 최대 span 수, 기본값 256
+	BatchSize int
+	// 보내기 전에 쌓아둘 수 있는 span 수, 가득 차면 버린다. 기본값 2048
+	BufferSize int
+	// 배치가 차지 않았을 때 보내는 간격, 기본값 1s
+	FlushInterval time.Duration
+}
+
+/*
+OpenCensus span 을 OTLP 로 바꿔서 OTLP/gRPC 로 보낸다. (OpenTelemetry Collector, Jaeger, Tempo 등)
+ExportSpan 은 span 을 끝낸 고루틴에서 호출하므로 버퍼에 넣기만 하고, 고루틴 하나가 배치로 보낸다.
+*/
+type OTLPExporter struct {
+	config   OTLPConfig
+	client   otlptrace.Client
+	resource *resourcepb.Resource
+	logger   *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	spans  chan *trace.SpanData
+	done   chan struct{}
+}
+
+var _ trace.Exporter = (*OTLPExporter)(nil)
+
+func NewOTLPExporter(config OTLPConfig) (*OTLPExporter, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = defaultOTLPBatchSize
+	}
+	if config.BufferSize == 0 {
+		config.BufferSize = defaultOTLPBufferSize
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = defaultOTLPFlushInterval
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	// 연결을 기다리지 않으므로 수신 주소가 아직 떠 있지 않아도 된다.
+	client := otlptracegrpc.NewClient(opts...)
+	if err := client.Start(context.Background()); err != nil {
+		return nil, err
+	}
+	e := &OTLPExporter{
+		config: config,
+		client: client,
+		resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{{
+				Key:   string(semconv.ServiceNameKey),
+				Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: config.ServiceName}},
+			}},
+		},
+		logger: zap.L().Named("tracing"),
+		spans:  make(chan *trace.SpanData, config.BufferSize),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *OTLPExporter) ExportSpan(sd *trace.SpanData) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- sd:
+	default:
+		e.logger.Debug("dropped span, buffer is full", zap.String("name", sd.Name))
+	}
+}
+
+// 남은 span 을 보내고 연결을 닫는다.
+func (e *OTLPExporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+	e.mu.Unlock()
+	<-e.done
+
+	ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+	defer cancel()
+	return e.client.Stop(ctx)
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*tracepb.Span, 0, e.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), otlpExportTimeout)
+		if err := e.client.UploadTraces(ctx, e.resourceSpans(batch)); err != nil {
+			e.logger.Warn("failed to export spans", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		cancel()
+		// UploadTraces 가 돌아오면 span 을 다시 쓰지 않으므로 배열을 재사용한다.
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case sd, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, convert(sd))
+			if len(batch) >= e.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// 이 노드의 span 을 하나의 리소스로 묶는다.
+func (e *OTLPExporter) resourceSpans(spans []*tracepb.Span) []*tracepb.ResourceSpans {
+	return []*tracepb.ResourceSpans{{
+		Resource: e.resource,
+		ScopeSpans: []*tracepb.ScopeSpans{{
+			Scope: &commonpb.InstrumentationScope{Name: "go.opencensus.io"},
+			Spans: spans,
+		}},
+		SchemaUrl: semconv.SchemaURL,
+	}}
+}
+
+// OpenCensus 와 OTLP 는 trace/span ID 형식이 같으므로 그대로 옮긴다.
+func convert(sd *trace.SpanData) *tracepb.Span {
+	span := &tracepb.Span{
+		TraceId:                sd.TraceID[:],
+		SpanId:                 sd.SpanID[:],
+		Name:                   sd.Name,
+		Kind:                   otlpSpanKind(sd.SpanKind),
+		StartTimeUnixNano:      uint64(sd.StartTime.UnixNano()),
+		EndTimeUnixNano:        uint64(sd.EndTime.UnixNano()),
+		Attributes:             attributes(sd.Attributes),
+		DroppedAttributesCount: uint32(sd.DroppedAttributeCount),
+		DroppedEventsCount:     uint32(sd.DroppedAnnotationCount + sd.DroppedMessageEventCount),
+		DroppedLinksCount:      uint32(sd.DroppedLinkCount),
+	}
+	if sd.ParentSpanID != (trace.SpanID{}) {
+		span.ParentSpanId = sd.ParentSpanID[:]
+	}
+	for _, a := range sd.Annotations {
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			Name:         a.Message,
+			TimeUnixNano: uint64(a.Time.UnixNano()),
+			Attributes:   attributes(a.Attributes),
+		})
+	}
+	for _, m := range sd.MessageEvents {
+		name := "message"
+		switch m.EventType {
+		case trace.MessageEventTypeSent:
+			name = "message sent"
+		case trace.MessageEventTypeRecv:
+			name = "message received"
+		}
+		span.Events = append(span.Events, &tracepb.Span_Event{
+			Name:         name,
+			TimeUnixNano: uint64(m.Time.UnixNano()),
+			Attributes: []*commonpb.KeyValue{
+				intAttribute("message.id", m.MessageID),
+				intAttribute("message.uncompressed_size", m.UncompressedByteSize),
+			},
+		})
+	}
+	for _, l := range sd.Links {
+		traceID, spanID := l.TraceID, l.SpanID
+		span.Links = append(span.Links, &tracepb.Span_Link{
+			TraceId:    traceID[:],
+			SpanId:     spanID[:],
+			Attributes: attributes(l.Attributes),
+		})
+	}
+	// OpenCensus 는 gRPC 상태 코드를 쓴다. 0 이 OK 다.
+	if sd.Code != 0 {
+		span.Status = &tracepb.Status{Code: tracepb.Status_STATUS_CODE_ERROR, Message: sd.Message}
+		span.Attributes = append(span.Attributes, intAttribute("opencensus.status_code", int64(sd.Code)))
+	}
+	return span
+}
+
+func otlpSpanKind(kind int) tracepb.Span_SpanKind {
+	switch kind {
+	case trace.SpanKindServer:
+		return tracepb.Span_SPAN_KIND_SERVER
+	case trace.SpanKindClient:
+		return tracepb.Span_SPAN_KIND_CLIENT
+	}
+	return tracepb.Span_SPAN_KIND_INTERNAL
+}
+
+// OpenCensus 속성 값은 string, bool, int64, float64 중 하나다.
+func attributes(attrs map[string]interface{}) []*commonpb.KeyValue {
+	kvs := make([]*commonpb.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value *commonpb.AnyValue
+		switch v := v.(type) {
+		case string:
+			value = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+		case bool:
+			value = &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: v}}
+		case int64:
+			value = &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}
+		case float64:
+			value = &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: v}}
+		default:
+			continue
+		}
+		kvs = append(kvs, &commonpb.KeyValue{Key: k, Value: value})
+	}
+	return kvs
+}
+
+func intAttribute(key string, v int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: v}}}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+
+	"go.opencensus.io/trace"
+)
+
+const (
+	ExporterNone   = ""
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+	ExporterOTLP   = "otlp"
+
+	defaultServiceName = "proglog"
+)
+
+/*
+OpenCensus 트레이싱 설정, 프로세스 전체(trace.ApplyConfig, trace.RegisterExporter)에 적용한다.
+ocgrpc 가 요청마다 span 을 시작하므로 샘플링 비율이 곧 기록하는 요청의 비율이다.
+*/
+type Config struct {
+	// 부모가 없는 span 을 기록할 비율, 0 이면 기록하지 않고 1 이면 모두 기록한다.
+	// trace ID 로 결정하므로 같은 trace 는 모든 노드에서 같은 결정을 내린다.
+	SampleRatio float64
+	// 클라이언트가 gRPC 메타데이터로 trace 를 보내면 SampleRatio 대신 클라이언트의 결정을 따른다.
+	ParentBased bool
+	// span 을 보낼 곳, "" (보내지 않음), "stdout", "file", "otlp"
+	Exporter string
+	// Exporter 가 "file" 일 때 span 을 JSON 줄로 덧붙일 파일
+	File string
+	// Exporter 가 "otlp" 일 때 OTLP/gRPC 수신 주소 (예: localhost:4317)
+	OTLPEndpoint string
+	// OTLP 수신 주소에 TLS 없이 연결한다.
+	OTLPInsecure bool
+	// OTLP 리소스의 service.name, 기본값 proglog
+	ServiceName string
+}
+
+// Exporter 가 구현하면 Setup 이 리턴한 함수에서 남은 span 을 보내고 닫는다.
+type closer interface {
+	Close() error
+}
+
+/*
+샘플러를 적용하고 exporter 를 등록한다.
+리턴한 함수는 exporter 를 해제하고 남은 span 을 보낸 뒤 닫는다. 샘플러는 되돌리지 않는다.
+*/
+func Setup(c Config) (func() error, error) {
+	exporter, err := newExporter(c)
+	if err != nil {
+		return nil, err
+	}
+	trace.ApplyConfig(trace.Config{
+		DefaultSampler: Sampler(c.SampleRatio, c.ParentBased),
+	})
+	if exporter == nil {
+		return func() error { return nil }, nil
+	}
+	trace.RegisterExporter(exporter)
+	return func() error {
+		trace.UnregisterExporter(exporter)
+		if closer, ok := exporter.(closer); ok {
+			return closer.Close()
+		}
+		return nil
+	}, nil
+}
+
+func newExporter(c Config) (trace.Exporter, error) {
+	switch c.Exporter {
+	case ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return NewJSONExporter(os.Stdout), nil
+	case ExporterFile:
+		if c.File == "" {
+			return nil, fmt.Errorf("tracing: file exporter needs a file")
+		}
+		f, err := os.OpenFile(c.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return &JSONExporter{w: f, closer: f}, nil
+	case ExporterOTLP:
+		if c.OTLPEndpoint == "" {
+			return nil, fmt.Errorf("tracing: otlp exporter needs an endpoint")
+		}
+		serviceName := c.ServiceName
+		if serviceName == "" {
+			serviceName = defaultServiceName
+		}
+		return NewOTLPExporter(OTLPConfig{
+			Endpoint:    c.OTLPEndpoint,
+			Insecure:    c.OTLPInsecure,
+			ServiceName: serviceName,
+		})
+	}
+	return nil, fmt.Errorf("tracing: unknown exporter %q", c.Exporter)
+}
+
+/*
+부모가 없으면 trace ID 의 앞 8바이트로 ratio 만큼 기록한다.
+parentBased 면 원격 부모의 결정을 따른다. (같은 프로세스 안의 부모는 OpenCensus 가 항상 따른다.)
+trace.ProbabilitySampler 는 부모가 기록하면 항상 기록하므로 parentBased 가 아닐 때는 쓰지 않는다.
+*/
+func Sampler(ratio float64, parentBased bool) trace.Sampler {
+	var bound uint64
+	switch {
+	case ratio >= 1:
+		bound = math.MaxUint64
+	case ratio > 0:
+		bound = uint64(ratio * (1 << 63))
+	}
+	return func(p trace.SamplingParameters) trace.SamplingDecision {
+		if parentBased && p.ParentContext != (trace.SpanContext{}) {
+			return trace.SamplingDecision{Sample: p.ParentContext.IsSampled()}
+		}
+		x := binary.BigEndian.Uint64(p.TraceID[0:8]) >> 1
+		return trace.SamplingDecision{Sample: bound == math.MaxUint64 || x < bound}
+	}
+}
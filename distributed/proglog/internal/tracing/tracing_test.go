@@ -0,0 +1,192 @@
+package tracing_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jhkim988/proglog/internal/tracing"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+)
+
+func TestSampler(t *testing.T) {
+	low := trace.TraceID{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01}
+	high := trace.TraceID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	sampledParent := trace.SpanContext{TraceID: high, SpanID: trace.SpanID{1}, TraceOptions: 1}
+	unsampledParent := trace.SpanContext{TraceID: low, SpanID: trace.SpanID{1}}
+
+	for scenario, tc := range map[string]struct {
+		ratio       float64
+		parentBased bool
+		params      trace.SamplingParameters
+		want        bool
+	}{
+		"zero ratio never samples": {
+			ratio:  0,
+			params: trace.SamplingParameters{TraceID: low},
+			want:   false,
+		},
+		"full ratio always samples": {
+			ratio:  1,
+			params: trace.SamplingParameters{TraceID: high},
+			want:   true,
+		},
+		"ratio samples low trace ids": {
+			ratio:  0.5,
+			params: trace.SamplingParameters{TraceID: low},
+			want:   true,
+		},
+		"ratio skips high trace ids": {
+			ratio:  0.5,
+			params: trace.SamplingParameters{TraceID: high},
+			want:   false,
+		},
+		"parent based follows sampled parent": {
+			ratio:       0,
+			parentBased: true,
+			params:      trace.SamplingParameters{TraceID: high, ParentContext: sampledParent},
+			want:        true,
+		},
+		"parent based follows unsampled parent": {
+			ratio:       1,
+			parentBased: true,
+			params:      trace.SamplingParameters{TraceID: low, ParentContext: unsampledParent},
+			want:        false,
+		},
+		"ratio ignores remote parent": {
+			ratio:  0,
+			params: trace.SamplingParameters{TraceID: high, ParentContext: sampledParent},
+			want:   false,
+		},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			sampler := tracing.Sampler(tc.ratio, tc.parentBased)
+			require.Equal(t, tc.want, sampler(tc.params).Sample)
+		})
+	}
+}
+
+func TestSetupFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	stop, err := tracing.Setup(tracing.Config{
+		SampleRatio: 1,
+		Exporter:    tracing.ExporterFile,
+		File:        file,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { trace.ApplyConfig(trace.Config{DefaultSampler: trace.NeverSample()}) })
+
+	ctx, parent := trace.StartSpan(context.Background(), "parent")
+	_, child := trace.StartSpan(ctx, "child")
+	child.AddAttributes(trace.StringAttribute("key", "value"))
+	child.End()
+	parent.End()
+	require.NoError(t, stop())
+
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	spans := make(map[string]map[string]interface{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var span map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &span))
+		spans[span["name"].(string)] = span
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, spans, 2)
+	require.Equal(t, spans["parent"]["trace_id"], spans["child"]["trace_id"])
+	require.Equal(t, spans["parent"]["span_id"], spans["child"]["parent_span_id"])
+	require.Equal(t, map[string]interface{}{"key": "value"}, spans["child"]["attributes"])
+}
+
+func TestSetupInvalid(t *testing.T) {
+	for scenario, c := range map[string]tracing.Config{
+		"unknown exporter":      {Exporter: "zipkin"},
+		"file without path":     {Exporter: tracing.ExporterFile},
+		"otlp without endpoint": {Exporter: tracing.ExporterOTLP},
+	} {
+		t.Run(scenario, func(t *testing.T) {
+			_, err := tracing.Setup(c)
+			require.Error(t, err)
+		})
+	}
+}
+
+type collector struct {
+	coltracepb.UnimplementedTraceServiceServer
+
+	mu    sync.Mutex
+	spans []*tracepb.Span
+	names []string
+}
+
+func (c *collector) Export(ctx context.Context, req *coltracepb.ExportTraceServiceRequest) (*coltracepb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.names = append(c.names, attr.Value.GetStringValue())
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+	return &coltracepb.ExportTraceServiceResponse{}, nil
+}
+
+func TestOTLPExporter(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	c := &collector{}
+	srv := grpc.NewServer()
+	coltracepb.RegisterTraceServiceServer(srv, c)
+	go func() { _ = srv.Serve(ln) }()
+	t.Cleanup(srv.Stop)
+
+	exporter, err := tracing.NewOTLPExporter(tracing.OTLPConfig{
+		Endpoint:      ln.Addr().String(),
+		Insecure:      true,
+		ServiceName:   "proglog-test",
+		FlushInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	trace.RegisterExporter(exporter)
+
+	ctx, parent := trace.StartSpan(context.Background(), "parent", trace.WithSampler(trace.AlwaysSample()))
+	_, child := trace.StartSpan(ctx, "child", trace.WithSpanKind(trace.SpanKindServer))
+	child.AddAttributes(trace.Int64Attribute("raft.index", 7))
+	child.SetStatus(trace.Status{Code: trace.StatusCodeUnavailable, Message: "no leader"})
+	child.End()
+	parent.End()
+	trace.UnregisterExporter(exporter)
+	require.NoError(t, exporter.Close())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.Len(t, c.spans, 2)
+	require.Contains(t, c.names, "proglog-test")
+
+	got, want := c.spans[0], child.SpanContext()
+	require.Equal(t, "child", got.Name)
+	require.Equal(t, want.TraceID[:], got.TraceId)
+	require.Equal(t, want.SpanID[:], got.SpanId)
+	parentID := parent.SpanContext().SpanID
+	require.Equal(t, parentID[:], got.ParentSpanId)
+	require.Equal(t, tracepb.Span_SPAN_KIND_SERVER, got.Kind)
+	require.Equal(t, tracepb.Status_STATUS_CODE_ERROR, got.Status.Code)
+	require.Equal(t, "no leader", got.Status.Message)
+}